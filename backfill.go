@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/aleveille/lagrande/generator"
+	log "github.com/sirupsen/logrus"
+)
+
+// How often the progress of the backfill is printed
+const backfillProgressInterval = 10 * time.Second
+
+// backfill loads history quickly: instead of ticking every interval, each worker walks a virtual clock from start to
+// end by step, the generators reading the virtual time instead of the wall clock, and publishes as fast as the targets
+// accept. Workers exit once they reach end.
+type backfill struct {
+	start time.Time
+	end   time.Time
+	step  time.Duration
+
+	// Ticks generated and workers done, across all workers, incremented atomically
+	ticksDone   int64
+	workersDone int64
+}
+
+// Set with -backfillStart, nil otherwise
+var backfillRun *backfill
+
+// parseBackfillTime parses an RFC 3339 time or a Go duration before now (eg: 168h for a week ago)
+func parseBackfillTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("'%s' is neither an RFC 3339 time (eg: 2020-01-31T00:00:00Z) nor a >= 0 Go Duration before now (eg: 168h)", value)
+	}
+	return now.Add(-d), nil
+}
+
+// processBackfillConfiguration sets backfillRun from the backfill flags
+func processBackfillConfiguration() error {
+	if mode != "standalone" {
+		return errors.New("Backfill mode is only available in standalone mode")
+	}
+	if len(stages) > 0 || len(loadStages) > 0 || len(controlListen) > 0 || churnIntervalDuration > 0 {
+		return errors.New("Backfill mode runs -workers workers until the end of the backfill, it can't be used with load stages, the control API or churn")
+	}
+
+	now := time.Now()
+	b := &backfill{end: now, step: intervalDuration}
+	var err error
+	b.start, err = parseBackfillTime(backfillStart, now)
+	if err != nil {
+		return fmt.Errorf("Invalid backfillStart specified: %s", err)
+	}
+	if len(backfillEnd) > 0 {
+		b.end, err = parseBackfillTime(backfillEnd, now)
+		if err != nil {
+			return fmt.Errorf("Invalid backfillEnd specified: %s", err)
+		}
+	}
+	if !b.end.After(b.start) {
+		return errors.New("Invalid backfillStart and backfillEnd specified. Make sure the start is before the end")
+	}
+	if len(backfillStep) > 0 {
+		b.step, err = time.ParseDuration(backfillStep)
+		if err != nil || b.step <= 0 {
+			return errors.New("Invalid backfillStep specified. Make sure it's a duration greater than 0 and parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
+		}
+	}
+	if timestampsMode == "aligned" {
+		b.start = b.start.Truncate(b.step)
+	}
+
+	backfillRun = b
+	return nil
+}
+
+func (b *backfill) String() string {
+	return fmt.Sprintf("from %s to %s by steps of %s", b.start.Format(time.RFC3339), b.end.Format(time.RFC3339), b.step)
+}
+
+// ticks returns the number of ticks each worker generates
+func (b *backfill) ticks() int64 {
+	return int64(b.end.Sub(b.start)/b.step) + 1
+}
+
+// runWorker moves the clock of the generators of a worker from start to end, calling emit at every step
+func (b *backfill) runWorker(workerGeneratorsArr []generator.Generator, emit func(now time.Time), stopChan <-chan bool) {
+	clock := generator.NewVirtualClock(b.start)
+	for _, gen := range workerGeneratorsArr {
+		generator.SetClock(gen, withClockOffset(clock))
+	}
+
+	for now := b.start; !now.After(b.end); now = now.Add(b.step) {
+		select {
+		case <-stopChan:
+			return
+		default:
+		}
+
+		clock.Set(now)
+		emit(now)
+		atomic.AddInt64(&b.ticksDone, 1)
+	}
+	atomic.AddInt64(&b.workersDone, 1)
+}
+
+// run starts the workers and prints the progress of the backfill until the workers are done or lagrande is
+// interrupted
+func (b *backfill) run(pool workersScaler, stageChan chan<- stageEvent) {
+	stage := "backfill"
+	stageChan <- stageEvent{name: stage}
+	start := time.Now()
+	pool.scaleTo(workersCount)
+
+	interruptChan := make(chan os.Signal, 1)
+	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interruptChan)
+
+	doneTicker := time.NewTicker(100 * time.Millisecond)
+	defer doneTicker.Stop()
+	progressTicker := time.NewTicker(backfillProgressInterval)
+	defer progressTicker.Stop()
+
+	for atomic.LoadInt64(&b.workersDone) < int64(workersCount) {
+		select {
+		case <-doneTicker.C:
+		case <-progressTicker.C:
+			b.printProgress(time.Since(start))
+		case sig := <-interruptChan:
+			log.Infof("Received %s, stopping workers", sig)
+			b.printProgress(time.Since(start))
+			pool.stopAll()
+			stageChan <- stageEvent{name: stage, previousWorkers: workersCount}
+			return
+		}
+	}
+
+	b.printProgress(time.Since(start))
+	log.Info("Backfill completed, stopping workers")
+	pool.stopAll()
+	stageChan <- stageEvent{name: stage, previousWorkers: workersCount}
+}
+
+// printProgress prints how much of the backfill is done and how fast the simulated time goes
+func (b *backfill) printProgress(elapsed time.Duration) {
+	ticksDone := atomic.LoadInt64(&b.ticksDone)
+	total := b.ticks() * int64(workersCount)
+	if total == 0 || elapsed <= 0 {
+		return
+	}
+
+	// The workers go at roughly the same pace, the simulated time covered is their average
+	done := float64(ticksDone) / float64(total)
+	covered := time.Duration(done * float64(b.end.Sub(b.start)))
+	perSecond := time.Duration(float64(covered) / elapsed.Seconds())
+	log.Infof("Backfill: %.2f%% done, %s of simulated time covered in %s (%s of simulated time per second)\n", done*100, covered.Round(time.Second), elapsed.Round(time.Second), perSecond.Round(time.Second))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+
+	"github.com/aleveille/lagrande/generator"
+)
+
+func TestParseBackfillTime(t *testing.T) {
+	now := time.Date(2020, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Time
+		err      string
+	}{
+		{value: "2020-01-31T00:00:00Z", expected: time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{value: "2020-01-31T02:00:00+02:00", expected: time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)},
+		{value: "168h", expected: now.Add(-168 * time.Hour)},
+		{value: "0s", expected: now},
+		{value: "-1h", err: "'-1h' is neither an RFC 3339 time"},
+		{value: "2020-01-31", err: "'2020-01-31' is neither an RFC 3339 time"},
+		{value: "yesterday", err: "'yesterday' is neither an RFC 3339 time"},
+	}
+
+	for _, test := range tests {
+		parsed, err := parseBackfillTime(test.value, now)
+		if len(test.err) > 0 {
+			assert.ErrorContains(t, err, test.err, "value %s", test.value)
+			continue
+		}
+		assert.NilError(t, err, "value %s", test.value)
+		assert.Assert(t, parsed.Equal(test.expected), "value %s: %s", test.value, parsed)
+	}
+}
+
+func TestProcessBackfillConfiguration(t *testing.T) {
+	defer func(previousMode, previousStages, previousControlListen, previousStart, previousEnd, previousStep, previousTimestamps string, previousLoadStages []loadStage, previousChurn, previousInterval time.Duration, previousRun *backfill) {
+		mode = previousMode
+		stages = previousStages
+		controlListen = previousControlListen
+		backfillStart = previousStart
+		backfillEnd = previousEnd
+		backfillStep = previousStep
+		timestampsMode = previousTimestamps
+		loadStages = previousLoadStages
+		churnIntervalDuration = previousChurn
+		intervalDuration = previousInterval
+		backfillRun = previousRun
+	}(mode, stages, controlListen, backfillStart, backfillEnd, backfillStep, timestampsMode, loadStages, churnIntervalDuration, intervalDuration, backfillRun)
+
+	start := time.Date(2020, 1, 31, 0, 0, 7, 0, time.UTC)
+	end := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		mode       string
+		stages     string
+		loadStages []loadStage
+		control    string
+		churn      time.Duration
+		start      string
+		end        string
+		step       string
+		timestamps string
+		expected   backfill
+		err        string
+	}{
+		{start: "2020-01-31T00:00:07Z", end: "2020-02-01T00:00:00Z", expected: backfill{start: start, end: end, step: 10 * time.Second}},
+		{start: "2020-01-31T00:00:07Z", end: "2020-02-01T00:00:00Z", step: "1m", expected: backfill{start: start, end: end, step: time.Minute}},
+		{start: "2020-01-31T00:00:07Z", end: "2020-02-01T00:00:00Z", timestamps: "batch", expected: backfill{start: start, end: end, step: 10 * time.Second}},
+		// With aligned timestamps, the start is truncated to a multiple of the step
+		{start: "2020-01-31T00:00:07Z", end: "2020-02-01T00:00:00Z", timestamps: "aligned", expected: backfill{start: start.Add(-7 * time.Second), end: end, step: 10 * time.Second}},
+		{start: "2020-01-31T00:00:07Z", end: "2020-02-01T00:00:00Z", step: "1m", timestamps: "aligned", expected: backfill{start: start.Add(-7 * time.Second), end: end, step: time.Minute}},
+		{mode: "coordinator", start: "1h", err: "only available in standalone mode"},
+		{mode: "agent", start: "1h", err: "only available in standalone mode"},
+		{stages: "hold:1m", start: "1h", err: "it can't be used with load stages, the control API or churn"},
+		{loadStages: []loadStage{{kind: "hold", duration: time.Minute}}, start: "1h", err: "it can't be used with load stages"},
+		{control: "127.0.0.1:7071", start: "1h", err: "it can't be used with load stages"},
+		{churn: time.Minute, start: "1h", err: "it can't be used with load stages"},
+		{start: "last week", err: "Invalid backfillStart specified: 'last week' is neither"},
+		{start: "1h", end: "soon", err: "Invalid backfillEnd specified: 'soon' is neither"},
+		{start: "1h", end: "2h", err: "Make sure the start is before the end"},
+		{start: "1h", end: "1h", err: "Make sure the start is before the end"},
+		{start: "1h", step: "0s", err: "Invalid backfillStep specified"},
+		{start: "1h", step: "often", err: "Invalid backfillStep specified"},
+	}
+
+	for _, test := range tests {
+		mode = "standalone"
+		if len(test.mode) > 0 {
+			mode = test.mode
+		}
+		timestampsMode = "generation"
+		if len(test.timestamps) > 0 {
+			timestampsMode = test.timestamps
+		}
+		stages = test.stages
+		loadStages = test.loadStages
+		controlListen = test.control
+		churnIntervalDuration = test.churn
+		backfillStart = test.start
+		backfillEnd = test.end
+		backfillStep = test.step
+		intervalDuration = 10 * time.Second
+		backfillRun = nil
+
+		err := processBackfillConfiguration()
+		if len(test.err) > 0 {
+			assert.ErrorContains(t, err, test.err, "%+v", test)
+			assert.Assert(t, backfillRun == nil)
+			continue
+		}
+		assert.NilError(t, err, "%+v", test)
+		assert.Assert(t, backfillRun.start.Equal(test.expected.start), "%+v: start %s", test, backfillRun.start)
+		assert.Assert(t, backfillRun.end.Equal(test.expected.end), "%+v: end %s", test, backfillRun.end)
+		assert.Equal(t, test.expected.step, backfillRun.step)
+	}
+
+	// Durations are before now, the end defaults to now
+	mode, stages, loadStages, controlListen, churnIntervalDuration = "standalone", "", nil, "", 0
+	backfillStart, backfillEnd, backfillStep, timestampsMode = "2h", "", "", "generation"
+	before := time.Now()
+	assert.NilError(t, processBackfillConfiguration())
+	after := time.Now()
+	assert.Assert(t, !backfillRun.end.Before(before) && !backfillRun.end.After(after))
+	assert.Equal(t, 2*time.Hour, backfillRun.end.Sub(backfillRun.start))
+}
+
+func TestBackfillTicks(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		duration time.Duration
+		step     time.Duration
+		expected int64
+	}{
+		{duration: time.Minute, step: 10 * time.Second, expected: 7},
+		{duration: 65 * time.Second, step: 10 * time.Second, expected: 7},
+		{duration: 69 * time.Second, step: 10 * time.Second, expected: 7},
+		{duration: 70 * time.Second, step: 10 * time.Second, expected: 8},
+		{duration: time.Second, step: 10 * time.Second, expected: 1},
+		{duration: 168 * time.Hour, step: time.Minute, expected: 168*60 + 1},
+	}
+
+	for _, test := range tests {
+		b := &backfill{start: start, end: start.Add(test.duration), step: test.step}
+		assert.Equal(t, test.expected, b.ticks(), "%s", b)
+	}
+}
+
+func TestBackfillRunWorker(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	b := &backfill{start: start, end: start.Add(time.Minute), step: 10 * time.Second}
+
+	counter, err := generator.NewIntCounterGenerator(generator.CLIConfig{}, nil, nil)
+	assert.NilError(t, err)
+	gens := []generator.Generator{counter.Clone("counter", nil)}
+
+	// Every step from start to end is visited, end included, and the generators read the virtual clock
+	var visited []time.Time
+	b.runWorker(gens, func(now time.Time) {
+		visited = append(visited, now)
+		assert.Equal(t, now.UnixNano(), *gens[0].GenerateMetric().Timestamp)
+	}, make(chan bool))
+	assert.Equal(t, int(b.ticks()), len(visited))
+	for i, now := range visited {
+		assert.Assert(t, now.Equal(start.Add(time.Duration(i)*b.step)), "tick %d at %s", i, now)
+	}
+	assert.Assert(t, visited[len(visited)-1].Equal(b.end))
+	assert.Equal(t, b.ticks(), b.ticksDone)
+	assert.Equal(t, int64(1), b.workersDone)
+
+	// A stopped worker returns at its next step, without being counted as done
+	b = &backfill{start: start, end: start.Add(time.Minute), step: 10 * time.Second}
+	stopChan := make(chan bool)
+	ticks := 0
+	b.runWorker(gens, func(now time.Time) {
+		ticks++
+		if ticks == 3 {
+			close(stopChan)
+		}
+	}, stopChan)
+	assert.Equal(t, 3, ticks)
+	assert.Equal(t, int64(3), b.ticksDone)
+	assert.Equal(t, int64(0), b.workersDone)
+
+	b.runWorker(gens, func(now time.Time) {
+		t.Fatal("a worker stopped before its first step doesn't emit")
+	}, stopChan)
+	assert.Equal(t, int64(3), b.ticksDone)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// churner simulates hosts (or pods) going down and being replaced by new ones: every churnInterval, a percentage of
+// the running workers is stopped and replaced by new workers with new WORKERNUM/WORKERFULLNAME identities.
+type churner struct {
+	percent float64
+	// Fraction of a worker left over from previous churns, so that low percentages on few workers still churn over time
+	carry float64
+}
+
+func (c *churner) churn(pool workersScaler) {
+	c.carry += float64(pool.count()) * c.percent / 100
+	n := int(c.carry)
+	c.carry -= float64(n)
+
+	if n > 0 {
+		pool.replace(n)
+		log.Infof("Churn: replaced %d of %d workers", n, pool.count())
+	}
+}
+
+// churnRate returns the number of replaced workers per minute
+func churnRate(replaced int64, duration time.Duration) float64 {
+	if duration <= 0 {
+		return 0
+	}
+	return float64(replaced) / duration.Minutes()
+}
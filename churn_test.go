@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// fakeScaler is a workersScaler recording what's asked of it, without running any worker
+type fakeScaler struct {
+	workers   int
+	replaced  []int
+	intervals []time.Duration
+	paused    bool
+}
+
+func (s *fakeScaler) count() int                         { return s.workers }
+func (s *fakeScaler) scaleTo(n int)                      { s.workers = n }
+func (s *fakeScaler) setInterval(interval time.Duration) { s.intervals = append(s.intervals, interval) }
+func (s *fakeScaler) replace(n int)                      { s.replaced = append(s.replaced, n) }
+func (s *fakeScaler) setPaused(paused bool)              { s.paused = paused }
+func (s *fakeScaler) syncStats()                         {}
+func (s *fakeScaler) stopAll()                           { s.workers = 0 }
+
+func TestChurn(t *testing.T) {
+	tests := []struct {
+		workers  int
+		percent  float64
+		ticks    int
+		expected []int
+	}{
+		{workers: 100, percent: 10, ticks: 3, expected: []int{10, 10, 10}},
+		// The fraction of a worker left over carries to the next churns
+		{workers: 10, percent: 25, ticks: 4, expected: []int{2, 3, 2, 3}},
+		{workers: 4, percent: 10, ticks: 5, expected: []int{1, 1}},
+		{workers: 3, percent: 33.5, ticks: 3, expected: []int{1, 1, 1}},
+		{workers: 0, percent: 50, ticks: 3, expected: nil},
+		{workers: 10, percent: 0, ticks: 3, expected: nil},
+	}
+
+	for _, test := range tests {
+		pool := &fakeScaler{workers: test.workers}
+		c := &churner{percent: test.percent}
+		for i := 0; i < test.ticks; i++ {
+			c.churn(pool)
+		}
+		assert.DeepEqual(t, test.expected, pool.replaced)
+	}
+}
+
+func TestChurnRate(t *testing.T) {
+	assert.Equal(t, 3.0, churnRate(6, 2*time.Minute))
+	assert.Equal(t, 0.0, churnRate(6, 0))
+}
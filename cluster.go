@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Distributed mode: one lagrande instance runs as a coordinator (-mode coordinator) and several instances run as
+// agents (-mode agent). The coordinator runs the load stages and churn, and tells each agent how many workers to run.
+// The agents run the workers and push their stats to the coordinator, which prints a single report.
+//
+// Protocol (JSON over HTTP):
+//  - POST /register: an agent registers itself. The response is only sent once all the expected agents registered,
+//    which gives every agent its index, and therefore its unique NODENAME and range of worker numbers.
+//  - POST /sync: every agentSyncInterval, an agent pushes its stats and gets back the workers it must run.
+
+const (
+	agentSyncInterval = statsPushInterval
+	// An agent gives up if it can't reach the coordinator for this long
+	agentCoordinatorTimeout = 10 * time.Second
+	// The coordinator stops waiting for the agents' last stats after this long
+	coordinatorStopTimeout = 10 * time.Second
+	// At the end of a stage, the coordinator waits this long at most for every agent to sync the stats of the stage
+	coordinatorStageSyncTimeout = 3 * agentSyncInterval
+	// The agent doesn't know in advance how many workers it will run, so the stats buffer is sized generously
+	agentStatsBufferSize = 65536
+)
+
+type agentRegistration struct {
+	Hostname string `json:"hostname"`
+}
+
+type agentAssignment struct {
+	Agent    int    `json:"agent"`
+	Agents   int    `json:"agents"`
+	NodeName string `json:"nodeName"`
+	Seed     int64  `json:"seed"`
+}
+
+type agentStat struct {
+	Worker             int            `json:"worker"`
+	Target             int            `json:"target"`
+	SuccessfullySent   int64          `json:"successfullySent"`
+	UnsuccessfullySent int64          `json:"unsuccessfullySent"`
+	Duration           time.Duration  `json:"duration"`
+	Disorder           disorderCounts `json:"disorder"`
+}
+
+type agentSync struct {
+	Agent int `json:"agent"`
+	// Sequence number of the stats, the same stats are sent again with the same number until the coordinator
+	// acknowledges them so that it can tell a retry from new stats
+	Sequence int64       `json:"sequence"`
+	Workers  int         `json:"workers"`
+	Stats    []agentStat `json:"stats"`
+	Done     bool        `json:"done"`
+}
+
+type agentCommand struct {
+	Workers  int           `json:"workers"`
+	Interval time.Duration `json:"interval"`
+	Replace  int           `json:"replace"`
+	Paused   bool          `json:"paused"`
+	Stop     bool          `json:"stop"`
+}
+
+// ------------------------------------------------------------------------------------------------------------------
+// Coordinator
+
+type remoteAgent struct {
+	hostname string
+	// What the agent should run
+	workers int
+	replace int
+	// What the agent reported on its last sync
+	runningWorkers int
+	done           bool
+	lastSync       time.Time
+	lastSequence   int64
+}
+
+// coordinator implements workersScaler by spreading the workers across the agents
+type coordinator struct {
+	mu        sync.Mutex
+	agents    []*remoteAgent
+	expected  int
+	interval  time.Duration
+	paused    bool
+	stopping  bool
+	statsChan chan<- emissionStat
+
+	registered chan bool
+	allDone    chan bool
+	// Notified after each sync, so that syncStats doesn't have to poll
+	synced chan bool
+}
+
+// startCoordinator starts the coordinator HTTP server and blocks until all the expected agents registered
+func startCoordinator(listen string, expected int, interval time.Duration, statsChan chan<- emissionStat) (*coordinator, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("Error starting the coordinator: %s", err)
+	}
+
+	c := newCoordinator(expected, interval, statsChan)
+	go func() {
+		err := http.Serve(listener, c.handler())
+		log.Errorf("Coordinator stopped: %s", err)
+	}()
+
+	log.Infof("Coordinator listening on %s, waiting for %d agents", listen, expected)
+	<-c.registered
+	log.Infof("All %d agents registered", expected)
+	return c, nil
+}
+
+func newCoordinator(expected int, interval time.Duration, statsChan chan<- emissionStat) *coordinator {
+	return &coordinator{
+		expected:   expected,
+		interval:   interval,
+		statsChan:  statsChan,
+		registered: make(chan bool),
+		allDone:    make(chan bool),
+		synced:     make(chan bool, 1),
+	}
+}
+
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", c.handleRegister)
+	mux.HandleFunc("/sync", c.handleSync)
+	return mux
+}
+
+func (c *coordinator) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var registration agentRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	if len(c.agents) >= c.expected {
+		c.mu.Unlock()
+		http.Error(w, "All agents already registered", http.StatusConflict)
+		return
+	}
+	index := len(c.agents)
+	c.agents = append(c.agents, &remoteAgent{hostname: registration.Hostname})
+	if len(c.agents) == c.expected {
+		close(c.registered)
+	}
+	c.mu.Unlock()
+
+	log.Infof("Agent %d registered from %s", index, registration.Hostname)
+
+	// Hold the response until every agent registered so that they all start in sync
+	<-c.registered
+
+	assignment := agentAssignment{
+		Agent:    index,
+		Agents:   c.expected,
+		NodeName: fmt.Sprintf("%s-agent%d", registration.Hostname, index),
+		Seed:     seed,
+	}
+	json.NewEncoder(w).Encode(assignment)
+}
+
+func (c *coordinator) handleSync(w http.ResponseWriter, r *http.Request) {
+	var report agentSync
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	if report.Agent < 0 || report.Agent >= len(c.agents) {
+		c.mu.Unlock()
+		http.Error(w, "Unknown agent", http.StatusBadRequest)
+		return
+	}
+
+	agent := c.agents[report.Agent]
+	// The agent sends its stats again if it didn't get the answer to a sync, they were already counted if the
+	// coordinator handled that sync
+	stats := report.Stats
+	if report.Sequence <= agent.lastSequence {
+		log.Debugf("Agent %d sent the stats #%d again, ignoring them", report.Agent, report.Sequence)
+		stats = nil
+	} else {
+		agent.lastSequence = report.Sequence
+	}
+
+	agent.runningWorkers = report.Workers
+	running := 0
+	for _, a := range c.agents {
+		running += a.runningWorkers
+	}
+	atomic.StoreInt64(&activeWorkers, int64(running))
+
+	command := agentCommand{Workers: agent.workers, Interval: c.interval, Replace: agent.replace, Paused: c.paused, Stop: c.stopping}
+	agent.replace = 0
+	c.mu.Unlock()
+
+	// Without the lock, pushing the stats can wait for the stats handler
+	for _, s := range stats {
+		pushStat(c.statsChan, emissionStat{workerNum: s.Worker, target: s.Target, successfullySent: s.SuccessfullySent, unsuccessfullySent: s.UnsuccessfullySent, duration: s.Duration, disorder: s.Disorder})
+	}
+
+	// Only once the stats reached the stats handler, for syncStats and stopAll to wait for them
+	c.mu.Lock()
+	agent.lastSync = time.Now()
+	select {
+	case c.synced <- true:
+	default:
+	}
+	if report.Done && !agent.done {
+		agent.done = true
+		log.Infof("Agent %d (%s) stopped", report.Agent, agent.hostname)
+		if c.allAgentsDone() {
+			close(c.allDone)
+		}
+	}
+	c.mu.Unlock()
+
+	json.NewEncoder(w).Encode(command)
+}
+
+func (c *coordinator) allAgentsDone() bool {
+	for _, a := range c.agents {
+		if !a.done {
+			return false
+		}
+	}
+	return true
+}
+
+// spread splits n across the agents, the first agents getting the remainder
+func (c *coordinator) spread(n int) []int {
+	shares := make([]int, len(c.agents))
+	for i := range shares {
+		shares[i] = n / len(c.agents)
+		if i < n%len(c.agents) {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+func (c *coordinator) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, a := range c.agents {
+		n += a.workers
+	}
+	return n
+}
+
+func (c *coordinator) scaleTo(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, share := range c.spread(n) {
+		c.agents[i].workers = share
+	}
+}
+
+func (c *coordinator) setInterval(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interval = interval
+}
+
+func (c *coordinator) replace(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Spread the churn proportionally to the workers of each agent
+	total := 0
+	for _, a := range c.agents {
+		total += a.workers
+	}
+	if total == 0 {
+		return
+	}
+	replaced := 0
+	for _, a := range c.agents {
+		share := n * a.workers / total
+		a.replace += share
+		replaced += share
+	}
+	for i := 0; replaced < n && i < len(c.agents); i++ {
+		if c.agents[i].workers > c.agents[i].replace {
+			c.agents[i].replace++
+			replaced++
+		}
+	}
+	atomic.AddInt64(&churnedWorkers, int64(replaced))
+}
+
+func (c *coordinator) setPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = paused
+}
+
+// syncStats waits for every agent to sync once more, so that the stats of the workers up to now reach the stats
+// handler before the next stage starts and are reported with the stage they were sent in
+func (c *coordinator) syncStats() {
+	since := time.Now()
+	timeout := time.After(coordinatorStageSyncTimeout)
+	for !c.syncedSince(since) {
+		select {
+		case <-c.synced:
+		case <-timeout:
+			log.Warn("Timed out waiting for the agents to sync, some stats may be reported with the next stage")
+			return
+		}
+	}
+}
+
+func (c *coordinator) syncedSince(since time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, a := range c.agents {
+		if a.lastSync.Before(since) {
+			return false
+		}
+	}
+	return true
+}
+
+// stopAll tells every agent to stop and waits for their last stats
+func (c *coordinator) stopAll() {
+	c.mu.Lock()
+	c.stopping = true
+	for _, a := range c.agents {
+		a.workers = 0
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.allDone:
+	case <-time.After(coordinatorStopTimeout):
+		log.Warn("Timed out waiting for the agents to stop, their last stats may be missing")
+	}
+	atomic.StoreInt64(&activeWorkers, 0)
+}
+
+// ------------------------------------------------------------------------------------------------------------------
+// Agent
+
+// registerAgent registers this instance to the coordinator and blocks until all agents registered. It must be called
+// before the CLI configuration is processed since the assignment changes the NODENAME.
+func registerAgent(coordinatorAddress string) (*agentAssignment, error) {
+	body, _ := json.Marshal(agentRegistration{Hostname: hostname})
+
+	log.Infof("Registering to coordinator %s", coordinatorAddress)
+	// No timeout: the coordinator holds the response until all the agents registered. The coordinator may not be up
+	// yet though, so connection errors are retried for a while.
+	var resp *http.Response
+	var err error
+	for start := time.Now(); ; {
+		resp, err = http.Post(coordinatorURL(coordinatorAddress, "/register"), "application/json", bytes.NewReader(body))
+		if err == nil {
+			break
+		}
+		if time.Since(start) > agentCoordinatorTimeout {
+			return nil, fmt.Errorf("Error registering to the coordinator: %s", err)
+		}
+		log.Debugf("Coordinator not reachable yet: %s", err)
+		time.Sleep(agentSyncInterval)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error registering to the coordinator: %s", resp.Status)
+	}
+
+	var assignment agentAssignment
+	if err := json.NewDecoder(resp.Body).Decode(&assignment); err != nil {
+		return nil, fmt.Errorf("Error decoding the coordinator assignment: %s", err)
+	}
+
+	log.Infof("Registered as agent %d of %d with node name %s", assignment.Agent, assignment.Agents, assignment.NodeName)
+	return &assignment, nil
+}
+
+// runAgent runs the workers the coordinator asks for until it tells the agent to stop
+func runAgent(coordinatorAddress string, assignment *agentAssignment) error {
+	statsChan := make(chan emissionStat, agentStatsBufferSize)
+	pool := newAgentPool(assignment, intervalDuration, statsChan)
+
+	client := &http.Client{Timeout: agentSyncInterval}
+	syncURL := coordinatorURL(coordinatorAddress, "/sync")
+	lastSuccessfulSync := time.Now()
+	stopping := false
+	// Stats the coordinator didn't acknowledge yet, they're sent again with the same sequence number on the next sync
+	// until it does. The stats pushed by the workers meanwhile wait in statsChan for the next sequence number.
+	var pending []agentStat
+	var sequence int64
+	acknowledged := true
+
+	syncTicker := time.NewTicker(agentSyncInterval)
+	defer syncTicker.Stop()
+
+	for range syncTicker.C {
+		// The agent is only done once the stats pushed by the stopped workers are sent
+		done := false
+		if acknowledged {
+			pending = nil
+			for drained := false; !drained; {
+				select {
+				case stats := <-statsChan:
+					pending = append(pending, agentStat{Worker: stats.workerNum, Target: stats.target, SuccessfullySent: stats.successfullySent, UnsuccessfullySent: stats.unsuccessfullySent, Duration: stats.duration, Disorder: stats.disorder})
+				default:
+					drained = true
+				}
+			}
+			sequence++
+			done = stopping
+		}
+
+		report := agentSync{Agent: assignment.Agent, Sequence: sequence, Workers: pool.count(), Stats: pending, Done: done}
+		command, err := postSync(client, syncURL, &report)
+		if err != nil {
+			log.Errorf("Error syncing with the coordinator: %s", err)
+			if time.Since(lastSuccessfulSync) > agentCoordinatorTimeout {
+				pool.stopAll()
+				return errors.New("Lost the connection to the coordinator, stopping")
+			}
+			acknowledged = false
+			continue
+		}
+		lastSuccessfulSync = time.Now()
+		acknowledged = true
+
+		if done {
+			// The last stats were acknowledged
+			return nil
+		}
+
+		if command.Stop {
+			log.Info("The coordinator asked to stop, stopping workers")
+			pool.stopAll()
+			stopping = true
+			continue
+		}
+
+		if command.Interval > 0 {
+			pool.setInterval(command.Interval)
+		}
+		pool.setPaused(command.Paused)
+		if command.Replace > 0 {
+			pool.replace(command.Replace)
+		}
+		pool.scaleTo(command.Workers)
+	}
+
+	return nil
+}
+
+// newAgentPool returns the pool of the workers of an agent
+func newAgentPool(assignment *agentAssignment, interval time.Duration, statsChan chan<- emissionStat) *workerPool {
+	pool := newWorkerPool(interval, statsChan)
+	// Worker numbers are interleaved between agents so that they never collide, even with churn
+	pool.idStride = assignment.Agents
+	pool.idOffset = assignment.Agent
+	return pool
+}
+
+func postSync(client *http.Client, url string, report *agentSync) (*agentCommand, error) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	var command agentCommand
+	if err := json.NewDecoder(resp.Body).Decode(&command); err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+func coordinatorURL(address string, path string) string {
+	if strings.HasPrefix(address, "http://") || strings.HasPrefix(address, "https://") {
+		return strings.TrimSuffix(address, "/") + path
+	}
+	return "http://" + address + path
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// startTestCluster registers n agents against a coordinator served by httptest and returns their assignments, in the
+// order of their index
+func startTestCluster(t *testing.T, n int, statsChan chan<- emissionStat) (*coordinator, *httptest.Server, []*agentAssignment) {
+	hostname = "testhost"
+	c := newCoordinator(n, time.Second, statsChan)
+	server := httptest.NewServer(c.handler())
+
+	assignmentsChan := make(chan *agentAssignment, n)
+	errChan := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			assignment, err := registerAgent(server.URL)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			assignmentsChan <- assignment
+		}()
+	}
+
+	assignments := make([]*agentAssignment, n)
+	for i := 0; i < n; i++ {
+		select {
+		case assignment := <-assignmentsChan:
+			assert.Assert(t, assignment.Agent >= 0 && assignment.Agent < n)
+			assert.Assert(t, assignments[assignment.Agent] == nil, "agent index %d given twice", assignment.Agent)
+			assignments[assignment.Agent] = assignment
+		case err := <-errChan:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the agents to register")
+		}
+	}
+	return c, server, assignments
+}
+
+func syncAgent(t *testing.T, server *httptest.Server, report agentSync) *agentCommand {
+	command, err := postSync(server.Client(), coordinatorURL(server.URL, "/sync"), &report)
+	assert.NilError(t, err)
+	return command
+}
+
+func TestClusterRegistration(t *testing.T) {
+	c, server, assignments := startTestCluster(t, 3, make(chan emissionStat, 16))
+	defer server.Close()
+
+	select {
+	case <-c.registered:
+	default:
+		t.Fatal("The coordinator didn't notice all the agents registered")
+	}
+
+	nodeNames := make(map[string]bool)
+	for i, assignment := range assignments {
+		assert.Equal(t, 3, assignment.Agents)
+		assert.Equal(t, seed, assignment.Seed)
+		nodeNames[assignment.NodeName] = true
+		assert.Equal(t, fmt.Sprintf("testhost-agent%d", i), assignment.NodeName)
+	}
+	assert.Equal(t, 3, len(nodeNames))
+
+	// A 4th agent is refused
+	_, err := registerAgent(server.URL)
+	assert.ErrorContains(t, err, "409")
+
+	// Worker numbers never collide between agents, even after many workers were started (eg: with churn)
+	var ids []int
+	for _, assignment := range assignments {
+		pool := newAgentPool(assignment, time.Second, nil)
+		for n := 0; n < 10; n++ {
+			ids = append(ids, pool.workerID(n))
+		}
+	}
+	sort.Ints(ids)
+	for i, id := range ids {
+		assert.Equal(t, i, id)
+	}
+}
+
+func TestClusterSpreadAndReplace(t *testing.T) {
+	c, server, _ := startTestCluster(t, 3, make(chan emissionStat, 16))
+	defer server.Close()
+
+	tests := []struct {
+		workers  int
+		replace  int
+		expected []int
+		replaced []int
+	}{
+		{workers: 7, replace: 0, expected: []int{3, 2, 2}, replaced: []int{0, 0, 0}},
+		{workers: 2, replace: 1, expected: []int{1, 1, 0}, replaced: []int{1, 0, 0}},
+		{workers: 9, replace: 3, expected: []int{3, 3, 3}, replaced: []int{1, 1, 1}},
+		{workers: 10, replace: 5, expected: []int{4, 3, 3}, replaced: []int{3, 1, 1}},
+		{workers: 0, replace: 2, expected: []int{0, 0, 0}, replaced: []int{0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		c.scaleTo(test.workers)
+		c.replace(test.replace)
+		assert.Equal(t, test.workers, c.count())
+
+		for agent := range test.expected {
+			command := syncAgent(t, server, agentSync{Agent: agent})
+			assert.Equal(t, test.expected[agent], command.Workers, "%d workers, agent %d", test.workers, agent)
+			assert.Equal(t, test.replaced[agent], command.Replace, "%d workers replacing %d, agent %d", test.workers, test.replace, agent)
+			assert.Equal(t, time.Second, command.Interval)
+
+			// The workers to replace are only given once
+			command = syncAgent(t, server, agentSync{Agent: agent})
+			assert.Equal(t, 0, command.Replace)
+		}
+	}
+}
+
+func TestClusterStats(t *testing.T) {
+	statsChan := make(chan emissionStat, 16)
+	c, server, _ := startTestCluster(t, 2, statsChan)
+	defer server.Close()
+
+	report := agentSync{Agent: 1, Sequence: 1, Workers: 2, Stats: []agentStat{
+		{Worker: 1, SuccessfullySent: 10, UnsuccessfullySent: 1, Duration: time.Second},
+		{Worker: 3, Target: 1, SuccessfullySent: 20, Duration: time.Second},
+	}}
+	syncAgent(t, server, report)
+	assert.Equal(t, 2, len(statsChan))
+	stats := <-statsChan
+	assert.Equal(t, emissionStat{workerNum: 1, successfullySent: 10, unsuccessfullySent: 1, duration: time.Second}, stats)
+	stats = <-statsChan
+	assert.Equal(t, emissionStat{workerNum: 3, target: 1, successfullySent: 20, duration: time.Second}, stats)
+
+	// The same stats sent again, eg: when the answer was lost, aren't counted twice
+	syncAgent(t, server, report)
+	assert.Equal(t, 0, len(statsChan))
+	report.Sequence++
+	report.Stats = report.Stats[:1]
+	syncAgent(t, server, report)
+	assert.Equal(t, 1, len(statsChan))
+	<-statsChan
+
+	// The coordinator isn't locked while the stats handler catches up
+	report.Sequence++
+	report.Stats = make([]agentStat, cap(statsChan)+1)
+	pushed := make(chan bool)
+	go func() {
+		syncAgent(t, server, report)
+		close(pushed)
+	}()
+	for len(statsChan) < cap(statsChan) {
+		time.Sleep(time.Millisecond)
+	}
+	counted := make(chan bool)
+	go func() {
+		c.count()
+		close(counted)
+	}()
+	select {
+	case <-counted:
+	case <-pushed:
+		t.Fatal("The sync returned before the stats handler caught up")
+	}
+	for len(statsChan) > 0 {
+		<-statsChan
+	}
+	<-pushed
+	for len(statsChan) > 0 {
+		<-statsChan
+	}
+
+	// The stats of an unknown agent are refused
+	_, err := postSync(server.Client(), coordinatorURL(server.URL, "/sync"), &agentSync{Agent: 2, Stats: []agentStat{{Worker: 2, SuccessfullySent: 5}}})
+	assert.ErrorContains(t, err, "400")
+	assert.Equal(t, 0, len(statsChan))
+
+	// syncStats returns once every agent synced
+	done := make(chan bool)
+	go func() {
+		c.syncStats()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	syncAgent(t, server, agentSync{Agent: 0, Sequence: 1})
+	syncAgent(t, server, agentSync{Agent: 1, Sequence: 4})
+	select {
+	case <-done:
+	case <-time.After(coordinatorStageSyncTimeout / 2):
+		t.Fatal("syncStats didn't return after every agent synced")
+	}
+
+	// Stopping waits for every agent to report it's done
+	stopped := make(chan bool)
+	go func() {
+		c.stopAll()
+		close(stopped)
+	}()
+	for agent := 0; agent < 2; agent++ {
+		for !syncAgent(t, server, agentSync{Agent: agent}).Stop {
+			time.Sleep(time.Millisecond)
+		}
+		syncAgent(t, server, agentSync{Agent: agent, Done: true})
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("stopAll didn't return after every agent was done")
+	}
+}
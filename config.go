@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// fileConfig is the structure of the YAML or JSON file given with -config. Every field is optional, the CLI flags
+// default values are used for the missing ones and CLI flags explicitly set on the command line override the file.
+type fileConfig struct {
+	Target struct {
+		Format   string `yaml:"format" json:"format"`
+		Protocol string `yaml:"protocol" json:"protocol"`
+		Endpoint string `yaml:"endpoint" json:"endpoint"`
+	} `yaml:"target" json:"target"`
+	Targets []struct {
+		Format   string `yaml:"format" json:"format"`
+		Protocol string `yaml:"protocol" json:"protocol"`
+		Endpoint string `yaml:"endpoint" json:"endpoint"`
+		Weight   *int   `yaml:"weight" json:"weight"`
+	} `yaml:"targets" json:"targets"`
+	TargetsMode string `yaml:"targetsMode" json:"targetsMode"`
+	Interval    string `yaml:"interval" json:"interval"`
+	Workers     struct {
+		Count    *int   `yaml:"count" json:"count"`
+		Interval string `yaml:"interval" json:"interval"`
+		Churn    struct {
+			Percent  *float64 `yaml:"percent" json:"percent"`
+			Interval string   `yaml:"interval" json:"interval"`
+		} `yaml:"churn" json:"churn"`
+	} `yaml:"workers" json:"workers"`
+	Stages []struct {
+		Kind     string `yaml:"kind" json:"kind"`
+		Duration string `yaml:"duration" json:"duration"`
+		Workers  *int   `yaml:"workers" json:"workers"`
+		Interval string `yaml:"interval" json:"interval"`
+	} `yaml:"stages" json:"stages"`
+	MetricNamespace struct {
+		Prefix *string `yaml:"prefix" json:"prefix"`
+		Suffix *string `yaml:"suffix" json:"suffix"`
+	} `yaml:"metricNamespace" json:"metricNamespace"`
+	Tags       map[string]string `yaml:"tags" json:"tags"`
+	Generators []struct {
+		Type   string                 `yaml:"type" json:"type"`
+		Params map[string]interface{} `yaml:"params" json:"params"`
+	} `yaml:"generators" json:"generators"`
+	Disorder struct {
+		LatePercent      *float64 `yaml:"latePercent" json:"latePercent"`
+		LateSkew         string   `yaml:"lateSkew" json:"lateSkew"`
+		FuturePercent    *float64 `yaml:"futurePercent" json:"futurePercent"`
+		FutureSkew       string   `yaml:"futureSkew" json:"futureSkew"`
+		DuplicatePercent *float64 `yaml:"duplicatePercent" json:"duplicatePercent"`
+		ConflictPercent  *float64 `yaml:"conflictPercent" json:"conflictPercent"`
+	} `yaml:"disorder" json:"disorder"`
+	Timestamps struct {
+		Mode   string `yaml:"mode" json:"mode"`
+		Offset string `yaml:"offset" json:"offset"`
+	} `yaml:"timestamps" json:"timestamps"`
+	Backfill struct {
+		Start string `yaml:"start" json:"start"`
+		End   string `yaml:"end" json:"end"`
+		Step  string `yaml:"step" json:"step"`
+	} `yaml:"backfill" json:"backfill"`
+	Restarts struct {
+		Probability *float64 `yaml:"probability" json:"probability"`
+		Every       string   `yaml:"every" json:"every"`
+		Tag         string   `yaml:"tag" json:"tag"`
+	} `yaml:"restarts" json:"restarts"`
+	LogLevel string `yaml:"logLevel" json:"logLevel"`
+	DryRun   *bool  `yaml:"dryRun" json:"dryRun"`
+	Seed     *int64 `yaml:"seed" json:"seed"`
+}
+
+// generatorSpec is a generator type and its 'key: value' arguments, as parsed from -profile or from the config file
+type generatorSpec struct {
+	kind string
+	args []string
+}
+
+// Generators declared in the config file, used unless -profile is set on the command line
+var configGenerators []generatorSpec
+
+// loadConfigFile reads the file given with -config and applies its values to the CLI variables that were not
+// explicitly set on the command line
+func loadConfigFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading config file: %s", err)
+	}
+
+	var conf fileConfig
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		decoder := json.NewDecoder(strings.NewReader(string(content)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&conf)
+	} else {
+		err = yaml.UnmarshalStrict(content, &conf)
+	}
+	if err != nil {
+		return fmt.Errorf("Error parsing config file %s: %s", path, err)
+	}
+
+	return applyFileConfig(&conf)
+}
+
+func applyFileConfig(conf *fileConfig) error {
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	setString := func(flagName string, dest *string, value string) {
+		if len(value) > 0 && !setFlags[flagName] {
+			*dest = value
+		}
+	}
+	checkDuration := func(path string, value string, allowZero bool) error {
+		if len(value) == 0 {
+			return nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("%s: invalid duration '%s', it must be a >= 0 Go Duration (eg: 500ms, 1m30s)", path, value)
+		}
+		if d == 0 && !allowZero {
+			return fmt.Errorf("%s: invalid duration '%s', it must be a > 0 Go Duration (eg: 500ms, 1m30s)", path, value)
+		}
+		return nil
+	}
+
+	if err := checkDuration("interval", conf.Interval, false); err != nil {
+		return err
+	}
+	if err := checkDuration("workers.interval", conf.Workers.Interval, true); err != nil {
+		return err
+	}
+	if err := checkDuration("workers.churn.interval", conf.Workers.Churn.Interval, true); err != nil {
+		return err
+	}
+	if err := checkDuration("disorder.lateSkew", conf.Disorder.LateSkew, false); err != nil {
+		return err
+	}
+	if err := checkDuration("disorder.futureSkew", conf.Disorder.FutureSkew, false); err != nil {
+		return err
+	}
+	if err := checkDuration("backfill.step", conf.Backfill.Step, false); err != nil {
+		return err
+	}
+	if err := checkDuration("restarts.every", conf.Restarts.Every, true); err != nil {
+		return err
+	}
+
+	setString("format", &format, conf.Target.Format)
+	setString("protocol", &protocol, conf.Target.Protocol)
+	setString("endpoint", &endpoint, conf.Target.Endpoint)
+	setString("targetsMode", &targetsMode, conf.TargetsMode)
+	setString("interval", &interval, conf.Interval)
+	setString("workersInterval", &workersInterval, conf.Workers.Interval)
+	setString("churnInterval", &churnInterval, conf.Workers.Churn.Interval)
+	setString("logLevel", &logLevel, conf.LogLevel)
+	setString("lateSkew", &lateSkew, conf.Disorder.LateSkew)
+	setString("futureSkew", &futureSkew, conf.Disorder.FutureSkew)
+	setString("timestamps", &timestampsMode, conf.Timestamps.Mode)
+	setString("clockOffset", &clockOffset, conf.Timestamps.Offset)
+	setString("backfillStart", &backfillStart, conf.Backfill.Start)
+	setString("backfillEnd", &backfillEnd, conf.Backfill.End)
+	setString("backfillStep", &backfillStep, conf.Backfill.Step)
+	setString("restartEvery", &restartEvery, conf.Restarts.Every)
+	setString("restartTag", &workersRestarts.tag, conf.Restarts.Tag)
+
+	if len(conf.Targets) > 0 {
+		if len(conf.Target.Format) > 0 || len(conf.Target.Protocol) > 0 || len(conf.Target.Endpoint) > 0 {
+			return fmt.Errorf("target and targets can't be used together, use only targets to publish to several targets")
+		}
+		if !setFlags["target"] {
+			targets = make([]*target, len(conf.Targets))
+			for i, t := range conf.Targets {
+				path := fmt.Sprintf("targets[%d]", i)
+				if len(t.Format) == 0 {
+					return fmt.Errorf("%s.format: missing target format", path)
+				}
+				targets[i] = &target{format: t.Format, protocol: t.Protocol, endpoint: t.Endpoint, weight: 1}
+				if len(t.Protocol) == 0 {
+					targets[i].protocol = "auto"
+				}
+				if t.Weight != nil {
+					if *t.Weight < 0 {
+						return fmt.Errorf("%s.weight: must be a >= 0 integer, got %d", path, *t.Weight)
+					}
+					targets[i].weight = *t.Weight
+				}
+			}
+		}
+	}
+
+	if conf.Workers.Count != nil && !setFlags["workers"] {
+		if *conf.Workers.Count < 0 {
+			return fmt.Errorf("workers.count: must be a >= 0 integer, got %d", *conf.Workers.Count)
+		}
+		workersCount = *conf.Workers.Count
+	}
+	if conf.Workers.Churn.Percent != nil && !setFlags["churnPercent"] {
+		churnPercent = *conf.Workers.Churn.Percent
+	}
+	if conf.MetricNamespace.Prefix != nil && !setFlags["metricNamespacePrefix"] {
+		metricNamespacePrefix = *conf.MetricNamespace.Prefix
+	}
+	if conf.MetricNamespace.Suffix != nil && !setFlags["metricNamespaceSuffix"] {
+		metricNamespaceSuffix = *conf.MetricNamespace.Suffix
+	}
+	if conf.DryRun != nil && !setFlags["dry-run"] {
+		dryRun = *conf.DryRun
+	}
+	if conf.Seed != nil && !setFlags["seed"] {
+		seed = *conf.Seed
+	}
+
+	setFloat := func(flagName string, dest *float64, value *float64) {
+		if value != nil && !setFlags[flagName] {
+			*dest = *value
+		}
+	}
+	setFloat("latePercent", &pointsDisorder.latePercent, conf.Disorder.LatePercent)
+	setFloat("futurePercent", &pointsDisorder.futurePercent, conf.Disorder.FuturePercent)
+	setFloat("duplicatePercent", &pointsDisorder.duplicatePercent, conf.Disorder.DuplicatePercent)
+	setFloat("conflictPercent", &pointsDisorder.conflictPercent, conf.Disorder.ConflictPercent)
+	setFloat("restartProbability", &workersRestarts.probability, conf.Restarts.Probability)
+
+	if len(conf.Tags) > 0 && !setFlags["tags"] {
+		// Sort the tags so that the generated series are the same from one run to the other
+		keys := make([]string, 0, len(conf.Tags))
+		for k := range conf.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		tagsList := make([]string, len(keys))
+		for i, k := range keys {
+			tagsList[i] = fmt.Sprintf("%s=%s", k, conf.Tags[k])
+		}
+		tags = strings.Join(tagsList, ",")
+	}
+
+	// Like -stages overrides -workers and -workersInterval, the stages of the file override workers.count and
+	// workers.interval, but not -workers or -workersInterval set on the command line
+	if len(conf.Stages) > 0 && !setFlags["stages"] && !setFlags["workers"] && !setFlags["workersInterval"] {
+		loadStages = make([]loadStage, len(conf.Stages))
+		for i, s := range conf.Stages {
+			path := fmt.Sprintf("stages[%d]", i)
+			if err := checkDuration(path+".duration", s.Duration, true); err != nil {
+				return err
+			}
+			if err := checkDuration(path+".interval", s.Interval, false); err != nil {
+				return err
+			}
+
+			// Reuse the -stages parser so both ways of declaring stages are validated the same way
+			token := fmt.Sprintf("%s:%s", s.Kind, s.Duration)
+			if s.Workers != nil {
+				token = fmt.Sprintf("%s:%d", token, *s.Workers)
+			} else if len(s.Interval) > 0 {
+				token = fmt.Sprintf("%s:", token)
+			}
+			if len(s.Interval) > 0 {
+				token = fmt.Sprintf("%s:%s", token, s.Interval)
+			}
+			parsed, err := parseStages(token)
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			loadStages[i] = parsed[0]
+			if setFlags["interval"] {
+				// -interval on the command line overrides the send intervals of the stages
+				loadStages[i].interval = 0
+			}
+		}
+	}
+
+	if len(conf.Generators) > 0 && !setFlags["profile"] {
+		configGenerators = make([]generatorSpec, len(conf.Generators))
+		for i, g := range conf.Generators {
+			path := fmt.Sprintf("generators[%d]", i)
+			if len(g.Type) == 0 {
+				return fmt.Errorf("%s.type: missing generator type", path)
+			}
+
+			// Sort the parameters for stable error messages
+			keys := make([]string, 0, len(g.Params))
+			for k := range g.Params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			spec := generatorSpec{kind: g.Type, args: make([]string, 0, len(keys))}
+			for _, k := range keys {
+				value, err := scalarToString(g.Params[k])
+				if err != nil {
+					return fmt.Errorf("%s.params.%s: %s", path, k, err)
+				}
+				spec.args = append(spec.args, fmt.Sprintf("%s: %s", k, value))
+			}
+			configGenerators[i] = spec
+		}
+	}
+
+	return nil
+}
+
+// scalarToString converts a typed YAML/JSON value (or list of values) into the string representation generators parse
+func scalarToString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		// Lists (eg: histogram buckets) are passed to generators as space-delimited values
+		tokens := make([]string, len(v))
+		for i, item := range v {
+			token, err := scalarToString(item)
+			if err != nil {
+				return "", err
+			}
+			tokens[i] = token
+		}
+		return strings.Join(tokens, " "), nil
+	case nil:
+		return "", fmt.Errorf("missing value")
+	default:
+		return "", fmt.Errorf("expected a string, number, boolean or list of those, got %T", value)
+	}
+}
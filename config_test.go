@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// withCommandLine parses args as the command line and runs f, then puts the flags and the variables set from the
+// config file back to their previous values
+func withCommandLine(t *testing.T, args []string, f func()) {
+	previous := make(map[string]string)
+	flag.VisitAll(func(fl *flag.Flag) {
+		previous[fl.Name] = fl.Value.String()
+	})
+	defer func(commandLine *flag.FlagSet, previousStages []loadStage, previousGenerators []generatorSpec, previousTargets []*target) {
+		flag.CommandLine = commandLine
+		flag.VisitAll(func(fl *flag.Flag) {
+			if fl.Name != "target" && fl.Value.String() != previous[fl.Name] {
+				assert.NilError(t, fl.Value.Set(previous[fl.Name]))
+			}
+		})
+		loadStages = previousStages
+		configGenerators = previousGenerators
+		targets = previousTargets
+	}(flag.CommandLine, loadStages, configGenerators, targets)
+
+	// The command line is parsed by a new flag set, so that the flags it sets don't stay set for the other tests
+	commandLine := flag.NewFlagSet("lagrande", flag.ContinueOnError)
+	flag.VisitAll(func(fl *flag.Flag) {
+		commandLine.Var(fl.Value, fl.Name, fl.Usage)
+	})
+	assert.NilError(t, commandLine.Parse(args))
+	flag.CommandLine = commandLine
+	loadStages = nil
+	configGenerators = nil
+	f()
+}
+
+func writeConfigFile(t *testing.T, name string, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "lagrande")
+	assert.NilError(t, err)
+	path := filepath.Join(dir, name)
+	assert.NilError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func assertStages(t *testing.T, expected []loadStage, stages []loadStage) {
+	assert.Equal(t, len(expected), len(stages))
+	for i := range stages {
+		assert.Equal(t, expected[i], stages[i])
+	}
+}
+
+const testConfigFile = `
+target:
+  format: influxdb
+  endpoint: http://127.0.0.1:8428/write
+interval: 5s
+workers:
+  count: 20
+  interval: 2s
+tags:
+  region: eu
+  node: NODENAME
+stages:
+  - {kind: ramp, duration: 5m, workers: 200}
+  - {kind: hold, duration: 10m, interval: 500ms}
+generators:
+  - type: histogram
+    params: {name: latency, buckets: [100, 250.5, 500], samples: 10}
+`
+
+func TestLoadConfigFile(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "run.yaml", testConfigFile)
+	defer cleanup()
+
+	withCommandLine(t, nil, func() {
+		assert.NilError(t, loadConfigFile(path))
+		assert.Equal(t, "influxdb", format)
+		assert.Equal(t, "http://127.0.0.1:8428/write", endpoint)
+		assert.Equal(t, "5s", interval)
+		assert.Equal(t, 20, workersCount)
+		assert.Equal(t, "2s", workersInterval)
+		assert.Equal(t, "node=NODENAME,region=eu", tags)
+		assertStages(t, []loadStage{
+			{kind: "ramp", duration: 5 * time.Minute, workers: 200},
+			{kind: "hold", duration: 10 * time.Minute, workers: -1, interval: 500 * time.Millisecond},
+		}, loadStages)
+		assert.Equal(t, 1, len(configGenerators))
+		assert.Equal(t, "histogram", configGenerators[0].kind)
+		assert.DeepEqual(t, []string{"buckets: 100 250.5 500", "name: latency", "samples: 10"}, configGenerators[0].args)
+	})
+
+	path, cleanup = writeConfigFile(t, "run.json", `{"workers": {"count": 3}, "stages": [{"kind": "step", "duration": "1m", "workers": 5}]}`)
+	defer cleanup()
+	withCommandLine(t, nil, func() {
+		assert.NilError(t, loadConfigFile(path))
+		assert.Equal(t, 3, workersCount)
+		assertStages(t, []loadStage{{kind: "step", duration: time.Minute, workers: 5}}, loadStages)
+	})
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "unknown.yaml", content: "workers: {count: 3, color: blue}", err: "field color not found"},
+		{name: "unknown.json", content: `{"workers": {"color": "blue"}}`, err: "unknown field \"color\""},
+		{name: "interval.yaml", content: "interval: 0s", err: "interval: invalid duration '0s', it must be a > 0 Go Duration"},
+		{name: "stage.yaml", content: "stages: [{kind: hold, duration: soon}]", err: "stages[0].duration: invalid duration 'soon'"},
+		{name: "kind.yaml", content: "stages: [{kind: jump, duration: 1m, workers: 2}]", err: "stages[0]: Invalid kind 'jump' for stage #1"},
+		{name: "weight.yaml", content: "targets: [{format: carbon, weight: -1}]", err: "targets[0].weight: must be a >= 0 integer, got -1"},
+		{name: "type.yaml", content: "generators: [{params: {name: a}}]", err: "generators[0].type: missing generator type"},
+		{name: "param.yaml", content: "generators: [{type: counterInt, params: {name: {a: b}}}]", err: "generators[0].params.name: expected a string"},
+	}
+
+	for _, test := range tests {
+		path, cleanup := writeConfigFile(t, test.name, test.content)
+		withCommandLine(t, nil, func() {
+			assert.ErrorContains(t, loadConfigFile(path), test.err, "%s", test.content)
+		})
+		cleanup()
+	}
+
+	withCommandLine(t, nil, func() {
+		assert.ErrorContains(t, loadConfigFile(filepath.Join(os.TempDir(), "lagrande-missing.yaml")), "Error reading config file")
+	})
+}
+
+func TestApplyFileConfigFlagsPrecedence(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "run.yaml", testConfigFile)
+	defer cleanup()
+
+	fileStages := []loadStage{
+		{kind: "ramp", duration: 5 * time.Minute, workers: 200},
+		{kind: "hold", duration: 10 * time.Minute, workers: -1, interval: 500 * time.Millisecond},
+	}
+	tests := []struct {
+		args            []string
+		workers         int
+		interval        string
+		tags            string
+		stages          []loadStage
+		generatorsCount int
+	}{
+		{args: nil, workers: 20, interval: "5s", tags: "node=NODENAME,region=eu", stages: fileStages, generatorsCount: 1},
+		{args: []string{"-tags", "a=b", "-profile", "counterInt={}"}, workers: 20, interval: "5s", tags: "a=b", stages: fileStages},
+		// -stages is parsed later on, the stages of the file are ignored
+		{args: []string{"-stages", "hold:1m"}, workers: 20, interval: "5s", tags: "node=NODENAME,region=eu", generatorsCount: 1},
+		// -workers and -workersInterval replace the stages of the file
+		{args: []string{"-workers", "3"}, workers: 3, interval: "5s", tags: "node=NODENAME,region=eu", generatorsCount: 1},
+		{args: []string{"-workersInterval", "0s"}, workers: 20, interval: "5s", tags: "node=NODENAME,region=eu", generatorsCount: 1},
+		// -interval replaces the send intervals of the stages of the file
+		{
+			args: []string{"-interval", "10s"}, workers: 20, interval: "10s", tags: "node=NODENAME,region=eu", generatorsCount: 1,
+			stages: []loadStage{{kind: "ramp", duration: 5 * time.Minute, workers: 200}, {kind: "hold", duration: 10 * time.Minute, workers: -1}},
+		},
+	}
+
+	for _, test := range tests {
+		withCommandLine(t, test.args, func() {
+			assert.NilError(t, loadConfigFile(path), "%v", test.args)
+			assert.Equal(t, test.workers, workersCount, "%v", test.args)
+			assert.Equal(t, test.interval, interval, "%v", test.args)
+			assert.Equal(t, test.tags, tags, "%v", test.args)
+			assertStages(t, test.stages, loadStages)
+			assert.Equal(t, test.generatorsCount, len(configGenerators), "%v", test.args)
+		})
+	}
+}
+
+func TestScalarToString(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+		err      string
+	}{
+		{value: "100ms", expected: "100ms"},
+		{value: true, expected: "true"},
+		{value: 42, expected: "42"},
+		{value: int64(-7), expected: "-7"},
+		{value: uint64(18446744073709551615), expected: "18446744073709551615"},
+		{value: 0.25, expected: "0.25"},
+		{value: 1e21, expected: "1000000000000000000000"},
+		{value: []interface{}{100, 250.5, "+Inf"}, expected: "100 250.5 +Inf"},
+		{value: []interface{}{}, expected: ""},
+		{value: nil, err: "missing value"},
+		{value: []interface{}{1, nil}, err: "missing value"},
+		{value: map[interface{}]interface{}{"a": "b"}, err: "expected a string, number, boolean or list of those, got map[interface {}]interface {}"},
+	}
+
+	for _, test := range tests {
+		s, err := scalarToString(test.value)
+		if len(test.err) > 0 {
+			assert.ErrorContains(t, err, test.err, "%v", test.value)
+			continue
+		}
+		assert.NilError(t, err, "%v", test.value)
+		assert.Equal(t, test.expected, s)
+	}
+}
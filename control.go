@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Control API: with -controlListen, lagrande serves a small JSON over HTTP API (on a TCP address or a Unix socket) to
+// steer a run without restarting it:
+//  - GET /status: current stage, workers, send interval, target rate, pause state and totals
+//  - POST /workers {"count": 50}: pin the worker count, the load stages no longer drive it. DELETE /workers gives the
+//    control back to the load stages.
+//  - POST /interval {"interval": "500ms"}: change the send interval of all workers
+//  - POST /rate {"rate": 20000}: adjust the send interval so that all workers together generate this many data points
+//    per second. DELETE /rate goes back to the send interval.
+//  - POST /pause and POST /resume: stop and resume the metrics emission, the workers keep running
+// Every call answers with the status after the change. The HTTP handlers don't touch the workers themselves, they hand
+// the request over to the main goroutine through the control channel.
+
+const (
+	// A control request is answered with an error if the main goroutine doesn't pick it up in time (eg: while stopping)
+	controlRequestTimeout = 5 * time.Second
+	// Shortest send interval a target rate can lead to
+	minRateSendInterval = time.Millisecond
+	// With a target rate, the send interval is only changed when it differs by more than this ratio, so that workers
+	// don't reset their ticker on every step of a ramp
+	rateIntervalTolerance = 0.05
+)
+
+// Metrics successfully and unsuccessfully sent since the start of the run, updated by the stats handler
+var (
+	totalSuccessfullySent   int64
+	totalUnsuccessfullySent int64
+)
+
+type controlStatus struct {
+	Mode           string  `json:"mode"`
+	Stage          string  `json:"stage"`
+	StageElapsed   string  `json:"stageElapsed"`
+	Workers        int     `json:"workers"`
+	WorkersPinned  bool    `json:"workersPinned"`
+	Series         int     `json:"series"`
+	Interval       string  `json:"interval"`
+	TargetRate     float64 `json:"targetRate,omitempty"`
+	ExpectedRate   float64 `json:"expectedRate"`
+	Paused         bool    `json:"paused"`
+	SuccessfulSent int64   `json:"successfullySent"`
+	FailedSent     int64   `json:"unsuccessfullySent"`
+}
+
+type controlBody struct {
+	Count    *int     `json:"count"`
+	Interval string   `json:"interval"`
+	Rate     *float64 `json:"rate"`
+}
+
+// controlRequest is a validated change (or a status query) handed over to the main goroutine
+type controlRequest struct {
+	action   string
+	workers  int
+	interval time.Duration
+	rate     float64
+	reply    chan controlStatus
+}
+
+// controlState is what the control API changed. It takes precedence over the load stages.
+type controlState struct {
+	// -1 when the worker count is driven by the load stages
+	workers int
+	// 0 when the send interval isn't driven by a target rate
+	rate float64
+	// Send interval set by the flags, the load stages or the control API
+	interval time.Duration
+	// Send interval the workers currently use
+	applied time.Duration
+	paused  bool
+}
+
+func newControlState(interval time.Duration) *controlState {
+	return &controlState{workers: -1, interval: interval, applied: interval}
+}
+
+func (s *controlState) desiredWorkers(plan *stagePlan, now time.Time) int {
+	if s.workers >= 0 {
+		return s.workers
+	}
+	return plan.desiredWorkers(now)
+}
+
+// applyInterval gives the workers the send interval they should use, derived from the target rate if there's one
+func (s *controlState) applyInterval(pool workersScaler) {
+	interval := s.interval
+	if s.rate > 0 {
+		series := pool.count() * seriesPerWorker()
+		if series == 0 {
+			return
+		}
+		interval = time.Duration(float64(series) / s.rate * float64(time.Second))
+		if interval < minRateSendInterval {
+			interval = minRateSendInterval
+		}
+		if math.Abs(float64(interval-s.applied)) < rateIntervalTolerance*float64(s.applied) {
+			return
+		}
+	}
+	if interval != s.applied {
+		pool.setInterval(interval)
+		s.applied = interval
+	}
+}
+
+// handle applies a control request from the main goroutine and returns the resulting status
+func (s *controlState) handle(req controlRequest, plan *stagePlan, pool workersScaler, now time.Time) controlStatus {
+	switch req.action {
+	case "workers":
+		s.workers = req.workers
+		if s.workers >= 0 {
+			log.Infof("Control: worker count pinned to %d", s.workers)
+		} else {
+			log.Info("Control: worker count driven by the load stages again")
+		}
+		pool.scaleTo(s.desiredWorkers(plan, now))
+	case "interval":
+		s.interval = req.interval
+		s.rate = 0
+		log.Infof("Control: send interval set to %s", s.interval)
+	case "rate":
+		s.rate = req.rate
+		if s.rate > 0 {
+			log.Infof("Control: target rate set to %.3f data points per second", s.rate)
+		} else {
+			log.Infof("Control: target rate removed, send interval back to %s", s.interval)
+		}
+	case "pause", "resume":
+		s.paused = req.action == "pause"
+		pool.setPaused(s.paused)
+		log.Infof("Control: metrics emission %sd", req.action)
+	}
+	s.applyInterval(pool)
+
+	return s.status(plan, pool, now)
+}
+
+func (s *controlState) status(plan *stagePlan, pool workersScaler, now time.Time) controlStatus {
+	workers := pool.count()
+	status := controlStatus{
+		Mode:           mode,
+		Stage:          plan.stageName(),
+		StageElapsed:   now.Sub(plan.stageStart).Round(time.Second).String(),
+		Workers:        workers,
+		WorkersPinned:  s.workers >= 0,
+		Series:         workers * seriesPerWorker(),
+		Interval:       s.applied.String(),
+		TargetRate:     s.rate,
+		Paused:         s.paused,
+		SuccessfulSent: atomic.LoadInt64(&totalSuccessfullySent),
+		FailedSent:     atomic.LoadInt64(&totalUnsuccessfullySent),
+	}
+	if !s.paused {
+		status.ExpectedRate = float64(status.Series) / s.applied.Seconds()
+	}
+	return status
+}
+
+// startControlServer serves the control API on the given address, or on a Unix socket if the address is of format
+// unix:<path>. It returns the channel the main goroutine reads control requests from.
+func startControlServer(address string) (<-chan controlRequest, error) {
+	var listener net.Listener
+	var err error
+	if strings.HasPrefix(address, "unix:") {
+		socket := strings.TrimPrefix(address, "unix:")
+		// Remove the socket left over by a previous run, but nothing else that would be at its path
+		if fi, statErr := os.Lstat(socket); statErr == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("Error starting the control API: %s exists and isn't a socket", socket)
+			}
+			if err = os.Remove(socket); err != nil {
+				return nil, fmt.Errorf("Error starting the control API: %s", err)
+			}
+		}
+		listener, err = net.Listen("unix", socket)
+	} else {
+		listener, err = net.Listen("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error starting the control API: %s", err)
+	}
+
+	controlChan := make(chan controlRequest)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", controlHandler(controlChan, parseStatusRequest))
+	mux.HandleFunc("/workers", controlHandler(controlChan, parseWorkersRequest))
+	mux.HandleFunc("/interval", controlHandler(controlChan, parseIntervalRequest))
+	mux.HandleFunc("/rate", controlHandler(controlChan, parseRateRequest))
+	mux.HandleFunc("/pause", controlHandler(controlChan, parsePauseRequest))
+	mux.HandleFunc("/resume", controlHandler(controlChan, parsePauseRequest))
+
+	go func() {
+		err := http.Serve(listener, mux)
+		log.Errorf("Control API stopped: %s", err)
+	}()
+
+	log.Infof("Control API listening on %s", address)
+	return controlChan, nil
+}
+
+// controlHandler validates a request with parse, hands it over to the main goroutine and answers with the new status
+func controlHandler(controlChan chan<- controlRequest, parse func(r *http.Request) (controlRequest, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := parse(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.reply = make(chan controlStatus, 1)
+
+		select {
+		case controlChan <- req:
+		case <-time.After(controlRequestTimeout):
+			http.Error(w, "The run isn't accepting control requests", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(<-req.reply)
+	}
+}
+
+func decodeControlBody(r *http.Request) (*controlBody, error) {
+	var body controlBody
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		return nil, fmt.Errorf("Error parsing request body: %s", err)
+	}
+	return &body, nil
+}
+
+func parseStatusRequest(r *http.Request) (controlRequest, error) {
+	if r.Method != http.MethodGet {
+		return controlRequest{}, errors.New("Only GET is supported")
+	}
+	return controlRequest{action: "status"}, nil
+}
+
+func parseWorkersRequest(r *http.Request) (controlRequest, error) {
+	switch r.Method {
+	case http.MethodDelete:
+		return controlRequest{action: "workers", workers: -1}, nil
+	case http.MethodPost:
+		body, err := decodeControlBody(r)
+		if err != nil {
+			return controlRequest{}, err
+		}
+		if body.Count == nil || *body.Count < 0 {
+			return controlRequest{}, errors.New("Invalid count specified, it must be a >= 0 integer")
+		}
+		return controlRequest{action: "workers", workers: *body.Count}, nil
+	default:
+		return controlRequest{}, errors.New("Only POST and DELETE are supported")
+	}
+}
+
+func parseIntervalRequest(r *http.Request) (controlRequest, error) {
+	if r.Method != http.MethodPost {
+		return controlRequest{}, errors.New("Only POST is supported")
+	}
+	body, err := decodeControlBody(r)
+	if err != nil {
+		return controlRequest{}, err
+	}
+	interval, err := time.ParseDuration(body.Interval)
+	if err != nil || interval <= 0 {
+		return controlRequest{}, errors.New("Invalid interval specified, it must be a > 0 Go Duration")
+	}
+	return controlRequest{action: "interval", interval: interval}, nil
+}
+
+func parseRateRequest(r *http.Request) (controlRequest, error) {
+	switch r.Method {
+	case http.MethodDelete:
+		return controlRequest{action: "rate"}, nil
+	case http.MethodPost:
+		body, err := decodeControlBody(r)
+		if err != nil {
+			return controlRequest{}, err
+		}
+		if body.Rate == nil || *body.Rate <= 0 {
+			return controlRequest{}, errors.New("Invalid rate specified, it must be a > 0 number of data points per second")
+		}
+		return controlRequest{action: "rate", rate: *body.Rate}, nil
+	default:
+		return controlRequest{}, errors.New("Only POST and DELETE are supported")
+	}
+}
+
+func parsePauseRequest(r *http.Request) (controlRequest, error) {
+	if r.Method != http.MethodPost {
+		return controlRequest{}, errors.New("Only POST is supported")
+	}
+	return controlRequest{action: strings.TrimPrefix(r.URL.Path, "/")}, nil
+}
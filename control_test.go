@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+
+	"github.com/aleveille/lagrande/generator"
+)
+
+func TestApplyInterval(t *testing.T) {
+	defer func(previous []generator.Generator) {
+		generatorsArr = previous
+	}(generatorsArr)
+
+	// 2 series per worker
+	counter, err := generator.NewIntCounterGenerator(generator.CLIConfig{}, nil, nil)
+	assert.NilError(t, err)
+	generatorsArr = []generator.Generator{counter, counter}
+
+	tests := []struct {
+		workers  int
+		interval time.Duration
+		applied  time.Duration
+		rate     float64
+		expected time.Duration // Interval the workers are given, 0 if unchanged
+	}{
+		// Without a target rate, the interval is applied as is
+		{workers: 10, interval: time.Second, applied: time.Second, expected: 0},
+		{workers: 10, interval: 2 * time.Second, applied: time.Second, expected: 2 * time.Second},
+		// 10 workers * 2 series at 40 points per second: a point every 500ms
+		{workers: 10, interval: time.Second, applied: time.Second, rate: 40, expected: 500 * time.Millisecond},
+		// Within 5% of the applied interval, the workers keep their ticker
+		{workers: 10, interval: time.Second, applied: 520 * time.Millisecond, rate: 40, expected: 0},
+		{workers: 10, interval: time.Second, applied: 480 * time.Millisecond, rate: 40, expected: 0},
+		{workers: 10, interval: time.Second, applied: 530 * time.Millisecond, rate: 40, expected: 500 * time.Millisecond},
+		{workers: 10, interval: time.Second, applied: 470 * time.Millisecond, rate: 40, expected: 500 * time.Millisecond},
+		// The interval doesn't go below minRateSendInterval
+		{workers: 1, interval: time.Second, applied: time.Second, rate: 1e9, expected: minRateSendInterval},
+		// Without workers, there's no interval to derive from the rate
+		{workers: 0, interval: time.Second, applied: 2 * time.Second, rate: 40, expected: 0},
+	}
+
+	for _, test := range tests {
+		pool := &fakeScaler{workers: test.workers}
+		s := &controlState{workers: -1, interval: test.interval, applied: test.applied, rate: test.rate}
+		s.applyInterval(pool)
+
+		if test.expected == 0 {
+			assert.Equal(t, 0, len(pool.intervals), "%+v", test)
+			assert.Equal(t, test.applied, s.applied)
+		} else {
+			assert.DeepEqual(t, []time.Duration{test.expected}, pool.intervals)
+			assert.Equal(t, test.expected, s.applied)
+		}
+	}
+}
+
+func TestControlHandle(t *testing.T) {
+	plan := &stagePlan{stages: []loadStage{{kind: "step", duration: time.Minute, workers: 5}}}
+	plan.start(time.Now(), 0)
+	pool := &fakeScaler{}
+	s := newControlState(time.Second)
+
+	s.handle(controlRequest{action: "workers", workers: 3}, plan, pool, time.Now())
+	assert.Equal(t, 3, pool.workers)
+	s.handle(controlRequest{action: "workers", workers: -1}, plan, pool, time.Now())
+	assert.Equal(t, 5, pool.workers)
+
+	status := s.handle(controlRequest{action: "pause"}, plan, pool, time.Now())
+	assert.Assert(t, pool.paused)
+	assert.Assert(t, status.Paused)
+	assert.Equal(t, 0.0, status.ExpectedRate)
+	s.handle(controlRequest{action: "resume"}, plan, pool, time.Now())
+	assert.Assert(t, !pool.paused)
+
+	s.handle(controlRequest{action: "interval", interval: 250 * time.Millisecond}, plan, pool, time.Now())
+	assert.DeepEqual(t, []time.Duration{250 * time.Millisecond}, pool.intervals)
+}
+
+func TestStartControlServerSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lagrande")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A file that isn't a socket is left untouched
+	file := filepath.Join(dir, "file")
+	assert.NilError(t, ioutil.WriteFile(file, []byte("data"), 0644))
+	_, err = startControlServer("unix:" + file)
+	assert.ErrorContains(t, err, "exists and isn't a socket")
+	content, err := ioutil.ReadFile(file)
+	assert.NilError(t, err)
+	assert.Equal(t, "data", string(content))
+
+	// The socket left over by a previous run is replaced
+	socket := filepath.Join(dir, "control.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.NilError(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	_, err = startControlServer("unix:" + socket)
+	assert.NilError(t, err)
+}
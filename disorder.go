@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/aleveille/lagrande/metric"
+)
+
+// disorder injects points that TSDBs have to reject, reorder or deduplicate, to verify their out-of-order window and
+// dedupe behavior: a percentage of the points is sent with a timestamp in the past (late) or in the future, and a
+// percentage is sent a second time, either as an exact duplicate or with a conflicting value for the same timestamp.
+type disorder struct {
+	latePercent      float64
+	lateSkew         time.Duration // Maximum skew of the late points
+	futurePercent    float64
+	futureSkew       time.Duration // Maximum skew of the future points
+	duplicatePercent float64
+	conflictPercent  float64
+}
+
+// disorderCounts counts the points injected by disorder, they are reported with the stats
+type disorderCounts struct {
+	Late       int64 `json:"late"`
+	Future     int64 `json:"future"`
+	Duplicates int64 `json:"duplicates"`
+	Conflicts  int64 `json:"conflicts"`
+}
+
+func (d *disorder) enabled() bool {
+	return d.latePercent > 0 || d.futurePercent > 0 || d.duplicatePercent > 0 || d.conflictPercent > 0
+}
+
+func (d *disorder) validate() error {
+	names := []string{"latePercent", "futurePercent", "duplicatePercent", "conflictPercent"}
+	for i, percent := range []float64{d.latePercent, d.futurePercent, d.duplicatePercent, d.conflictPercent} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("Invalid %s specified. Make sure it's a percentage between 0 and 100", names[i])
+		}
+	}
+	if d.latePercent+d.futurePercent > 100 {
+		return fmt.Errorf("Invalid latePercent and futurePercent specified. Make sure they don't add up to more than 100")
+	}
+	if d.latePercent > 0 && d.lateSkew <= 0 {
+		return fmt.Errorf("Invalid lateSkew specified. Make sure it's a duration greater than 0")
+	}
+	if d.futurePercent > 0 && d.futureSkew <= 0 {
+		return fmt.Errorf("Invalid futureSkew specified. Make sure it's a duration greater than 0")
+	}
+	return nil
+}
+
+func (d *disorder) String() string {
+	return fmt.Sprintf("%.2f%% late points (up to %s), %.2f%% future points (up to %s), %.2f%% exact duplicates and %.2f%% conflicting values", d.latePercent, d.lateSkew, d.futurePercent, d.futureSkew, d.duplicatePercent, d.conflictPercent)
+}
+
+// apply returns the metrics of a tick with the injected points, the metrics themselves are not modified
+func (d *disorder) apply(metrics []*metric.Metric, random *rand.Rand, counts *disorderCounts) []*metric.Metric {
+	result := make([]*metric.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		draw := random.Float64() * 100
+		if draw < d.latePercent {
+			m = withTimestamp(m, *m.Timestamp-1-random.Int63n(int64(d.lateSkew)))
+			counts.Late++
+		} else if draw < d.latePercent+d.futurePercent {
+			m = withTimestamp(m, *m.Timestamp+1+random.Int63n(int64(d.futureSkew)))
+			counts.Future++
+		}
+		result = append(result, m)
+
+		if random.Float64()*100 < d.duplicatePercent {
+			result = append(result, m)
+			counts.Duplicates++
+		}
+		if random.Float64()*100 < d.conflictPercent {
+			if conflicting := withConflictingValue(m); conflicting != nil {
+				result = append(result, conflicting)
+				counts.Conflicts++
+			}
+		}
+	}
+	return result
+}
+
+// withTimestamp returns a copy of m with the timestamp, in nanoseconds
+func withTimestamp(m *metric.Metric, timestamp int64) *metric.Metric {
+	injected := *m
+	injected.Timestamp = &timestamp
+	return &injected
+}
+
+// withConflictingValue returns a copy of m with another value, or nil if the value isn't a finite number (eg:
+// histograms or special values)
+func withConflictingValue(m *metric.Metric) *metric.Metric {
+	if m.Histogram != nil || m.Value == nil {
+		return nil
+	}
+	v, err := strconv.ParseFloat(string(*m.Value), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	value := strconv.AppendFloat(nil, v+1, 'f', -1, 64)
+	injected := *m
+	injected.Value = &value
+	injected.Stale = false
+	return &injected
+}
+
+func (c *disorderCounts) add(other disorderCounts) {
+	c.Late += other.Late
+	c.Future += other.Future
+	c.Duplicates += other.Duplicates
+	c.Conflicts += other.Conflicts
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+
+	"github.com/aleveille/lagrande/metric"
+)
+
+func disorderTestMetrics(n int, timestamp int64) []*metric.Metric {
+	metrics := make([]*metric.Metric, n)
+	for i := range metrics {
+		value := []byte("42.5")
+		ts := timestamp
+		metrics[i] = &metric.Metric{Value: &value, Timestamp: &ts}
+	}
+	return metrics
+}
+
+func TestDisorderApply(t *testing.T) {
+	const points = 20000
+	const timestamp = int64(1600000000000000000)
+
+	tests := []struct {
+		disorder disorder
+		expected disorderCounts // In percents of the points
+	}{
+		{disorder: disorder{}, expected: disorderCounts{}},
+		{disorder: disorder{latePercent: 10, lateSkew: time.Minute}, expected: disorderCounts{Late: 10}},
+		{disorder: disorder{futurePercent: 25, futureSkew: time.Second}, expected: disorderCounts{Future: 25}},
+		{disorder: disorder{latePercent: 30, lateSkew: time.Minute, futurePercent: 70, futureSkew: time.Minute}, expected: disorderCounts{Late: 30, Future: 70}},
+		{disorder: disorder{duplicatePercent: 5, conflictPercent: 50}, expected: disorderCounts{Duplicates: 5, Conflicts: 50}},
+		{disorder: disorder{duplicatePercent: 100, conflictPercent: 100}, expected: disorderCounts{Duplicates: 100, Conflicts: 100}},
+	}
+
+	for _, test := range tests {
+		d := test.disorder
+		var counts disorderCounts
+		metrics := disorderTestMetrics(points, timestamp)
+		result := d.apply(metrics, rand.New(rand.NewSource(42)), &counts)
+
+		// Within 1% of the points
+		for _, c := range []struct {
+			name     string
+			count    int64
+			expected int64
+		}{
+			{"late", counts.Late, test.expected.Late},
+			{"future", counts.Future, test.expected.Future},
+			{"duplicates", counts.Duplicates, test.expected.Duplicates},
+			{"conflicts", counts.Conflicts, test.expected.Conflicts},
+		} {
+			expected := c.expected * points / 100
+			assert.Assert(t, c.count >= expected-points/100 && c.count <= expected+points/100, "%s: %d points for %d expected with %s", c.name, c.count, expected, d.String())
+		}
+
+		// Every injected point is in the result and the points are counted right
+		assert.Equal(t, int64(points)+counts.Duplicates+counts.Conflicts, int64(len(result)))
+		var late, future, conflicts int64
+		for _, m := range result {
+			switch {
+			case *m.Timestamp < timestamp:
+				late++
+				assert.Assert(t, *m.Timestamp >= timestamp-int64(d.lateSkew))
+			case *m.Timestamp > timestamp:
+				future++
+				assert.Assert(t, *m.Timestamp <= timestamp+int64(d.futureSkew))
+			}
+			if string(*m.Value) != "42.5" {
+				assert.Equal(t, "43.5", string(*m.Value))
+				conflicts++
+			}
+		}
+		assert.Equal(t, counts.Conflicts, conflicts)
+		// Duplicates and conflicts of a late (or future) point are late (or future) too
+		assert.Assert(t, late >= counts.Late && future >= counts.Future)
+
+		// The metrics given are left untouched
+		for _, m := range metrics {
+			assert.Equal(t, timestamp, *m.Timestamp)
+			assert.Equal(t, "42.5", string(*m.Value))
+		}
+	}
+}
+
+func TestDisorderConflictingValue(t *testing.T) {
+	for value, expected := range map[string]string{"1": "2", "-0.5": "0.5", "NaN": "", "+Inf": "", "not a number": ""} {
+		v := []byte(value)
+		conflicting := withConflictingValue(&metric.Metric{Value: &v})
+		if len(expected) == 0 {
+			assert.Assert(t, conflicting == nil, "value %s", value)
+			continue
+		}
+		assert.Equal(t, expected, string(*conflicting.Value))
+	}
+	assert.Assert(t, withConflictingValue(&metric.Metric{}) == nil)
+}
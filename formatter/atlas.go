@@ -67,7 +67,7 @@ func (f *atlas) FormatData(metrics *[]*metric.Metric) *[]*[]byte {
 		metricTags := fmt.Sprintf("name=%s, atlas.dstype=%s", *(*metrics)[i].Name, *(*metrics)[i].Metadata.MetricType)
 		r[(7*i)+1+4] = f.FormatTags(&metricTags)
 		r[(7*i)+2+4] = &bytesForCommaTimestampColon
-		byteTs := []byte(fmt.Sprintf("%d", *((*metrics)[i].Timestamp)/1000))
+		byteTs := []byte(fmt.Sprintf("%d", unixMicroseconds(*((*metrics)[i].Timestamp))))
 		r[(7*i)+3+4] = &byteTs
 		r[(7*i)+4+4] = &bytesForCommaValueColon
 		r[(7*i)+5+4] = (*metrics)[i].Value
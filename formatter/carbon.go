@@ -26,7 +26,7 @@ func (f *carbon) FormatData(metrics *[]*metric.Metric) *[]*[]byte {
 	r := make([]*[]byte, 8*len(*metrics))
 
 	for i, m := range *metrics {
-		byteTs := []byte(fmt.Sprintf("%d", *(m.Timestamp)/1000/1000/1000))
+		byteTs := []byte(fmt.Sprintf("%d", unixSeconds(*m.Timestamp)))
 
 		r[(8 * i)] = m.Name
 		r[(8*i)+1] = m.Metadata.Tags
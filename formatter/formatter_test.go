@@ -258,3 +258,58 @@ func TestM3DBSingleMetricWithTagsFormat(t *testing.T) {
 	formattedString := sb.String()
 	assert.Equal(t, formattedString, "{\"namespace\":\"default\",\"id\":\"foo\",\"tags\":[{\"name\":\"__name__\",\"value\":\"testValue\"},{\"name\":\"tag1\",\"value\":\"value1\"},{\"name\":\"tag2\",\"value\":\"value2\"}],\"datapoint\":{\"timestamp\":1257894000,\"value\":42}}")
 }
+
+func TestOTLPExponentialHistogramFormat(t *testing.T) {
+	otlpFormatter := NewOTLPFormatter()
+
+	byteName := []byte("latency")
+	tags := "node=localhost"
+	byteTags := otlpFormatter.FormatTags(&tags)
+	byteType := []byte("gauge")
+	staticMeta := metric.MetricStaticMetadata{Name: &byteName, Tags: byteTags, MetricType: &byteType}
+
+	workerTags := "worker=1"
+	byteWorkerTags := otlpFormatter.FormatTags(&workerTags)
+	byteArrValue := []byte("2.5000")
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC).UnixNano()
+	histogram := metric.ExponentialHistogram{Scale: 1, Count: 2, Sum: 5, Min: 2, Max: 3, PositiveOffset: 1, PositiveCounts: []uint64{1, 1}, StartTimestamp: timestamp - 1000000000}
+
+	m := metric.Metric{Metadata: &staticMeta, Name: &byteName, Tags: byteWorkerTags, Value: &byteArrValue, Timestamp: &timestamp, Histogram: &histogram}
+	mArr := []*metric.Metric{&m}
+
+	formattedMetric := otlpFormatter.FormatData(&mArr)
+
+	var sb strings.Builder
+	for _, bytePtr := range *formattedMetric {
+		sb.WriteString(string(*bytePtr))
+	}
+
+	formattedString := sb.String()
+	assert.Equal(t, formattedString, `{"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"lagrande"},"metrics":[{"name":"latency","exponentialHistogram":{"aggregationTemporality":1,"dataPoints":[{"attributes":[{"key":"node","value":{"stringValue":"localhost"}},{"key":"worker","value":{"stringValue":"1"}}],"startTimeUnixNano":"1257893999000000000","timeUnixNano":"1257894000000000000","count":"2","sum":5,"scale":1,"zeroCount":"0","positive":{"offset":1,"bucketCounts":["1","1"]},"negative":{"offset":0,"bucketCounts":[]},"min":2,"max":3}]}}]}]}]}`)
+}
+
+func TestOTLPSpecialValuesFormat(t *testing.T) {
+	otlpFormatter := NewOTLPFormatter()
+
+	byteName := []byte("temperature")
+	byteType := []byte("gauge")
+	staticMeta := metric.MetricStaticMetadata{Name: &byteName, MetricType: &byteType}
+	timestamp := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC).UnixNano()
+
+	nan := []byte("NaN")
+	inf := []byte("-Inf")
+	mArr := []*metric.Metric{
+		{Metadata: &staticMeta, Name: &byteName, Value: &nan, Timestamp: &timestamp, Stale: true},
+		{Metadata: &staticMeta, Name: &byteName, Value: &inf, Timestamp: &timestamp},
+	}
+
+	formattedMetric := otlpFormatter.FormatData(&mArr)
+
+	var sb strings.Builder
+	for _, bytePtr := range *formattedMetric {
+		sb.WriteString(string(*bytePtr))
+	}
+
+	formattedString := sb.String()
+	assert.Equal(t, formattedString, `{"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"lagrande"},"metrics":[{"name":"temperature","gauge":{"dataPoints":[{"attributes":[],"timeUnixNano":"1257894000000000000","flags":1}]}},{"name":"temperature","gauge":{"dataPoints":[{"attributes":[],"timeUnixNano":"1257894000000000000","asDouble":"-Infinity"}]}}]}]}]}`)
+}
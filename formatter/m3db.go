@@ -52,7 +52,7 @@ func (f *m3db) FormatData(metrics *[]*metric.Metric) *[]*[]byte {
 	r[6] = &byteForComma
 	r[7] = f.finalizeTags((*metrics)[0].Metadata.Tags, (*metrics)[0].Name)
 	r[8] = &bytesForCommaDatapointColonTimestampColon
-	byteTs := []byte(fmt.Sprintf("%d", *((*metrics)[0].Timestamp)/1000/1000/1000))
+	byteTs := []byte(fmt.Sprintf("%d", unixSeconds(*((*metrics)[0].Timestamp))))
 	r[9] = &byteTs
 	r[10] = &bytesForCommaValueColon
 	r[11] = (*metrics)[0].Value
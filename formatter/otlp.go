@@ -0,0 +1,178 @@
+package formatter
+
+// references:
+//  - https://opentelemetry.io/docs/specs/otlp/#otlphttp
+//  - https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+// Metrics are sent as OTLP/HTTP JSON, one data point per metric. Counters are sent as cumulative monotonic sums, gauges
+// as gauges and metrics carrying an exponential histogram as delta exponential histograms. Staleness markers are sent
+// as data points flagged with no recorded value.
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aleveille/lagrande/metric"
+)
+
+const (
+	otlpDeltaTemporality      = 1
+	otlpCumulativeTemporality = 2
+	otlpNoRecordedValueFlag   = 1
+)
+
+// The JSON encoding of OTLP (proto3) writes the special float values as strings
+var otlpSpecialDoubles = map[string]string{
+	"NaN":  `"NaN"`,
+	"+Inf": `"Infinity"`,
+	"-Inf": `"-Infinity"`,
+}
+
+type otlp struct {
+}
+
+var otlpTagTokenizerRE = regexp.MustCompile(`[^,=]+=[^,]+`)
+
+func NewOTLPFormatter() Formatter {
+	return &otlp{}
+}
+
+// Format according to the OTLP JSON encoding:
+// {"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"lagrande"},"metrics":[...]}]}]}
+func (f *otlp) FormatData(metrics *[]*metric.Metric) *[]*[]byte {
+	var b bytes.Buffer
+	b.WriteString(`{"resourceMetrics":[{"resource":{},"scopeMetrics":[{"scope":{"name":"lagrande"},"metrics":[`)
+
+	for i, m := range *metrics {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`{"name":`)
+		writeJSONString(&b, string(*m.Name))
+
+		var attributes []byte
+		if m.Metadata.Tags != nil {
+			attributes = append(attributes, *m.Metadata.Tags...)
+		}
+		if m.Tags != nil && len(*m.Tags) > 0 {
+			if len(attributes) > 0 {
+				attributes = append(attributes, ',')
+			}
+			attributes = append(attributes, *m.Tags...)
+		}
+
+		switch {
+		case m.Histogram != nil:
+			f.writeExponentialHistogram(&b, m, attributes)
+		case m.Metadata.MetricType != nil && string(*m.Metadata.MetricType) == "counter":
+			b.WriteString(`,"sum":{"aggregationTemporality":`)
+			b.WriteString(strconv.Itoa(otlpCumulativeTemporality))
+			b.WriteString(`,"isMonotonic":true,"dataPoints":[`)
+			f.writeNumberDataPoint(&b, m, attributes)
+			b.WriteString(`]}}`)
+		default:
+			b.WriteString(`,"gauge":{"dataPoints":[`)
+			f.writeNumberDataPoint(&b, m, attributes)
+			b.WriteString(`]}}`)
+		}
+	}
+
+	b.WriteString(`]}]}]}`)
+	r := b.Bytes()
+	return &[]*[]byte{&r}
+}
+
+func (f *otlp) writeNumberDataPoint(b *bytes.Buffer, m *metric.Metric, attributes []byte) {
+	b.WriteString(`{"attributes":[`)
+	b.Write(attributes)
+	b.WriteString(`],"timeUnixNano":"`)
+	b.WriteString(strconv.FormatInt(*m.Timestamp, 10))
+	if m.Stale {
+		b.WriteString(`","flags":`)
+		b.WriteString(strconv.Itoa(otlpNoRecordedValueFlag))
+		b.WriteByte('}')
+		return
+	}
+	b.WriteString(`","asDouble":`)
+	if special, ok := otlpSpecialDoubles[string(*m.Value)]; ok {
+		b.WriteString(special)
+	} else {
+		b.Write(*m.Value)
+	}
+	b.WriteByte('}')
+}
+
+func (f *otlp) writeExponentialHistogram(b *bytes.Buffer, m *metric.Metric, attributes []byte) {
+	h := m.Histogram
+
+	b.WriteString(`,"exponentialHistogram":{"aggregationTemporality":`)
+	b.WriteString(strconv.Itoa(otlpDeltaTemporality))
+	b.WriteString(`,"dataPoints":[{"attributes":[`)
+	b.Write(attributes)
+	b.WriteString(`],"startTimeUnixNano":"`)
+	b.WriteString(strconv.FormatInt(h.StartTimestamp, 10))
+	b.WriteString(`","timeUnixNano":"`)
+	b.WriteString(strconv.FormatInt(*m.Timestamp, 10))
+	b.WriteString(`","count":"`)
+	b.WriteString(strconv.FormatUint(h.Count, 10))
+	b.WriteString(`","sum":`)
+	b.WriteString(strconv.FormatFloat(h.Sum, 'f', -1, 64))
+	b.WriteString(`,"scale":`)
+	b.WriteString(strconv.FormatInt(int64(h.Scale), 10))
+	b.WriteString(`,"zeroCount":"`)
+	b.WriteString(strconv.FormatUint(h.ZeroCount, 10))
+	b.WriteString(`","positive":`)
+	writeOTLPBuckets(b, h.PositiveOffset, h.PositiveCounts)
+	b.WriteString(`,"negative":`)
+	writeOTLPBuckets(b, h.NegativeOffset, h.NegativeCounts)
+	if h.Count > 0 {
+		b.WriteString(`,"min":`)
+		b.WriteString(strconv.FormatFloat(h.Min, 'f', -1, 64))
+		b.WriteString(`,"max":`)
+		b.WriteString(strconv.FormatFloat(h.Max, 'f', -1, 64))
+	}
+	b.WriteString(`}]}}`)
+}
+
+func writeOTLPBuckets(b *bytes.Buffer, offset int32, counts []uint64) {
+	b.WriteString(`{"offset":`)
+	b.WriteString(strconv.FormatInt(int64(offset), 10))
+	b.WriteString(`,"bucketCounts":[`)
+	for i, c := range counts {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('"')
+		b.WriteString(strconv.FormatUint(c, 10))
+		b.WriteByte('"')
+	}
+	b.WriteString(`]}`)
+}
+
+// Format a series of comma-delimited strings of key=value into OTLP JSON attributes, without the enclosing brackets:
+// {"key":"<tag-key>","value":{"stringValue":"<tag-value>"}},...
+func (f *otlp) FormatTags(tags *string) *[]byte {
+	var b bytes.Buffer
+
+	for i, m := range otlpTagTokenizerRE.FindAllString(*tags, -1) {
+		kv := strings.SplitN(m, "=", 2)
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`{"key":`)
+		writeJSONString(&b, kv[0])
+		b.WriteString(`,"value":{"stringValue":`)
+		writeJSONString(&b, kv[1])
+		b.WriteString(`}}`)
+	}
+
+	byteStr := b.Bytes()
+	return &byteStr
+}
+
+func writeJSONString(b *bytes.Buffer, s string) {
+	quoted, _ := json.Marshal(s)
+	b.Write(quoted)
+}
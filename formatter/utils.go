@@ -1,5 +1,7 @@
 package formatter
 
+import "time"
+
 var byteForEmpty = []byte("")
 var byteForSpace = []byte(" ")
 var byteForEqual = []byte("=")
@@ -18,3 +20,13 @@ var bytesForCommaDatapointColonTimestampColon = []byte(",\"datapoint\":{\"timest
 var bytesForCommaValueColon = []byte(",\"value\":")
 var bytesForValueEquals = []byte("value=")
 var bytesForCommaMetricsColon = []byte(",\"metrics\":")
+
+// The metrics timestamps are in nanoseconds since the epoch, these convert them to the unit of each format
+
+func unixSeconds(timestamp int64) int64 {
+	return timestamp / int64(time.Second)
+}
+
+func unixMicroseconds(timestamp int64) int64 {
+	return timestamp / int64(time.Microsecond)
+}
@@ -0,0 +1,358 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aleveille/lagrande/metric"
+)
+
+// Any generator can be fanned out into many tag combinations per worker, to stress the index of the TSDB:
+//  - dimensions: space-delimited list of tag dimensions, each being either <key>=<count> (values <key>0 to
+//    <key><count-1>) or <key>=<value>|<value>|... (eg: 'region=us|eu|ap service=200 endpoint=50')
+//  - combinations: number of tag combinations each worker emits, sampled from the Cartesian product of the dimensions.
+//    Defaults to the whole product.
+//  - activity: uniform (every combination emits at every tick) or zipf, where the combination of rank k emits at each
+//    tick with a probability of 1/k^zipfExponent, so that a few combinations are hot and the long tail is sparse
+// Each combination gets its own clone of the generator, with the combination appended to the tags of the worker. With
+// activity: zipf, each worker ranks its combinations in a random order. The combinations are picked and cloned once per
+// worker, when it's seeded (or on its first tick if it isn't), so that they're drawn from the seed of the worker.
+
+// Above this many tag combinations, combinations must be set so that workers don't try to emit them all
+const maxCardinalityCombinations = 10000000
+
+type cardinalityDimension struct {
+	key    string
+	values []string
+}
+
+type cardinality struct {
+	name       string
+	workerTags *[]byte
+	clones     []Generator // nil until the combinations are picked
+	activity   []float64   // Probability of each clone to emit at each tick, nil if they all emit at every tick
+	seed       int64
+	value      float64              // Sum of the values of the combinations emitted at the last tick
+	random     *rand.Rand           // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock                // nil for the wall clock
+	sources    map[string]*recorder // The generators the clones reference, nil until bound to the worker
+	sharedData *cardinalitySharedData
+}
+
+type cardinalitySharedData struct {
+	generator    Generator
+	dimensions   []cardinalityDimension
+	product      int
+	combinations int
+	activity     []float64 // Probability to emit at each tick by rank, nil for activity: uniform
+	zipfExponent float64
+}
+
+// WithDimensions returns gen fanned out into the tag combinations given by the dimensions and combinations parameters
+// of its config, or gen itself if there are no dimensions. The result is compliant with the Generator,
+// SeriesGenerator and RandomGenerator interfaces.
+func WithDimensions(gen Generator, config CLIConfig) (Generator, error) {
+	var dimensions []cardinalityDimension
+	confCombinations := 0
+	confActivity := "uniform"
+	confZipfExponent := 1.0
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "dimensions":
+			dimensions = nil
+			for _, token := range strings.Fields(value) {
+				dimension, err := parseCardinalityDimension(token)
+				if err != nil {
+					return nil, err
+				}
+				dimensions = append(dimensions, dimension)
+			}
+		case "combinations":
+			v, err := strconv.Atoi(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing combinations '%s', it must be a > 0 integer", value)
+			}
+			confCombinations = v
+		case "activity":
+			if value != "uniform" && value != "zipf" {
+				return nil, fmt.Errorf("Invalid activity '%s', must be 'uniform' or 'zipf'", value)
+			}
+			confActivity = value
+		case "zipfExponent":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing zipfExponent '%s', it must be a > 0 number", value)
+			}
+			confZipfExponent = v
+		}
+	}
+
+	if len(dimensions) == 0 {
+		if confCombinations > 0 || confActivity != "uniform" {
+			return nil, fmt.Errorf("The combinations and activity parameters require dimensions")
+		}
+		return gen, nil
+	}
+
+	product := 1
+	for _, d := range dimensions {
+		if product > int(^uint(0)>>1)/len(d.values) {
+			return nil, fmt.Errorf("The dimensions have too many tag combinations")
+		}
+		product *= len(d.values)
+	}
+	if product > maxCardinalityCombinations && (confCombinations == 0 || confCombinations > maxCardinalityCombinations) {
+		return nil, fmt.Errorf("The dimensions have %d tag combinations, set combinations to sample at most %d of them per worker", product, maxCardinalityCombinations)
+	}
+	if confCombinations == 0 || confCombinations > product {
+		confCombinations = product
+	}
+
+	sharedData := &cardinalitySharedData{
+		generator:    gen,
+		dimensions:   dimensions,
+		product:      product,
+		combinations: confCombinations,
+		zipfExponent: confZipfExponent,
+	}
+	if confActivity == "zipf" {
+		sharedData.activity = make([]float64, confCombinations)
+		for k := range sharedData.activity {
+			sharedData.activity[k] = math.Pow(float64(k+1), -confZipfExponent)
+		}
+	}
+
+	g := &cardinality{name: gen.GetName(), sharedData: sharedData}
+	g.setSeed(rand.Int63())
+	return g, nil
+}
+
+// parseCardinalityDimension parses <key>=<count> or <key>=<value>|<value>|...
+func parseCardinalityDimension(token string) (cardinalityDimension, error) {
+	kv := strings.SplitN(token, "=", 2)
+	if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+		return cardinalityDimension{}, fmt.Errorf("Error parsing dimension '%s', it must be <key>=<count> or <key>=<value>|<value>|...", token)
+	}
+
+	d := cardinalityDimension{key: kv[0]}
+	if count, err := strconv.Atoi(kv[1]); err == nil {
+		if count <= 0 {
+			return cardinalityDimension{}, fmt.Errorf("Error parsing dimension '%s', the count must be a > 0 integer", token)
+		}
+		d.values = make([]string, count)
+		for i := range d.values {
+			d.values[i] = d.key + strconv.Itoa(i)
+		}
+	} else {
+		d.values = strings.Split(kv[1], "|")
+	}
+	return d, nil
+}
+
+// Clone the current generator into a new struct, the wrapped generator is cloned for each tag combination when the
+// new struct is seeded
+func (g cardinality) Clone(newName string, specificTags *[]byte) Generator {
+	newg := cardinality{name: newName, workerTags: specificTags, sharedData: g.sharedData}
+	newg.setSeed(rand.Int63())
+	return &newg
+}
+
+// Seed picks the tag combinations and clones the wrapped generator for each of them the first time it's called. Later
+// calls only seed the clones again: they keep their combination and their state (eg: counters).
+func (g *cardinality) Seed(seed int64) {
+	g.setSeed(seed)
+	if g.clones == nil {
+		g.pickCombinations()
+		return
+	}
+	for i, clone := range g.clones {
+		Seed(clone, combinationSeed(seed, i))
+	}
+}
+
+func (g *cardinality) setSeed(seed int64) {
+	g.seed = seed
+	g.random = newRandom(seed)
+}
+
+func combinationSeed(seed int64, i int) int64 {
+	return int64(splitMix64(uint64(seed) ^ uint64(i)))
+}
+
+// pickCombinations picks the tag combinations of the worker and clones the wrapped generator for each of them, unless
+// it's already done
+func (g *cardinality) pickCombinations() {
+	if g.clones != nil {
+		return
+	}
+	s := g.sharedData
+
+	g.clones = make([]Generator, 0, s.combinations)
+	for i, combination := range sampleCombinations(s.product, s.combinations, g.random) {
+		clone := s.generator.Clone(g.name, seriesTags(g.workerTags, s.combinationTags(combination)))
+		Seed(clone, combinationSeed(g.seed, i))
+		if g.clock != nil {
+			SetClock(clone, g.clock)
+		}
+		if g.sources != nil {
+			bind(clone, g.sources)
+		}
+		g.clones = append(g.clones, clone)
+	}
+
+	if s.activity != nil {
+		g.activity = make([]float64, len(s.activity))
+		for i, rank := range g.random.Perm(len(s.activity)) {
+			g.activity[i] = s.activity[rank]
+		}
+	}
+}
+
+// SetClock replaces the clock of the clones of every combination
+func (g *cardinality) SetClock(clock Clock) {
+	g.clock = clock
+	for _, clone := range g.clones {
+		SetClock(clone, clock)
+	}
+}
+
+// Restart resets the clones of every combination
+func (g *cardinality) Restart() {
+	for _, clone := range g.clones {
+		Restart(clone)
+	}
+}
+
+// tagKeys returns the keys of the dimensions
+func (g *cardinality) tagKeys() []string {
+	keys := make([]string, len(g.sharedData.dimensions))
+	for i, d := range g.sharedData.dimensions {
+		keys[i] = d.key
+	}
+	return append(keys, TagKeys(g.sharedData.generator)...)
+}
+
+func (g *cardinality) references() []string {
+	return references(g.sharedData.generator)
+}
+
+// bind binds the clones of every combination, they all read the same generators
+func (g *cardinality) bind(sources map[string]*recorder) {
+	g.sources = sources
+	for _, clone := range g.clones {
+		bind(clone, sources)
+	}
+}
+
+// lastValue is the sum of the values of the combinations emitted at the last tick
+func (g *cardinality) lastValue() (float64, bool) {
+	if _, ok := lastValue(g.sharedData.generator); !ok {
+		return 0, false
+	}
+	return g.value, true
+}
+
+// sampleCombinations returns n distinct combination indexes between 0 and product, sorted
+func sampleCombinations(product int, n int, random *rand.Rand) []int {
+	indexes := make([]int, 0, n)
+	if n == product {
+		for i := 0; i < n; i++ {
+			indexes = append(indexes, i)
+		}
+		return indexes
+	}
+
+	// Floyd's algorithm: n draws, without building the whole product
+	picked := make(map[int]bool, n)
+	for j := product - n; j < product; j++ {
+		i := int(random.Int63n(int64(j) + 1))
+		if picked[i] {
+			i = j
+		}
+		picked[i] = true
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// combinationTags returns the 'key=value,...' tags of the combination at index of the Cartesian product
+func (s *cardinalitySharedData) combinationTags(index int) string {
+	tags := make([]string, len(s.dimensions))
+	for i := len(s.dimensions) - 1; i >= 0; i-- {
+		d := s.dimensions[i]
+		tags[i] = d.key + "=" + d.values[index%len(d.values)]
+		index /= len(d.values)
+	}
+	return strings.Join(tags, ",")
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *cardinality) GetName() string {
+	return g.name
+}
+
+// Return a human-readable description of the generator
+func (g *cardinality) ToString() string {
+	s := g.sharedData
+	dimensions := make([]string, len(s.dimensions))
+	for i, d := range s.dimensions {
+		dimensions[i] = fmt.Sprintf("%s: %d", d.key, len(d.values))
+	}
+
+	description := fmt.Sprintf("%s, fanned out into %d tag combinations per worker", s.generator.ToString(), s.combinations)
+	if s.combinations < s.product {
+		description += " sampled from"
+	} else {
+		description += " of"
+	}
+	description += fmt.Sprintf(" %s", strings.Join(dimensions, ", "))
+
+	if s.activity != nil {
+		expected := 0.0
+		for _, p := range s.activity {
+			expected += p
+		}
+		description += fmt.Sprintf(", with a zipf activity of exponent %.2f (%.1f combinations per tick on average)", s.zipfExponent, expected)
+	}
+	return description
+}
+
+// Return the maximum number of series generated at each tick: the series of the wrapped generator for each combination
+func (g *cardinality) SeriesCount() int {
+	return g.sharedData.combinations * SeriesCount(g.sharedData.generator)
+}
+
+// Generates the metric of the first combination only, workers call GenerateMetrics to get all the series
+func (g *cardinality) GenerateMetric() *metric.Metric {
+	g.pickCombinations()
+	return AppendMetrics(nil, g.clones[0])[0]
+}
+
+// Generates the metrics of every combination
+func (g *cardinality) GenerateMetrics() []*metric.Metric {
+	g.pickCombinations()
+	metrics := make([]*metric.Metric, 0, g.SeriesCount())
+	g.value = 0
+	for i, clone := range g.clones {
+		if g.activity != nil && g.random.Float64() >= g.activity[i] {
+			continue
+		}
+		emitted := len(metrics)
+		metrics = AppendMetrics(metrics, clone)
+		if len(metrics) > emitted {
+			value, _ := lastValue(clone)
+			g.value += value
+		}
+	}
+	return metrics
+}
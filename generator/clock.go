@@ -0,0 +1,93 @@
+package generator
+
+import "time"
+
+// Clock tells the generators what time it is, so that they can generate data for another time than now (eg: to
+// backfill history). Each clone has its own clock, nil standing for the wall clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// RealClock is the wall clock
+var RealClock Clock = realClock{}
+
+// VirtualClock is a clock that only moves when it's told to. It isn't thread-safe, each worker has its own.
+type VirtualClock struct {
+	now time.Time
+}
+
+// NewVirtualClock returns a virtual clock set to start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the time the clock was set to
+func (c *VirtualClock) Now() time.Time {
+	return c.now
+}
+
+// Set moves the clock to now
+func (c *VirtualClock) Set(now time.Time) {
+	c.now = now
+}
+
+// OffsetClock is a clock shifted by an offset from another clock, eg: to simulate hosts whose clock is skewed
+type OffsetClock struct {
+	clock  Clock
+	offset time.Duration
+}
+
+// NewOffsetClock returns clock shifted by offset
+func NewOffsetClock(clock Clock, offset time.Duration) *OffsetClock {
+	return &OffsetClock{clock: clock, offset: offset}
+}
+
+// Now returns the time of the shifted clock plus the offset
+func (c *OffsetClock) Now() time.Time {
+	return clockNow(c.clock).Add(c.offset)
+}
+
+// AlignedClock is a clock truncated to the multiples of a step since the epoch, so that the timestamps line up on
+// step boundaries
+type AlignedClock struct {
+	clock Clock
+	step  time.Duration
+}
+
+// NewAlignedClock returns clock truncated to the multiples of step
+func NewAlignedClock(clock Clock, step time.Duration) *AlignedClock {
+	return &AlignedClock{clock: clock, step: step}
+}
+
+// Now returns the time of the aligned clock, truncated to the step
+func (c *AlignedClock) Now() time.Time {
+	return clockNow(c.clock).Truncate(c.step)
+}
+
+// ClockedGenerator is implemented by generators that read the time. SetClock replaces the clock of the clone and
+// resets what the clone based on the time (eg: when it started).
+type ClockedGenerator interface {
+	Generator
+	SetClock(clock Clock)
+}
+
+// SetClock replaces the clock of a generator, if it reads the time
+func SetClock(g Generator, clock Clock) {
+	if cg, ok := g.(ClockedGenerator); ok {
+		cg.SetClock(clock)
+	}
+}
+
+// clockNow returns the time of clock, or the wall clock time if clock is nil
+func clockNow(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
@@ -0,0 +1,282 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+)
+
+// The cpu generator emits, for each core, the percentage of time spent in each mode like node_exporter's CPU collector
+// would, tagged with cpu=<core> and mode=<mode>. The modes of a core always sum to exactly 100.
+//
+// The busy time (100 - idle) of a core follows:
+//  - a diurnal baseline: base +/- amplitude over period, peaking in the middle of the period (noon UTC with the default 24h)
+//  - gaussian noise of standard deviation noise
+//  - saturation bursts: at each tick, a core has a burstProbability chance to be saturated for burstDuration
+// The busy time is then split between user, system, iowait and steal with shares that slightly vary at each tick.
+
+var cpuModes = []string{"user", "system", "iowait", "steal", "idle"}
+
+// Average share of the busy time of each busy mode, idle being the rest
+var cpuBusyShares = []float64{0.65, 0.20, 0.10, 0.05}
+
+// The modes are computed in 1/10000 of percent (4 decimals) so that they sum to exactly 100
+const cpuUnitsPerPercent = 10000
+
+type cpu struct {
+	name       *[]byte
+	tags       []*[]byte // cpu/mode tags of each series, stable for the whole life of the worker
+	offsets    []float64 // Per-core deviation from the baseline, so that cores aren't all equally busy
+	burstUntil []time.Time
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
+	sharedData *cpuSharedData
+}
+
+type cpuSharedData struct {
+	metadata         *metric.MetricStaticMetadata
+	cores            int
+	base             float64
+	amplitude        float64
+	period           time.Duration
+	noise            float64
+	burstProbability float64
+	burstDuration    time.Duration
+
+	formatter *formatter.Formatter
+}
+
+// NewCPUGenerator returns a struct compliant with the Generator and SeriesGenerator interfaces
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewCPUGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	confName := "cpu"
+	confCores := 4
+	confBase := 30.0
+	confAmplitude := 20.0
+	confPeriod := 24 * time.Hour
+	confNoise := 5.0
+	confBurstProbability := 0.001
+	confBurstDuration := 30 * time.Second
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "name":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("Error parsing cpu name '%s'", value)
+			}
+			confName = value
+		case "cores":
+			v, err := strconv.Atoi(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing cpu cores '%s', it must be a > 0 integer", value)
+			}
+			confCores = v
+		case "base":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 || v > 100 {
+				return nil, fmt.Errorf("Error parsing cpu base '%s', it must be a percentage between 0 and 100", value)
+			}
+			confBase = v
+		case "amplitude":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing cpu amplitude '%s', it must be a >= 0 percentage", value)
+			}
+			confAmplitude = v
+		case "period":
+			v, err := time.ParseDuration(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing cpu period '%s', it must be a > 0 Go Duration", value)
+			}
+			confPeriod = v
+		case "noise":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing cpu noise '%s', it must be a >= 0 percentage", value)
+			}
+			confNoise = v
+		case "burstProbability":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 || v > 1 {
+				return nil, fmt.Errorf("Error parsing cpu burstProbability '%s', it must be a probability between 0 and 1", value)
+			}
+			confBurstProbability = v
+		case "burstDuration":
+			v, err := time.ParseDuration(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing cpu burstDuration '%s', it must be a > 0 Go Duration", value)
+			}
+			confBurstDuration = v
+		}
+	}
+
+	metricName := []byte(confName)
+	metricType := []byte("gauge")
+
+	staticMeta := &metric.MetricStaticMetadata{
+		Name:       &metricName,
+		Tags:       tags,
+		MetricType: &metricType,
+	}
+
+	sharedData := &cpuSharedData{
+		metadata:         staticMeta,
+		cores:            confCores,
+		base:             confBase,
+		amplitude:        confAmplitude,
+		period:           confPeriod,
+		noise:            confNoise,
+		burstProbability: confBurstProbability,
+		burstDuration:    confBurstDuration,
+		formatter:        f,
+	}
+
+	g := &cpu{sharedData: sharedData}
+	g.initSeries(nil)
+	g.Seed(rand.Int63())
+	return g, nil
+}
+
+// initSeries creates the per-core state and the tags of each series, Seed draws the deviation of each core
+func (g *cpu) initSeries(workerTags *[]byte) {
+	cores := g.sharedData.cores
+	g.tags = make([]*[]byte, cores*len(cpuModes))
+	g.offsets = make([]float64, cores)
+	g.burstUntil = make([]time.Time, cores)
+
+	for c := 0; c < cores; c++ {
+		for m, mode := range cpuModes {
+			g.tags[c*len(cpuModes)+m] = seriesTags(workerTags, fmt.Sprintf("cpu=%d,mode=%s", c, mode))
+		}
+	}
+}
+
+// Seed replaces the random source of the generator and redraws the per-core deviations
+func (g *cpu) Seed(seed int64) {
+	g.random = newRandom(seed)
+	for c := range g.offsets {
+		g.offsets[c] = g.random.NormFloat64() * g.sharedData.noise
+	}
+}
+
+// Clone the current generator into a new struct with its own per-core state and the same pointer for sharedData
+func (g cpu) Clone(newName string, specificTags *[]byte) Generator {
+	newg := cpu{sharedData: g.sharedData}
+	newNameBytes := []byte(newName)
+	newg.name = &newNameBytes
+	newg.initSeries(specificTags)
+	newg.Seed(rand.Int63())
+	return &newg
+}
+
+// SetClock replaces the clock the generator follows and ends the ongoing bursts
+func (g *cpu) SetClock(clock Clock) {
+	g.clock = clock
+	g.burstUntil = make([]time.Time, len(g.burstUntil))
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *cpu) GetName() string {
+	if g.name != nil {
+		return string(*g.name)
+	}
+	return string(*g.sharedData.metadata.Name)
+}
+
+// Return a human-readable description of the generator
+func (g *cpu) ToString() string {
+	return fmt.Sprintf("CPU generator (%s) of %d cores (%d series) busy %.2f%% +/- %.2f%% over %s with a noise of %.2f%% and a %.2f%% chance per tick of a %s saturation burst", *g.sharedData.metadata.Name, g.sharedData.cores, g.SeriesCount(), g.sharedData.base, g.sharedData.amplitude, g.sharedData.period, g.sharedData.noise, g.sharedData.burstProbability*100, g.sharedData.burstDuration)
+}
+
+// Return the number of series generated at each tick: one per core and mode
+func (g *cpu) SeriesCount() int {
+	return g.sharedData.cores * len(cpuModes)
+}
+
+// lastValue is the sum of the modes of all the cores: 100 per core
+func (g *cpu) lastValue() (float64, bool) {
+	return float64(g.sharedData.cores) * 100, true
+}
+
+// Generates the metric of the first core and mode only, workers call GenerateMetrics to get all the series
+func (g *cpu) GenerateMetric() *metric.Metric {
+	return g.GenerateMetrics()[0]
+}
+
+// Generates a metric for each core and mode
+func (g *cpu) GenerateMetrics() []*metric.Metric {
+	now := clockNow(g.clock)
+	timestamp := now.UnixNano()
+	metrics := make([]*metric.Metric, 0, g.SeriesCount())
+
+	// Diurnal baseline, shared by all the cores
+	phase := float64(now.UnixNano()%int64(g.sharedData.period)) / float64(g.sharedData.period)
+	baseline := g.sharedData.base - g.sharedData.amplitude*math.Cos(2*math.Pi*phase)
+
+	for c := 0; c < g.sharedData.cores; c++ {
+		if now.After(g.burstUntil[c]) && g.random.Float64() < g.sharedData.burstProbability {
+			g.burstUntil[c] = now.Add(g.sharedData.burstDuration)
+		}
+
+		var busy float64
+		if now.Before(g.burstUntil[c]) {
+			busy = 97 + 3*g.random.Float64()
+		} else {
+			busy = baseline + g.offsets[c] + g.random.NormFloat64()*g.sharedData.noise
+		}
+
+		for i, units := range splitCPUBusy(busy, g.random) {
+			metrics = append(metrics, &metric.Metric{
+				Metadata:  g.sharedData.metadata,
+				Name:      g.name,
+				Value:     decimalsToByteArrPtr(units, false),
+				Tags:      g.tags[c*len(cpuModes)+i],
+				Timestamp: &timestamp,
+			})
+		}
+	}
+
+	return metrics
+}
+
+// splitCPUBusy splits the busy percentage between the modes, in the order of cpuModes. The values are in
+// 1/cpuUnitsPerPercent of percent and sum to exactly 100 percent.
+func splitCPUBusy(busy float64, random *rand.Rand) []int64 {
+	busy = math.Max(0, math.Min(100, busy))
+	busyUnits := int64(math.Round(busy * cpuUnitsPerPercent))
+
+	shares := make([]float64, len(cpuBusyShares))
+	total := 0.0
+	for i, share := range cpuBusyShares {
+		shares[i] = math.Max(0, share*(1+0.2*random.NormFloat64()))
+		total += shares[i]
+	}
+
+	units := make([]int64, len(cpuModes))
+	remaining := busyUnits
+	for i := range shares {
+		if i == len(shares)-1 || total == 0 {
+			// The last busy mode gets what's left so that the rounding doesn't change the busy total
+			units[i] = remaining
+			break
+		}
+		units[i] = int64(math.Round(float64(busyUnits) * shares[i] / total))
+		if units[i] > remaining {
+			units[i] = remaining
+		}
+		remaining -= units[i]
+	}
+	units[len(units)-1] = 100*cpuUnitsPerPercent - busyUnits
+
+	return units
+}
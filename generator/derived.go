@@ -0,0 +1,318 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+)
+
+// The derived generator emits a float gauge computed at each tick from the values of the other generators of the
+// worker, eg: 'requests * 0.02 + noise(1)' for an error count following the requests, or 'used / total' for a ratio.
+// See expression.go for the syntax. Generators are referenced by their name, as specified in their config, and their
+// value is the one they generated at the tick, before it's encoded (eg: as NaN or a staleness marker). The cpu and
+// memory generators and the generators with dimensions emit several series, their value is the sum of the values of
+// the series (the cpu modes sum to 100 per core and the memory states to the total memory). The generators whose
+// series don't add up (eg: histogram, summary or http) can't be referenced.
+//
+// Generators are generated in the order they are declared: a derived generator declared after the generators it
+// references reads their value of the current tick. Referencing a generator declared later, or the derived generator
+// itself, reads its value of the previous tick (eg: 'total + requests' accumulates), 0 before its first tick. A
+// generator that skipped a tick keeps its last value.
+
+type derived struct {
+	name       *[]byte
+	tags       *[]byte
+	sources    []*recorder // The generators of the variables, nil until bound to the worker
+	scope      expressionScope
+	value      float64 // Value of the last tick, before it's encoded
+	clock      Clock   // nil for the wall clock
+	sharedData *derivedSharedData
+}
+
+type derivedSharedData struct {
+	metadata   *metric.MetricStaticMetadata
+	expression *expression
+	encoding   *valueEncoding
+
+	formatter *formatter.Formatter
+}
+
+// NewDerivedGenerator returns a struct compliant with the Generator interface
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewDerivedGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	confName := "derived"
+	var confExpression *expression
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "name":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("Error parsing derived name '%s'", value)
+			}
+			confName = value
+		case "expression":
+			e, err := parseExpression(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing derived expression '%s': %s", value, err)
+			}
+			confExpression = e
+		}
+	}
+
+	if confExpression == nil {
+		return nil, fmt.Errorf("The derived generator requires an expression")
+	}
+
+	encoding, err := parseValueEncoding("derived", config)
+	if err != nil {
+		return nil, err
+	}
+
+	metricName := []byte(confName)
+	metricType := []byte("gauge")
+
+	staticMeta := &metric.MetricStaticMetadata{
+		Name:       &metricName,
+		Tags:       tags,
+		MetricType: &metricType,
+	}
+
+	sharedData := &derivedSharedData{
+		metadata:   staticMeta,
+		expression: confExpression,
+		encoding:   encoding,
+		formatter:  f,
+	}
+
+	g := &derived{sharedData: sharedData}
+	g.scope.variables = make([]float64, len(confExpression.variables))
+	g.Seed(rand.Int63())
+	return g, nil
+}
+
+// Clone the current generator into a new struct, unbound, with the same pointer for sharedData
+func (g derived) Clone(newName string, specificTags *[]byte) Generator {
+	newg := derived{sharedData: g.sharedData}
+	newNameBytes := []byte(newName)
+	newg.name = &newNameBytes
+	newg.tags = specificTags
+	newg.scope.variables = make([]float64, len(g.sharedData.expression.variables))
+	newg.Seed(rand.Int63())
+	return &newg
+}
+
+// Seed replaces the random source the noise is drawn from
+func (g *derived) Seed(seed int64) {
+	g.scope.random = newRandom(seed)
+}
+
+// SetClock replaces the clock the points are timestamped with
+func (g *derived) SetClock(clock Clock) {
+	g.clock = clock
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *derived) GetName() string {
+	if g.name != nil {
+		return string(*g.name)
+	}
+	return string(*g.sharedData.metadata.Name)
+}
+
+// Return a human-readable description of the generator
+func (g *derived) ToString() string {
+	s := g.sharedData
+	return fmt.Sprintf("Derived generator (%s) computing %s", *s.metadata.Name, s.expression.source) + s.encoding.String()
+}
+
+func (g *derived) references() []string {
+	return g.sharedData.expression.variables
+}
+
+func (g *derived) bind(sources map[string]*recorder) {
+	g.sources = make([]*recorder, len(g.sharedData.expression.variables))
+	for i, name := range g.sharedData.expression.variables {
+		g.sources[i] = sources[name]
+	}
+}
+
+func (g *derived) lastValue() (float64, bool) {
+	return g.value, true
+}
+
+// Generates a metric struct with the value of the expression for the current values of the referenced generators
+func (g *derived) GenerateMetric() *metric.Metric {
+	for i, source := range g.sources {
+		if source != nil {
+			g.scope.variables[i] = source.value
+		}
+	}
+
+	timestamp := clockNow(g.clock).UnixNano()
+	g.value = g.sharedData.expression.evaluate(&g.scope)
+	value, stale := g.sharedData.encoding.encode(g.value, g.scope.random)
+
+	return &metric.Metric{
+		Metadata:  g.sharedData.metadata,
+		Name:      g.name,
+		Value:     value,
+		Tags:      g.tags,
+		Timestamp: &timestamp,
+		Stale:     stale,
+	}
+}
+
+// boundGenerator is implemented by generators reading the values of other generators of the worker, and by the
+// wrappers forwarding to the generators they wrap
+type boundGenerator interface {
+	references() []string
+	bind(sources map[string]*recorder)
+}
+
+func references(g Generator) []string {
+	if bg, ok := g.(boundGenerator); ok {
+		return bg.references()
+	}
+	return nil
+}
+
+func bind(g Generator, sources map[string]*recorder) {
+	if bg, ok := g.(boundGenerator); ok {
+		bg.bind(sources)
+	}
+}
+
+// valuedGenerator is implemented by the generators that can be referenced by derived generators, and by the wrappers
+// forwarding to the generators they wrap. lastValue returns the value generated at the last tick, before it's encoded,
+// and false if the generator doesn't have a value that can be referenced.
+type valuedGenerator interface {
+	lastValue() (float64, bool)
+}
+
+func lastValue(g Generator) (float64, bool) {
+	if vg, ok := g.(valuedGenerator); ok {
+		return vg.lastValue()
+	}
+	return 0, false
+}
+
+// CheckReferences returns an error if a generator references a name that isn't the name of exactly one of gens, or
+// the name of a generator without a value that can be referenced. names are the names of gens as specified in their
+// config.
+func CheckReferences(gens []Generator, names []string) error {
+	for i, g := range gens {
+		for _, reference := range references(g) {
+			matches := 0
+			var referenced Generator
+			for j, name := range names {
+				if name == reference {
+					matches++
+					referenced = gens[j]
+				}
+			}
+			if matches == 0 {
+				return fmt.Errorf("Unknown generator '%s' referenced by '%s'", reference, names[i])
+			}
+			if matches > 1 {
+				return fmt.Errorf("Generator '%s' referenced by '%s' is ambiguous, %d generators have that name", reference, names[i], matches)
+			}
+			if _, ok := lastValue(referenced); !ok {
+				return fmt.Errorf("Generator '%s' referenced by '%s' emits series that don't add up to a value, it can't be referenced", reference, names[i])
+			}
+		}
+	}
+	return nil
+}
+
+// Bind connects the generators of a worker to the generators they reference. The referenced generators are replaced
+// by recorders of their last value in the returned slice, the gens slice isn't modified. names are the names of gens as
+// specified in their config, unknown references are left unbound and read 0.
+func Bind(gens []Generator, names []string) []Generator {
+	sources := make(map[string]*recorder)
+	bound := make([]Generator, len(gens))
+	copy(bound, gens)
+	for _, g := range gens {
+		for _, reference := range references(g) {
+			if _, ok := sources[reference]; ok {
+				continue
+			}
+			for i, name := range names {
+				if name == reference {
+					sources[reference] = &recorder{generator: gens[i]}
+					bound[i] = sources[reference]
+					break
+				}
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return bound
+	}
+
+	for _, g := range gens {
+		bind(g, sources)
+	}
+	return bound
+}
+
+// recorder wraps a generator referenced by other generators of the worker, and records its value at each tick
+type recorder struct {
+	generator Generator
+	value     float64
+}
+
+// Clone the wrapped generator, recorders only live in workers
+func (r *recorder) Clone(newName string, specificTags *[]byte) Generator {
+	return r.generator.Clone(newName, specificTags)
+}
+
+// Seed seeds the wrapped generator
+func (r *recorder) Seed(seed int64) {
+	Seed(r.generator, seed)
+}
+
+// SetClock replaces the clock of the wrapped generator
+func (r *recorder) SetClock(clock Clock) {
+	SetClock(r.generator, clock)
+}
+
+// Restart resets the wrapped generator, its value is recorded at its next tick
+func (r *recorder) Restart() {
+	Restart(r.generator)
+}
+
+// Return the name of the wrapped generator
+func (r *recorder) GetName() string {
+	return r.generator.GetName()
+}
+
+// Return the description of the wrapped generator
+func (r *recorder) ToString() string {
+	return r.generator.ToString()
+}
+
+// Return the maximum number of series of the wrapped generator
+func (r *recorder) SeriesCount() int {
+	return SeriesCount(r.generator)
+}
+
+// Generates the metric of the wrapped generator without recording it, workers call GenerateMetrics
+func (r *recorder) GenerateMetric() *metric.Metric {
+	return r.generator.GenerateMetric()
+}
+
+// Generates the metrics of the wrapped generator and records its value, unless it skipped the tick
+func (r *recorder) GenerateMetrics() []*metric.Metric {
+	metrics := AppendMetrics(nil, r.generator)
+	if len(metrics) > 0 {
+		r.value, _ = lastValue(r.generator)
+	}
+	return metrics
+}
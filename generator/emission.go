@@ -0,0 +1,203 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aleveille/lagrande/metric"
+)
+
+// Any generator can skip ticks, to test staleness handling, gap filling and queries over missing data:
+//  - emitProbability: probability to emit at each tick, between 0 and 1
+//  - gapEvery and gapDuration: the series disappear for gapDuration every gapEvery (Go durations). Each worker is
+//    shifted randomly in the schedule, so that the workers don't all disappear at once
+//  - once: whether to emit on the first tick only, the series are then never written again
+// With dimensions, each combination skips its ticks on its own.
+// The wrapped generator still generates its metrics on the skipped ticks, they're discarded: the series are unobserved
+// during a gap, not paused, so a counter keeps increasing and a random walk keeps walking, like the time-based
+// generators (eg: wave, memory or http) whose values follow the clock anyway.
+
+type emission struct {
+	generator  Generator
+	emitted    bool
+	gapShift   time.Duration // Shift of the worker in the gap schedule
+	start      time.Time
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
+	sharedData *emissionSharedData
+}
+
+type emissionSharedData struct {
+	probability float64
+	gapEvery    time.Duration
+	gapDuration time.Duration
+	once        bool
+}
+
+// WithEmission returns gen skipping the ticks given by the emitProbability, gapEvery, gapDuration and once parameters
+// of its config, or gen itself if it emits at every tick. The result is compliant with the Generator, SeriesGenerator
+// and RandomGenerator interfaces.
+func WithEmission(gen Generator, config CLIConfig) (Generator, error) {
+	confProbability := 1.0
+	confGapEvery := time.Duration(0)
+	confGapDuration := time.Duration(0)
+	confOnce := false
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "emitProbability":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v <= 0 || v > 1 {
+				return nil, fmt.Errorf("Error parsing emitProbability '%s', it must be > 0 and <= 1", value)
+			}
+			confProbability = v
+		case "gapEvery":
+			v, err := time.ParseDuration(value)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing gapEvery '%s', it must be a >= 0 Go Duration", value)
+			}
+			confGapEvery = v
+		case "gapDuration":
+			v, err := time.ParseDuration(value)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing gapDuration '%s', it must be a >= 0 Go Duration", value)
+			}
+			confGapDuration = v
+		case "once":
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing once '%s'", value)
+			}
+			confOnce = v
+		}
+	}
+
+	if (confGapEvery > 0) != (confGapDuration > 0) {
+		return nil, fmt.Errorf("The gapEvery and gapDuration parameters must be set together")
+	}
+	if confGapDuration >= confGapEvery && confGapEvery > 0 {
+		return nil, fmt.Errorf("Gap duration '%s' must be shorter than the gaps interval '%s'", confGapDuration, confGapEvery)
+	}
+	if confProbability == 1 && confGapEvery == 0 && !confOnce {
+		return gen, nil
+	}
+
+	sharedData := &emissionSharedData{
+		probability: confProbability,
+		gapEvery:    confGapEvery,
+		gapDuration: confGapDuration,
+		once:        confOnce,
+	}
+
+	g := &emission{generator: gen, start: time.Now(), sharedData: sharedData}
+	g.Seed(rand.Int63())
+	return g, nil
+}
+
+// Clone the current generator into a new struct wrapping a clone of the generator, with its own gap schedule
+func (g emission) Clone(newName string, specificTags *[]byte) Generator {
+	newg := emission{generator: g.generator.Clone(newName, specificTags), start: time.Now(), sharedData: g.sharedData}
+	newg.Seed(rand.Int63())
+	return &newg
+}
+
+// Seed replaces the random source of the generator, seeds the wrapped generator and redraws the shift of the worker
+// in the gap schedule
+func (g *emission) Seed(seed int64) {
+	g.random = newRandom(seed)
+	Seed(g.generator, int64(splitMix64(uint64(seed))))
+	if g.sharedData.gapEvery > 0 {
+		g.gapShift = time.Duration(g.random.Int63n(int64(g.sharedData.gapEvery)))
+	}
+}
+
+// SetClock replaces the clock of the generator and of the wrapped generator, the gap schedule starts from its time
+func (g *emission) SetClock(clock Clock) {
+	g.clock = clock
+	g.start = clockNow(clock)
+	SetClock(g.generator, clock)
+}
+
+// Restart resets the wrapped generator
+func (g *emission) Restart() {
+	Restart(g.generator)
+}
+
+func (g *emission) references() []string {
+	return references(g.generator)
+}
+
+func (g *emission) bind(sources map[string]*recorder) {
+	bind(g.generator, sources)
+}
+
+func (g *emission) lastValue() (float64, bool) {
+	return lastValue(g.generator)
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *emission) GetName() string {
+	return g.generator.GetName()
+}
+
+// Return a human-readable description of the generator
+func (g *emission) ToString() string {
+	s := g.sharedData
+	description := g.generator.ToString()
+	if s.probability < 1 {
+		description += fmt.Sprintf(", emitted with a probability of %.2f%%", s.probability*100)
+	}
+	if s.gapEvery > 0 {
+		description += fmt.Sprintf(", disappearing for %s every %s", s.gapDuration, s.gapEvery)
+	}
+	if s.once {
+		description += ", emitted once"
+	}
+	return description
+}
+
+// Return the maximum number of series generated at each tick
+func (g *emission) SeriesCount() int {
+	return SeriesCount(g.generator)
+}
+
+// emits returns whether the generator emits at now, and draws the emission probability of the tick
+func (g *emission) emits(now time.Time) bool {
+	s := g.sharedData
+	if s.once && g.emitted {
+		return false
+	}
+	if s.gapEvery > 0 && (now.Sub(g.start)+g.gapShift)%s.gapEvery < s.gapDuration {
+		return false
+	}
+	if s.probability < 1 && g.random.Float64() >= s.probability {
+		return false
+	}
+	g.emitted = true
+	return true
+}
+
+// Generates the metric of the wrapped generator, even on skipped ticks. Workers call GenerateMetrics to skip them.
+func (g *emission) GenerateMetric() *metric.Metric {
+	return AppendMetrics(nil, g.generator)[0]
+}
+
+// Generates the metrics of the wrapped generator, or none if the tick is skipped. Once emitted with once, the series
+// are never written again and the wrapped generator isn't called anymore.
+func (g *emission) GenerateMetrics() []*metric.Metric {
+	if g.sharedData.once && g.emitted {
+		return nil
+	}
+	metrics := AppendMetrics(nil, g.generator)
+	if !g.emits(clockNow(g.clock)) {
+		return nil
+	}
+	return metrics
+}
@@ -0,0 +1,139 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// How the float generators format their values. These parameters are common to all of them:
+//  - precision: number of decimals (or significant digits after the first one in scientific notation), or auto for the
+//    shortest representation that parses back to the same float
+//  - notation: fixed (eg: 1234.5678) or scientific (eg: 1.2346e+03)
+//  - special: space-delimited list of special values among NaN, +Inf, -Inf and stale, sent instead of the value with a
+//    probability of specialProbability at each tick. stale is a staleness marker: formats that support it flag the
+//    point as having no value (OTLP), the others send NaN like a Prometheus staleness marker would be exposed.
+
+const (
+	defaultValuePrecision = 4
+	// Precision (in strconv terms) of the shortest representation
+	autoValuePrecision = -1
+)
+
+type specialValue struct {
+	name  string
+	value *[]byte
+	stale bool
+}
+
+var specialValues = map[string]float64{
+	"NaN":   math.NaN(),
+	"+Inf":  math.Inf(1),
+	"-Inf":  math.Inf(-1),
+	"stale": math.NaN(),
+}
+
+type valueEncoding struct {
+	precision          int
+	notation           byte // 'f' or 'e', as in strconv.FormatFloat
+	special            []specialValue
+	specialProbability float64
+}
+
+// parseValueEncoding parses the value encoding parameters of a float generator, ignoring the other parameters
+func parseValueEncoding(kind string, config CLIConfig) (*valueEncoding, error) {
+	e := &valueEncoding{precision: defaultValuePrecision, notation: 'f'}
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "precision":
+			if value == "auto" {
+				e.precision = autoValuePrecision
+				continue
+			}
+			v, err := strconv.Atoi(value)
+			if err != nil || v < 0 || v > 17 {
+				return nil, fmt.Errorf("Error parsing %s precision '%s', it must be 'auto' or an integer between 0 and 17", kind, value)
+			}
+			e.precision = v
+		case "notation":
+			switch value {
+			case "fixed":
+				e.notation = 'f'
+			case "scientific":
+				e.notation = 'e'
+			default:
+				return nil, fmt.Errorf("Invalid %s notation '%s', must be 'fixed' or 'scientific'", kind, value)
+			}
+		case "special":
+			e.special = nil
+			for _, name := range strings.Fields(value) {
+				v, ok := specialValues[name]
+				if !ok {
+					return nil, fmt.Errorf("Invalid %s special value '%s', must be one of 'NaN', '+Inf', '-Inf' or 'stale'", kind, name)
+				}
+				formatted := []byte(strconv.FormatFloat(v, 'f', -1, 64))
+				e.special = append(e.special, specialValue{name: name, value: &formatted, stale: name == "stale"})
+			}
+		case "specialProbability":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 || v > 1 {
+				return nil, fmt.Errorf("Error parsing %s specialProbability '%s', it must be between 0 and 1", kind, value)
+			}
+			e.specialProbability = v
+		}
+	}
+
+	if e.specialProbability > 0 && len(e.special) == 0 {
+		return nil, fmt.Errorf("The %s specialProbability requires a list of special values", kind)
+	}
+
+	return e, nil
+}
+
+// encode formats v, or a special value drawn from random. stale is true for staleness markers.
+func (e *valueEncoding) encode(v float64, random *rand.Rand) (value *[]byte, stale bool) {
+	if e.specialProbability > 0 && random.Float64() < e.specialProbability {
+		special := e.special[random.Intn(len(e.special))]
+		return special.value, special.stale
+	}
+
+	if e.precision == defaultValuePrecision && e.notation == 'f' {
+		return float64ToByteArrPtr(v), false
+	}
+	b := strconv.AppendFloat(nil, v, e.notation, e.precision, 64)
+	return &b, false
+}
+
+// Return a human-readable description of the encoding, empty for the default one
+func (e *valueEncoding) String() string {
+	var parts []string
+	if e.precision != defaultValuePrecision || e.notation != 'f' {
+		notation := "fixed"
+		if e.notation == 'e' {
+			notation = "scientific"
+		}
+		if e.precision == autoValuePrecision {
+			parts = append(parts, fmt.Sprintf("formatted in %s notation with the shortest precision", notation))
+		} else {
+			parts = append(parts, fmt.Sprintf("formatted in %s notation with a precision of %d", notation, e.precision))
+		}
+	}
+	if e.specialProbability > 0 {
+		names := make([]string, len(e.special))
+		for i, s := range e.special {
+			names[i] = s.name
+		}
+		parts = append(parts, fmt.Sprintf("%.2f%% of special values (%s)", e.specialProbability*100, strings.Join(names, " ")))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return ", " + strings.Join(parts, ", ")
+}
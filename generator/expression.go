@@ -0,0 +1,277 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// Expressions of the derived generator are made of:
+//  - numbers, eg: 0.02 or 1e3
+//  - the names of the other generators of the worker, made of letters, digits, '_' and '.'
+//  - the +, -, * and / operators, with the usual precedence, and parentheses
+//  - the abs(x), min(a, b), max(a, b) and noise(sd) functions, noise drawing a gaussian noise of standard deviation sd
+// Divisions by zero follow float semantics: they give +Inf, -Inf or NaN.
+
+// expressionScope is what an expression is evaluated against: the values of its variables, in the order of their
+// first appearance in the expression, and the random source of the clone
+type expressionScope struct {
+	variables []float64
+	random    *rand.Rand
+}
+
+type expressionNode func(scope *expressionScope) float64
+
+// expression is a parsed expression, shared by all the clones of a generator
+type expression struct {
+	source    string
+	variables []string
+	root      expressionNode
+}
+
+var expressionFunctions = map[string]struct {
+	arity int
+	apply func(scope *expressionScope, args []float64) float64
+}{
+	"abs": {1, func(scope *expressionScope, args []float64) float64 {
+		return math.Abs(args[0])
+	}},
+	"min": {2, func(scope *expressionScope, args []float64) float64 {
+		return math.Min(args[0], args[1])
+	}},
+	"max": {2, func(scope *expressionScope, args []float64) float64 {
+		return math.Max(args[0], args[1])
+	}},
+	"noise": {1, func(scope *expressionScope, args []float64) float64 {
+		return scope.random.NormFloat64() * args[0]
+	}},
+}
+
+// expressionParser is a recursive descent parser, each method parsing one level of precedence
+type expressionParser struct {
+	source    string
+	position  int
+	variables []string
+}
+
+func parseExpression(source string) (*expression, error) {
+	p := &expressionParser{source: source}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.position < len(p.source) {
+		return nil, p.errorf("unexpected '%c'", p.source[p.position])
+	}
+	return &expression{source: source, variables: p.variables, root: root}, nil
+}
+
+// evaluate returns the value of the expression for the values of its variables
+func (e *expression) evaluate(scope *expressionScope) float64 {
+	return e.root(scope)
+}
+
+func (p *expressionParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at position %d", fmt.Sprintf(format, args...), p.position+1)
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.position < len(p.source) && (p.source[p.position] == ' ' || p.source[p.position] == '\t') {
+		p.position++
+	}
+}
+
+// next returns the next character after the spaces, or 0 at the end of the expression
+func (p *expressionParser) next() byte {
+	p.skipSpaces()
+	if p.position >= len(p.source) {
+		return 0
+	}
+	return p.source[p.position]
+}
+
+// parseSum parses terms separated by + and -
+func (p *expressionParser) parseSum() (expressionNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator := p.next()
+		if operator != '+' && operator != '-' {
+			return left, nil
+		}
+		p.position++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if operator == '+' {
+			left = func(scope *expressionScope) float64 { return l(scope) + right(scope) }
+		} else {
+			left = func(scope *expressionScope) float64 { return l(scope) - right(scope) }
+		}
+	}
+}
+
+// parseProduct parses factors separated by * and /
+func (p *expressionParser) parseProduct() (expressionNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		operator := p.next()
+		if operator != '*' && operator != '/' {
+			return left, nil
+		}
+		p.position++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		if operator == '*' {
+			left = func(scope *expressionScope) float64 { return l(scope) * right(scope) }
+		} else {
+			left = func(scope *expressionScope) float64 { return l(scope) / right(scope) }
+		}
+	}
+}
+
+// parseUnary parses a factor, optionally negated
+func (p *expressionParser) parseUnary() (expressionNode, error) {
+	if p.next() == '-' {
+		p.position++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(scope *expressionScope) float64 { return -operand(scope) }, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a number, a variable, a function call or a parenthesized expression
+func (p *expressionParser) parsePrimary() (expressionNode, error) {
+	c := p.next()
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	case c == '(':
+		p.position++
+		node, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, p.errorf("missing ')'")
+		}
+		p.position++
+		return node, nil
+	case isExpressionDigit(c) || c == '.':
+		return p.parseNumber()
+	case isExpressionLetter(c):
+		name := p.parseName()
+		if p.next() == '(' {
+			return p.parseCall(name)
+		}
+		index := p.variableIndex(name)
+		return func(scope *expressionScope) float64 { return scope.variables[index] }, nil
+	default:
+		return nil, p.errorf("unexpected '%c'", c)
+	}
+}
+
+func (p *expressionParser) parseNumber() (expressionNode, error) {
+	start := p.position
+	for p.position < len(p.source) && (isExpressionDigit(p.source[p.position]) || p.source[p.position] == '.') {
+		p.position++
+	}
+	if p.position < len(p.source) && (p.source[p.position] == 'e' || p.source[p.position] == 'E') {
+		p.position++
+		if p.position < len(p.source) && (p.source[p.position] == '+' || p.source[p.position] == '-') {
+			p.position++
+		}
+		for p.position < len(p.source) && isExpressionDigit(p.source[p.position]) {
+			p.position++
+		}
+	}
+
+	token := p.source[start:p.position]
+	v, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		p.position = start
+		return nil, p.errorf("invalid number '%s'", token)
+	}
+	return func(scope *expressionScope) float64 { return v }, nil
+}
+
+func (p *expressionParser) parseName() string {
+	start := p.position
+	for p.position < len(p.source) {
+		c := p.source[p.position]
+		if !isExpressionLetter(c) && !isExpressionDigit(c) && c != '.' {
+			break
+		}
+		p.position++
+	}
+	return p.source[start:p.position]
+}
+
+// parseCall parses the parenthesized arguments of a function
+func (p *expressionParser) parseCall(name string) (expressionNode, error) {
+	function, ok := expressionFunctions[name]
+	if !ok {
+		return nil, p.errorf("unknown function '%s'", name)
+	}
+	p.position++
+
+	var args []expressionNode
+	for p.next() != ')' {
+		if len(args) > 0 {
+			if p.next() != ',' {
+				return nil, p.errorf("missing ',' or ')'")
+			}
+			p.position++
+		}
+		arg, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.position++
+	if len(args) != function.arity {
+		return nil, p.errorf("%s takes %d argument(s), got %d", name, function.arity, len(args))
+	}
+
+	return func(scope *expressionScope) float64 {
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			values[i] = arg(scope)
+		}
+		return function.apply(scope, values)
+	}, nil
+}
+
+// variableIndex returns the index of the variable name, adding it to the variables of the expression if needed
+func (p *expressionParser) variableIndex(name string) int {
+	for i, variable := range p.variables {
+		if variable == name {
+			return i
+		}
+	}
+	p.variables = append(p.variables, name)
+	return len(p.variables) - 1
+}
+
+func isExpressionDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isExpressionLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+}
@@ -2,9 +2,9 @@ package generator
 
 import (
 	"fmt"
+	"math/rand"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aleveille/lagrande/formatter"
 	"github.com/aleveille/lagrande/metric"
@@ -14,15 +14,20 @@ type floatCounter struct {
 	name       *[]byte
 	value      float64
 	tags       *[]byte
+	last       float64    // Value of the last tick, read by the derived generators
+	random     *rand.Rand // Only used for the special values, each worker has its own random source
+	clock      Clock      // nil for the wall clock
 	sharedData *floatCounterSharedData
 }
 
 type floatCounterSharedData struct {
 	metadata  *metric.MetricStaticMetadata
+	value     float64 // Initial value
 	increment float64
 	min       float64
 	max       float64
 	reset     bool
+	encoding  *valueEncoding
 
 	cache     [][]byte
 	formatter *formatter.Formatter
@@ -39,7 +44,7 @@ func NewFloatCounterGenerator(config CLIConfig, tags *[]byte, f *formatter.Forma
 	confReset := true
 
 	for _, arg := range config.Args {
-		kv := strings.Split(arg, ":")
+		kv := strings.SplitN(arg, ":", 2)
 		key := strings.TrimSpace(kv[0])
 		value := strings.TrimSpace(kv[1])
 
@@ -91,6 +96,11 @@ func NewFloatCounterGenerator(config CLIConfig, tags *[]byte, f *formatter.Forma
 		confMax = confValue
 	}
 
+	encoding, err := parseValueEncoding("float counter", config)
+	if err != nil {
+		return nil, err
+	}
+
 	metricName := []byte(confName)
 	metricType := []byte("counter")
 
@@ -102,14 +112,18 @@ func NewFloatCounterGenerator(config CLIConfig, tags *[]byte, f *formatter.Forma
 
 	sharedData := &floatCounterSharedData{
 		metadata:  staticMeta,
+		value:     confValue,
 		increment: confIncrement,
 		min:       confMin,
 		max:       confMax,
 		reset:     confReset,
+		encoding:  encoding,
 		formatter: f,
 	}
 
-	return &floatCounter{value: confValue, sharedData: sharedData}, nil
+	g := &floatCounter{value: confValue, sharedData: sharedData}
+	g.Seed(rand.Int63())
+	return g, nil
 }
 
 // Clone the current generator into a new struct with the current value for value and the same pointer for sharedData
@@ -118,9 +132,25 @@ func (g floatCounter) Clone(newName string, specificTags *[]byte) Generator {
 	newNameBytes := []byte(newName)
 	newg.name = &newNameBytes
 	newg.tags = specificTags
+	newg.Seed(rand.Int63())
 	return &newg
 }
 
+// Seed replaces the random source of the generator
+func (g *floatCounter) Seed(seed int64) {
+	g.random = newRandom(seed)
+}
+
+// Restart sets the counter back to its initial value
+func (g *floatCounter) Restart() {
+	g.value = g.sharedData.value
+}
+
+// SetClock replaces the clock the timestamps are read from
+func (g *floatCounter) SetClock(clock Clock) {
+	g.clock = clock
+}
+
 // Return the name of the generator (as specificed on the command-line)
 func (g *floatCounter) GetName() string {
 	if g.name != nil {
@@ -150,20 +180,28 @@ func (g *floatCounter) ToString() string {
 		sb.WriteString(fmt.Sprintf(" with a value of %.4f", g.value))
 	}
 
+	sb.WriteString(g.sharedData.encoding.String())
 	return sb.String()
 }
 
+func (g *floatCounter) lastValue() (float64, bool) {
+	return g.last, true
+}
+
 // Generates a metric struct with a value computed from the generator's rules
 func (g *floatCounter) GenerateMetric() *metric.Metric {
-	timestamp := time.Now().UnixNano()
+	timestamp := clockNow(g.clock).UnixNano()
 	var retMetric *metric.Metric
 
+	g.last = g.value
+	value, stale := g.sharedData.encoding.encode(g.value, g.random)
 	retMetric = &metric.Metric{
 		Metadata:  g.sharedData.metadata,
 		Name:      g.name,
-		Value:     float64ToByteArrPtr(g.value),
+		Value:     value,
 		Tags:      g.tags,
 		Timestamp: &timestamp,
+		Stale:     stale,
 	}
 
 	if g.sharedData.increment != 0.0 {
@@ -5,7 +5,6 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aleveille/lagrande/formatter"
 	"github.com/aleveille/lagrande/metric"
@@ -14,6 +13,9 @@ import (
 type floatRandom struct {
 	name       *[]byte
 	tags       *[]byte
+	last       float64    // Value of the last tick, read by the derived generators
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
 	sharedData *floatRandomSharedData
 }
 
@@ -22,6 +24,7 @@ type floatRandomSharedData struct {
 	min      float64
 	max      float64
 	reset    bool
+	encoding *valueEncoding
 
 	cache     [][]byte
 	formatter *formatter.Formatter
@@ -38,7 +41,7 @@ func NewFloatRandomGenerator(config CLIConfig, tags *[]byte, f *formatter.Format
 	confMax := 100.0
 
 	for _, arg := range config.Args {
-		kv := strings.Split(arg, ":")
+		kv := strings.SplitN(arg, ":", 2)
 		key := strings.TrimSpace(kv[0])
 		value := strings.TrimSpace(kv[1])
 
@@ -67,6 +70,11 @@ func NewFloatRandomGenerator(config CLIConfig, tags *[]byte, f *formatter.Format
 		return nil, fmt.Errorf("Maximum '%f' cannot be inferior to minimum '%f'", confMax, confMin)
 	}
 
+	encoding, err := parseValueEncoding("random float", config)
+	if err != nil {
+		return nil, err
+	}
+
 	metricName := []byte(confName)
 	metricType := []byte("gauge")
 
@@ -80,10 +88,13 @@ func NewFloatRandomGenerator(config CLIConfig, tags *[]byte, f *formatter.Format
 		metadata:  staticMeta,
 		min:       confMin,
 		max:       confMax,
+		encoding:  encoding,
 		formatter: f,
 	}
 
-	return &floatRandom{sharedData: sharedData}, nil
+	g := &floatRandom{sharedData: sharedData}
+	g.Seed(rand.Int63())
+	return g, nil
 }
 
 // Clone the current generator into a new struct with the current value for value and the same pointer for sharedData
@@ -92,9 +103,20 @@ func (g floatRandom) Clone(newName string, specificTags *[]byte) Generator {
 	newNameBytes := []byte(newName)
 	newg.name = &newNameBytes
 	newg.tags = specificTags
+	newg.Seed(rand.Int63())
 	return &newg
 }
 
+// Seed replaces the random source of the generator
+func (g *floatRandom) Seed(seed int64) {
+	g.random = newRandom(seed)
+}
+
+// SetClock replaces the clock the timestamps are read from
+func (g *floatRandom) SetClock(clock Clock) {
+	g.clock = clock
+}
+
 // Return the name of the generator (as specificed on the command-line)
 func (g *floatRandom) GetName() string {
 	if g.name != nil {
@@ -105,20 +127,27 @@ func (g *floatRandom) GetName() string {
 
 // Return a human-readable description of the generator
 func (g *floatRandom) ToString() string {
-	return fmt.Sprintf("Random float generator (%s) between %f and %f", *g.sharedData.metadata.Name, g.sharedData.min, g.sharedData.max)
+	return fmt.Sprintf("Random float generator (%s) between %f and %f", *g.sharedData.metadata.Name, g.sharedData.min, g.sharedData.max) + g.sharedData.encoding.String()
+}
+
+func (g *floatRandom) lastValue() (float64, bool) {
+	return g.last, true
 }
 
 // Generates a metric struct with a value computed from the generator's rules
 func (g *floatRandom) GenerateMetric() *metric.Metric {
-	timestamp := time.Now().UnixNano()
+	timestamp := clockNow(g.clock).UnixNano()
 	var retMetric *metric.Metric
 
+	g.last = g.random.Float64()*(g.sharedData.max-g.sharedData.min) + g.sharedData.min
+	value, stale := g.sharedData.encoding.encode(g.last, g.random)
 	retMetric = &metric.Metric{
 		Metadata:  g.sharedData.metadata,
 		Name:      g.name,
-		Value:     float64ToByteArrPtr(rand.Float64()*(g.sharedData.max-g.sharedData.min) + g.sharedData.min),
+		Value:     value,
 		Tags:      g.tags,
-		Timestamp: &timestamp}
+		Timestamp: &timestamp,
+		Stale:     stale}
 
 	return retMetric
 }
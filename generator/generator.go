@@ -1,6 +1,10 @@
 package generator
 
-import "github.com/aleveille/lagrande/metric"
+import (
+	"math/rand"
+
+	"github.com/aleveille/lagrande/metric"
+)
 
 // CLIConfig represents a set of 'key: value' pairs that are used to configure each generator
 type CLIConfig struct {
@@ -14,3 +18,103 @@ type Generator interface {
 	GetName() string
 	ToString() string
 }
+
+// SeriesGenerator is implemented by generators that emit several correlated series at each tick (eg: one per CPU core
+// and mode). Workers call GenerateMetrics instead of GenerateMetric for those generators.
+type SeriesGenerator interface {
+	Generator
+	GenerateMetrics() []*metric.Metric
+	SeriesCount() int
+}
+
+// SeriesCount returns the maximum number of series a generator emits at each tick
+func SeriesCount(g Generator) int {
+	if sg, ok := g.(SeriesGenerator); ok {
+		return sg.SeriesCount()
+	}
+	return 1
+}
+
+// AppendMetrics generates the metrics of a tick and appends them to metrics
+func AppendMetrics(metrics []*metric.Metric, g Generator) []*metric.Metric {
+	if sg, ok := g.(SeriesGenerator); ok {
+		return append(metrics, sg.GenerateMetrics()...)
+	}
+	return append(metrics, g.GenerateMetric())
+}
+
+// RandomGenerator is implemented by generators that draw random numbers. Each clone has its own random source, seeded
+// randomly by Clone. Workers then call Seed with a seed derived from -seed so that two runs with the same seed generate
+// the same data. Seed replaces the random source of the clone and redraws what the clone drew from it (eg: where it
+// starts).
+type RandomGenerator interface {
+	Generator
+	Seed(seed int64)
+}
+
+// Seed seeds the random source of a generator, if it draws random numbers
+func Seed(g Generator, seed int64) {
+	if rg, ok := g.(RandomGenerator); ok {
+		rg.Seed(seed)
+	}
+}
+
+// RestartableGenerator is implemented by generators whose state is lost when the process they simulate restarts (eg:
+// counters). Restart resets that state, as if the process had just started: counters go back to their initial value.
+type RestartableGenerator interface {
+	Generator
+	Restart()
+}
+
+// Restart resets the state of a generator, if it loses it when the process restarts
+func Restart(g Generator) {
+	if rg, ok := g.(RestartableGenerator); ok {
+		rg.Restart()
+	}
+}
+
+// taggedGenerator is implemented by generators adding tags of their own to the tags of the worker (eg: dimensions)
+type taggedGenerator interface {
+	tagKeys() []string
+}
+
+// TagKeys returns the keys of the tags a generator adds to the tags of the worker
+func TagKeys(g Generator) []string {
+	if tg, ok := g.(taggedGenerator); ok {
+		return tg.tagKeys()
+	}
+	return nil
+}
+
+// WorkerSeed derives the seed of the generator at index of a worker from the seed of the run, so that every worker and
+// generator draws its own stream of random numbers
+func WorkerSeed(seed int64, worker int, index int) int64 {
+	z := splitMix64(uint64(seed))
+	z = splitMix64(z ^ uint64(worker))
+	z = splitMix64(z ^ uint64(index))
+	return int64(z)
+}
+
+// splitMix64 scrambles x so that close inputs (eg: worker 1 and 2) give unrelated seeds
+func splitMix64(x uint64) uint64 {
+	z := x + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+// newRandom returns a random source for a single clone, sources aren't thread-safe
+func newRandom(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// seriesTags appends series-specific 'key=value,...' tags to the raw tags of a worker
+func seriesTags(workerTags *[]byte, tags string) *[]byte {
+	var b []byte
+	if workerTags != nil && len(*workerTags) > 0 {
+		b = append(b, *workerTags...)
+		b = append(b, ',')
+	}
+	b = append(b, tags...)
+	return &b
+}
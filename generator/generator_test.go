@@ -1,7 +1,16 @@
 package generator
 
 import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"gotest.tools/assert"
 
@@ -332,6 +341,211 @@ func TestCounterDecrementFloatMin(t *testing.T) {
 	assert.Equal(t, "1.0000", string(*metric.Value))
 }
 
+func TestFloat64ToByteArrPtr(t *testing.T) {
+	// Floats are truncated to the 4th decimal
+	for f, expected := range map[float64]string{
+		0:         "0.0000",
+		1:         "1.0000",
+		-1:        "-1.0000",
+		12.5:      "12.5000",
+		33.33339:  "33.3333",
+		-0.00009:  "-0.0000",
+		99.99999:  "99.9999",
+		1234567.5: "1234567.5000",
+		1e15:      "1000000000000000.0000",
+	} {
+		assert.Equal(t, expected, string(*float64ToByteArrPtr(f)), "%v", f)
+	}
+
+	// Numbers of 1/10000 are formatted exactly
+	for units, expected := range map[int64]string{0: "0.0000", 2900: "0.2900", 1000000: "100.0000", 123456789: "12345.6789"} {
+		assert.Equal(t, expected, string(*decimalsToByteArrPtr(units, false)))
+	}
+	assert.Equal(t, "-0.2900", string(*decimalsToByteArrPtr(2900, true)))
+}
+
+func TestCPUModesSumTo100(t *testing.T) {
+	config := CLIConfig{Args: []string{"cores: 2", "noise: 30", "burstProbability: 0.5"}}
+	gen, err := NewCPUGenerator(config, nil, nil)
+
+	assert.NilError(t, err)
+
+	workerTags := []byte("node=a")
+	clone := gen.Clone("cpu", &workerTags).(SeriesGenerator)
+	assert.Equal(t, 10, clone.SeriesCount())
+
+	for tick := 0; tick < 100; tick++ {
+		metrics := clone.GenerateMetrics()
+		assert.Equal(t, 10, len(metrics))
+
+		for core := 0; core < 2; core++ {
+			total := 0.0
+			for mode := 0; mode < len(cpuModes); mode++ {
+				m := metrics[core*len(cpuModes)+mode]
+				assert.Equal(t, fmt.Sprintf("node=a,cpu=%d,mode=%s", core, cpuModes[mode]), string(*m.Tags))
+
+				v, err := strconv.ParseFloat(string(*m.Value), 64)
+				assert.NilError(t, err)
+				assert.Assert(t, v >= 0 && v <= 100)
+				total += v
+			}
+			assert.Equal(t, "100.0000", strconv.FormatFloat(total, 'f', 4, 64))
+		}
+	}
+}
+
+func TestMemorySumToTotal(t *testing.T) {
+	config := CLIConfig{Args: []string{"total: 8G", "behavior: oom", "noise: 20", "peak: 90"}}
+	gen, err := NewMemoryGenerator(config, nil, nil)
+
+	assert.NilError(t, err)
+
+	clone := gen.Clone("memory", nil).(*memory)
+	for tick := 0; tick < 100; tick++ {
+		clone.advance(time.Minute)
+		metrics := clone.GenerateMetrics()
+		assert.Equal(t, 4, len(metrics))
+
+		total := int64(0)
+		for i, m := range metrics {
+			assert.Equal(t, "state="+memoryStates[i], string(*m.Tags))
+			v, err := strconv.ParseInt(string(*m.Value), 10, 64)
+			assert.NilError(t, err)
+			assert.Assert(t, v >= 0)
+			total += v
+		}
+		assert.Equal(t, int64(8<<30), total)
+	}
+}
+
+func TestMemorySawtooth(t *testing.T) {
+	config := CLIConfig{Args: []string{"behavior: sawtooth", "used: 20", "peak: 50", "rate: 10"}}
+	gen, err := NewMemoryGenerator(config, nil, nil)
+
+	assert.NilError(t, err)
+
+	clone := gen.Clone("memory", nil).(*memory)
+	clone.used = 20
+	clone.advance(2 * time.Minute)
+	assert.Equal(t, 40.0, clone.used)
+	clone.advance(time.Minute)
+	assert.Equal(t, 20.0, clone.used)
+}
+
+func TestHistogramCumulativeBuckets(t *testing.T) {
+	config := CLIConfig{Args: []string{"name: requests", "buckets: 1000 250 500", "samples: 50"}}
+	gen, err := NewHistogramGenerator(config, nil, nil)
+
+	assert.NilError(t, err)
+
+	workerTags := []byte("node=a")
+	clone := gen.Clone("requests", &workerTags).(SeriesGenerator)
+	assert.Equal(t, 6, clone.SeriesCount())
+
+	metrics := clone.GenerateMetrics()
+	metrics = clone.GenerateMetrics()
+	expectedNames := []string{"requests_bucket", "requests_bucket", "requests_bucket", "requests_bucket", "requests_sum", "requests_count"}
+	expectedTags := []string{"node=a,le=250", "node=a,le=500", "node=a,le=1000", "node=a,le=+Inf", "node=a", "node=a"}
+	previous := int64(0)
+	for i, m := range metrics {
+		assert.Equal(t, expectedNames[i], string(*m.Name))
+		assert.Equal(t, expectedTags[i], string(*m.Tags))
+		if i < 4 {
+			v, err := strconv.ParseInt(string(*m.Value), 10, 64)
+			assert.NilError(t, err)
+			assert.Assert(t, v >= previous)
+			previous = v
+		}
+	}
+	assert.Equal(t, "100", string(*metrics[3].Value))
+	assert.Equal(t, "100", string(*metrics[5].Value))
+}
+
+func TestSummaryQuantiles(t *testing.T) {
+	config := CLIConfig{Args: []string{"name: requests", "quantiles: 0.99 0.5", "window: 10", "samples: 25"}}
+	gen, err := NewSummaryGenerator(config, nil, nil)
+
+	assert.NilError(t, err)
+
+	clone := gen.Clone("requests", nil).(SeriesGenerator)
+	metrics := clone.GenerateMetrics()
+	assert.Equal(t, 4, len(metrics))
+	assert.Equal(t, "quantile=0.5", string(*metrics[0].Tags))
+	assert.Equal(t, "quantile=0.99", string(*metrics[1].Tags))
+	assert.Equal(t, "requests_count", string(*metrics[3].Name))
+	assert.Equal(t, "25", string(*metrics[3].Value))
+
+	p50, _ := strconv.ParseFloat(string(*metrics[0].Value), 64)
+	p99, _ := strconv.ParseFloat(string(*metrics[1].Value), 64)
+	assert.Assert(t, p50 <= p99)
+}
+
+func TestHistogramParameters(t *testing.T) {
+	_, err := NewHistogramGenerator(CLIConfig{Args: []string{"quantiles: 0.5"}}, nil, nil)
+	assert.ErrorContains(t, err, "only supported by the summary generator")
+
+	_, err = NewSummaryGenerator(CLIConfig{Args: []string{"quantiles: 1.5"}}, nil, nil)
+	assert.ErrorContains(t, err, "must be between 0 and 1")
+
+	// Each boundary and quantile is the tag of a series, they can't be repeated
+	_, err = NewHistogramGenerator(CLIConfig{Args: []string{"buckets: 100 250 100"}}, nil, nil)
+	assert.ErrorContains(t, err, "Error parsing histogram buckets '100 250 100', '100' is repeated")
+	_, err = NewHistogramGenerator(CLIConfig{Args: []string{"buckets: 0.5 5e-1"}}, nil, nil)
+	assert.ErrorContains(t, err, "'0.5' is repeated")
+	_, err = NewHistogramGenerator(CLIConfig{Args: []string{"buckets: 100 +Inf"}}, nil, nil)
+	assert.ErrorContains(t, err, "'+Inf' isn't a finite boundary")
+	_, err = NewHistogramGenerator(CLIConfig{Args: []string{"buckets: NaN 100"}}, nil, nil)
+	assert.ErrorContains(t, err, "'NaN' isn't a finite boundary")
+	_, err = NewSummaryGenerator(CLIConfig{Args: []string{"quantiles: 0.99 0.5 0.99"}}, nil, nil)
+	assert.ErrorContains(t, err, "Error parsing summary quantiles '0.99 0.5 0.99', '0.99' is repeated")
+	_, err = NewHTTPServiceGenerator(CLIConfig{Args: []string{"buckets: 0.1 0.1"}}, nil, nil)
+	assert.ErrorContains(t, err, "Error parsing http buckets '0.1 0.1', '0.1' is repeated")
+}
+
+func TestExponentialHistogramBuckets(t *testing.T) {
+	// With a scale of 1, the base is sqrt(2): bucket 1 is (1.4142, 2], bucket 2 is (2, 2.8284], bucket 3 is (2.8284, 4]
+	h := newExponentialHistogram(1)
+	for _, v := range []float64{2, 2.5, 3, 4, 0, -2} {
+		h.record(v)
+	}
+
+	assert.Equal(t, uint64(6), h.Count)
+	assert.Equal(t, 9.5, h.Sum)
+	assert.Equal(t, -2.0, h.Min)
+	assert.Equal(t, 4.0, h.Max)
+	assert.Equal(t, uint64(1), h.ZeroCount)
+	assert.Equal(t, int32(1), h.PositiveOffset)
+	assert.DeepEqual(t, []uint64{1, 1, 2}, h.PositiveCounts)
+	assert.Equal(t, int32(1), h.NegativeOffset)
+	assert.DeepEqual(t, []uint64{1}, h.NegativeCounts)
+
+	// Recording a value below the first bucket shifts the offset
+	h.record(1)
+	assert.Equal(t, int32(-1), h.PositiveOffset)
+	assert.DeepEqual(t, []uint64{1, 0, 1, 1, 2}, h.PositiveCounts)
+}
+
+func TestLatencyExponentialHistogram(t *testing.T) {
+	config := CLIConfig{Args: []string{"histogram: exponential", "scale: 4", "samples: 500"}}
+	gen, err := NewLatencyDistributionGenerator(config, nil, nil)
+
+	assert.NilError(t, err)
+
+	m := gen.Clone("latency", nil).GenerateMetric()
+	assert.Assert(t, m.Histogram != nil)
+	assert.Equal(t, int32(4), m.Histogram.Scale)
+	assert.Equal(t, uint64(500), m.Histogram.Count)
+
+	total := uint64(0)
+	for _, c := range m.Histogram.PositiveCounts {
+		total += c
+	}
+	assert.Equal(t, uint64(500), total)
+
+	_, err = NewLatencyDistributionGenerator(CLIConfig{Args: []string{"histogram: exponential", "scale: 9"}}, nil, nil)
+	assert.ErrorContains(t, err, "between -4 and 8")
+}
+
 var result *metric.Metric // https://dave.cheney.net/2013/06/30/how-to-write-benchmarks-in-go
 
 func BenchmarkCounterStaticInt(b *testing.B) {
@@ -398,3 +612,824 @@ func BenchmarkLatency(b *testing.B) {
 		gen.GenerateMetric()
 	}
 }
+
+func TestReplayCSV(t *testing.T) {
+	series, err := parseReplayCSV(strings.NewReader("timestamp,value\n1600000020,2.5\n1600000010,1\n\n1600000030,-3\n"))
+	assert.NilError(t, err)
+
+	points := series[""]
+	sortReplayPoints(series)
+	assert.Equal(t, len(points), 3)
+	assert.Equal(t, points[0], replayPoint{timestamp: 1600000010 * int64(time.Second), value: 1})
+	assert.Equal(t, points[2], replayPoint{timestamp: 1600000030 * int64(time.Second), value: -3})
+
+	series, err = parseReplayCSV(strings.NewReader("a,1600000010000,1\nb,2020-09-13T12:26:50Z,2\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, series["a"][0].timestamp, 1600000010*int64(time.Second))
+	assert.Equal(t, series["b"][0].timestamp, 1600000010*int64(time.Second))
+
+	_, err = parseReplayCSV(strings.NewReader("1\nfoo\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestReplayWhisperDump(t *testing.T) {
+	dump := `Meta data:
+  aggregation method: average
+  max retention: 86400
+  xFilesFactor: 0.5
+
+Archive 0 info:
+  offset: 40
+  seconds per point: 60
+  points: 3
+  retention: 180
+  size: 36
+
+Archive 0 data:
+0: 1600000080, 3
+1: 0,          0
+2: 1600000020, 1.5
+
+Archive 1 data:
+0: 1600000000, 42
+`
+	series, err := parseReplayWhisperDump(strings.NewReader(dump))
+	assert.NilError(t, err)
+	sortReplayPoints(series)
+	points := series[""]
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, points[0], replayPoint{timestamp: 1600000020 * int64(time.Second), value: 1.5})
+	assert.Equal(t, points[1], replayPoint{timestamp: 1600000080 * int64(time.Second), value: 3})
+}
+
+func TestReplayInfluxLineProtocol(t *testing.T) {
+	lines := "cpu,host=a usage_idle=90.5,usage_user=5i,state=\"ok\" 1600000010000000000\ncpu,host=a usage_idle=80 1600000020000000000\n"
+	series, err := parseReplayInfluxLineProtocol(strings.NewReader(lines))
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(series), 2)
+	assert.Equal(t, len(series["cpu,host=a usage_idle"]), 2)
+	assert.Equal(t, series["cpu,host=a usage_user"][0].value, 5.0)
+}
+
+func TestReplayPrometheusDump(t *testing.T) {
+	dump := "{__name__=\"up\", job=\"a b\"} 1 1600000010000\n{__name__=\"up\", job=\"a b\"} 0 1600000025000\n"
+	series, err := parseReplayPrometheusDump(strings.NewReader(dump))
+	assert.NilError(t, err)
+	points := series["{__name__=\"up\", job=\"a b\"}"]
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, points[1], replayPoint{timestamp: 1600000025 * int64(time.Second), value: 0})
+
+	openMetrics := "# TYPE up gauge\nup{job=\"a\"} 1 1600000010.5\n# EOF\n"
+	series, err = parseReplayPrometheusDump(strings.NewReader(openMetrics))
+	assert.NilError(t, err)
+	assert.Equal(t, series["up{job=\"a\"}"][0].timestamp, 1600000010500*int64(time.Millisecond))
+}
+
+func TestReplayLoop(t *testing.T) {
+	file, err := ioutil.TempFile("", "replay")
+	assert.NilError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("1600000000,1\n1600000010,2\n1600000020,3\n")
+	assert.NilError(t, err)
+	file.Close()
+
+	config := CLIConfig{Args: []string{"file: " + file.Name(), "timestamps: true"}}
+	gen, err := NewReplayGenerator(config, nil, nil)
+	assert.NilError(t, err)
+	worker := gen.Clone("replay", nil)
+
+	var timestamps []int64
+	var values []string
+	for i := 0; i < 4; i++ {
+		m := worker.GenerateMetric()
+		timestamps = append(timestamps, *m.Timestamp)
+		values = append(values, string(*m.Value))
+	}
+	assert.DeepEqual(t, values, []string{"1.0000", "2.0000", "3.0000", "1.0000"})
+	for i := 1; i < len(timestamps); i++ {
+		assert.Equal(t, timestamps[i]-timestamps[i-1], 10*int64(time.Second))
+	}
+
+	config = CLIConfig{Args: []string{"file: " + file.Name(), "loop: false"}}
+	gen, err = NewReplayGenerator(config, nil, nil)
+	assert.NilError(t, err)
+	worker = gen.Clone("replay", nil)
+	for i := 0; i < 3; i++ {
+		worker.GenerateMetric()
+	}
+	assert.Equal(t, string(*worker.GenerateMetric().Value), "3.0000")
+}
+
+func TestWaveShapes(t *testing.T) {
+	start := time.Unix(0, 0)
+	expected := map[string][]float64{
+		"sine":     {10, 12, 10, 8},
+		"square":   {12, 12, 8, 8},
+		"sawtooth": {8, 9, 10, 11},
+		"trend":    {10, 10, 10, 10},
+	}
+
+	for shape, values := range expected {
+		config := CLIConfig{Args: []string{"shape: " + shape, "offset: 10", "amplitude: 2", "period: 4m", "phase: none"}}
+		gen, err := NewWaveGenerator(config, nil, nil)
+		assert.NilError(t, err)
+		worker := gen.Clone("wave", nil).(*wave)
+		worker.start = start
+
+		for i, v := range values {
+			assert.Assert(t, math.Abs(worker.value(start.Add(time.Duration(i)*time.Minute))-v) < 1e-9, "%s at minute %d", shape, i)
+		}
+	}
+}
+
+func TestWaveTrendAndPhase(t *testing.T) {
+	config := CLIConfig{Args: []string{"shape: trend", "offset: 10", "trend: -4"}}
+	gen, err := NewWaveGenerator(config, nil, nil)
+	assert.NilError(t, err)
+	worker := gen.Clone("wave", nil).(*wave)
+	assert.Assert(t, math.Abs(worker.value(worker.start.Add(90*time.Minute))-4) < 1e-9)
+
+	config = CLIConfig{Args: []string{"period: 1m"}}
+	gen, err = NewWaveGenerator(config, nil, nil)
+	assert.NilError(t, err)
+	// With phase: spread, the phase only depends on the seed, not on the order of the clones
+	first := gen.Clone("wave-0", nil).(*wave)
+	second := gen.Clone("wave-1", nil).(*wave)
+	Seed(first, WorkerSeed(42, 1, 0))
+	Seed(second, WorkerSeed(42, 1, 0))
+	assert.Equal(t, first.phase, second.phase)
+	Seed(second, WorkerSeed(42, 2, 0))
+	assert.Assert(t, first.phase != second.phase)
+	phases := make([]int, 4)
+	for worker := 0; worker < 4000; worker++ {
+		Seed(second, WorkerSeed(42, worker, 0))
+		assert.Assert(t, second.phase >= 0 && second.phase < int64(time.Minute))
+		phases[second.phase/int64(15*time.Second)]++
+	}
+	// Spread over the period
+	for _, count := range phases {
+		assert.Assert(t, count > 900 && count < 1100, "%v", phases)
+	}
+
+	_, err = NewWaveGenerator(CLIConfig{Args: []string{"shape: triangle"}}, nil, nil)
+	assert.ErrorContains(t, err, "Invalid wave shape")
+}
+
+func TestRandomWalkBounds(t *testing.T) {
+	config := CLIConfig{Args: []string{"min: -1", "max: 1", "step: 5", "distribution: uniform"}}
+	gen, err := NewRandomWalkGenerator(config, nil, nil)
+	assert.NilError(t, err)
+	worker := gen.Clone("walk", nil).(*randomWalk)
+
+	for i := 0; i < 1000; i++ {
+		v := worker.walk()
+		assert.Assert(t, v >= -1 && v <= 1, "%f is out of bounds", v)
+	}
+
+	assert.Equal(t, reflectInRange(12, 0, 10), 8.0)
+	assert.Equal(t, reflectInRange(-3, 0, 10), 3.0)
+	assert.Equal(t, reflectInRange(25, 0, 10), 5.0)
+}
+
+func TestRandomWalkReversion(t *testing.T) {
+	config := CLIConfig{Args: []string{"value: 0", "min: 0", "max: 100", "step: 0", "reversion: 0.5", "mean: 80"}}
+	gen, err := NewRandomWalkGenerator(config, nil, nil)
+	assert.NilError(t, err)
+	worker := gen.Clone("walk", nil).(*randomWalk)
+
+	assert.Equal(t, worker.walk(), 40.0)
+	assert.Equal(t, worker.walk(), 60.0)
+	assert.Equal(t, string(*worker.GenerateMetric().Value), "70.0000")
+
+	_, err = NewRandomWalkGenerator(CLIConfig{Args: []string{"value: 200"}}, nil, nil)
+	assert.ErrorContains(t, err, "must be between")
+}
+
+func TestSeedDeterminism(t *testing.T) {
+	newGenerators := []func() (Generator, error){
+		func() (Generator, error) {
+			return NewIntRandomGenerator(CLIConfig{Args: []string{"min: 0", "max: 1000000"}}, nil, nil)
+		},
+		func() (Generator, error) { return NewFloatRandomGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) { return NewRandomWalkGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) { return NewLatencyDistributionGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) { return NewHistogramGenerator(CLIConfig{}, nil, nil) },
+	}
+
+	values := func(gen Generator, worker int, index int) []string {
+		clone := gen.Clone("seeded", nil)
+		Seed(clone, WorkerSeed(42, worker, index))
+		var v []string
+		for i := 0; i < 10; i++ {
+			for _, m := range AppendMetrics(nil, clone) {
+				v = append(v, string(*m.Value))
+			}
+		}
+		return v
+	}
+
+	for i, newGenerator := range newGenerators {
+		gen, err := newGenerator()
+		assert.NilError(t, err)
+		other, err := newGenerator()
+		assert.NilError(t, err)
+
+		assert.DeepEqual(t, values(gen, 3, i), values(other, 3, i))
+		assert.Assert(t, fmt.Sprint(values(gen, 3, i)) != fmt.Sprint(values(gen, 4, i)), "generator %s", gen.ToString())
+	}
+
+	assert.Assert(t, WorkerSeed(42, 1, 0) != WorkerSeed(42, 0, 1))
+	assert.Assert(t, WorkerSeed(42, 1, 0) != WorkerSeed(43, 1, 0))
+}
+
+// Run with -race: workers share the formatted values cache of their generator
+func TestConcurrentWorkers(t *testing.T) {
+	counter, err := NewIntCounterGenerator(CLIConfig{Args: []string{"value: 0", "increment: 1", "max: 100", "reset: false"}}, nil, nil)
+	assert.NilError(t, err)
+	random, err := NewIntRandomGenerator(CLIConfig{Args: []string{"min: 0", "max: 50"}}, nil, nil)
+	assert.NilError(t, err)
+
+	var wg sync.WaitGroup
+	lastValues := make([]string, 16)
+	for w := range lastValues {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			workerCounter := counter.Clone(fmt.Sprintf("counter-%d", w), nil)
+			workerRandom := random.Clone(fmt.Sprintf("random-%d", w), nil)
+			// Half of the workers stop before reaching max, they must not be stuck at max by the others
+			ticks := 50 + 100*(w%2)
+			for i := 0; i < ticks; i++ {
+				lastValues[w] = string(*workerCounter.GenerateMetric().Value)
+				workerRandom.GenerateMetric()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w, v := range lastValues {
+		if w%2 == 0 {
+			assert.Equal(t, v, "49")
+		} else {
+			assert.Equal(t, v, "100")
+		}
+	}
+}
+
+func TestValueEncoding(t *testing.T) {
+	random := newRandom(1)
+	encode := func(args []string, v float64) string {
+		e, err := parseValueEncoding("test", CLIConfig{Args: args})
+		assert.NilError(t, err)
+		value, _ := e.encode(v, random)
+		return string(*value)
+	}
+
+	assert.Equal(t, encode(nil, 0.29), "0.2900")
+	assert.Equal(t, encode(nil, 1e20), "100000000000000000000.0000")
+	assert.Equal(t, encode(nil, -2e15), "-2000000000000000.0000")
+	assert.Equal(t, encode([]string{"precision: 1"}, 3.14159), "3.1")
+	assert.Equal(t, encode([]string{"precision: auto"}, 0.1), "0.1")
+	assert.Equal(t, encode([]string{"notation: scientific", "precision: 2"}, 12345), "1.23e+04")
+	assert.Equal(t, encode([]string{"special: -Inf", "specialProbability: 1"}, 42), "-Inf")
+
+	e, err := parseValueEncoding("test", CLIConfig{Args: []string{"special: stale", "specialProbability: 1"}})
+	assert.NilError(t, err)
+	value, stale := e.encode(42, random)
+	assert.Equal(t, string(*value), "NaN")
+	assert.Assert(t, stale)
+
+	_, err = parseValueEncoding("test", CLIConfig{Args: []string{"specialProbability: 0.1"}})
+	assert.ErrorContains(t, err, "requires a list of special values")
+	_, err = parseValueEncoding("test", CLIConfig{Args: []string{"special: NaN Infinity"}})
+	assert.ErrorContains(t, err, "Invalid test special value 'Infinity'")
+}
+
+func TestFloatGeneratorSpecialValues(t *testing.T) {
+	config := CLIConfig{Args: []string{"min: 0", "max: 1", "special: NaN +Inf", "specialProbability: 0.5"}}
+	gen, err := NewFloatRandomGenerator(config, nil, nil)
+	assert.NilError(t, err)
+	worker := gen.Clone("random", nil)
+	Seed(worker, 1)
+
+	special := 0
+	for i := 0; i < 1000; i++ {
+		v := string(*worker.GenerateMetric().Value)
+		if v == "NaN" || v == "+Inf" {
+			special++
+		}
+	}
+	assert.Assert(t, special > 400 && special < 600, "%d special values out of 1000", special)
+}
+
+func TestCardinalityDimensions(t *testing.T) {
+	walk, err := NewRandomWalkGenerator(CLIConfig{Args: []string{"name: walk"}}, nil, nil)
+	assert.NilError(t, err)
+
+	// Without dimensions, the generator is returned as is
+	gen, err := WithDimensions(walk, CLIConfig{Args: []string{"name: walk"}})
+	assert.NilError(t, err)
+	assert.Equal(t, gen, walk)
+
+	gen, err = WithDimensions(walk, CLIConfig{Args: []string{"name: walk", "dimensions: region=us|eu service=3"}})
+	assert.NilError(t, err)
+	assert.Equal(t, SeriesCount(gen), 6)
+	assert.DeepEqual(t, TagKeys(gen), []string{"region", "service"})
+	assert.Assert(t, TagKeys(walk) == nil)
+
+	workerTags := []byte("worker=1")
+	clone := gen.Clone("walk-1", &workerTags)
+	var tags []string
+	for _, m := range AppendMetrics(nil, clone) {
+		assert.Equal(t, string(*m.Name), "walk-1")
+		tags = append(tags, string(*m.Tags))
+	}
+	assert.DeepEqual(t, tags, []string{
+		"worker=1,region=us,service=service0",
+		"worker=1,region=us,service=service1",
+		"worker=1,region=us,service=service2",
+		"worker=1,region=eu,service=service0",
+		"worker=1,region=eu,service=service1",
+		"worker=1,region=eu,service=service2",
+	})
+
+	for _, args := range [][]string{
+		{"dimensions: region"},
+		{"dimensions: region=0"},
+		{"combinations: 10"},
+		{"dimensions: region=5", "combinations: 0"},
+		{"dimensions: a=10000 b=10000"},
+	} {
+		_, err := WithDimensions(walk, CLIConfig{Args: args})
+		assert.Assert(t, err != nil, "args %v", args)
+	}
+}
+
+func TestCardinalitySampledCombinations(t *testing.T) {
+	counter, err := NewIntCounterGenerator(CLIConfig{}, nil, nil)
+	assert.NilError(t, err)
+
+	// The product is too large to emit, workers sample distinct combinations out of it
+	gen, err := WithDimensions(counter, CLIConfig{Args: []string{"dimensions: a=10000 b=10000 c=100", "combinations: 50"}})
+	assert.NilError(t, err)
+	assert.Equal(t, SeriesCount(gen), 50)
+
+	tags := func(worker int) []string {
+		clone := gen.Clone("sampled", nil)
+		Seed(clone, WorkerSeed(42, worker, 0))
+		var tags []string
+		for _, m := range AppendMetrics(nil, clone) {
+			tags = append(tags, string(*m.Tags))
+		}
+		return tags
+	}
+
+	first := tags(0)
+	assert.Equal(t, len(first), 50)
+	distinct := make(map[string]bool)
+	for _, tag := range first {
+		distinct[tag] = true
+	}
+	assert.Equal(t, len(distinct), 50)
+	assert.DeepEqual(t, first, tags(0))
+	assert.Assert(t, fmt.Sprint(first) != fmt.Sprint(tags(1)))
+
+	// Seeding a worker again keeps its combinations and the state of their clones
+	counter, err = NewIntCounterGenerator(CLIConfig{Args: []string{"value: 0", "increment: 1"}}, nil, nil)
+	assert.NilError(t, err)
+	gen, err = WithDimensions(counter, CLIConfig{Args: []string{"dimensions: a=10000 b=10000 c=100", "combinations: 50"}})
+	assert.NilError(t, err)
+	first = tags(0)
+	clone := gen.Clone("sampled", nil)
+	Seed(clone, WorkerSeed(42, 0, 0))
+	AppendMetrics(nil, clone)
+	Seed(clone, WorkerSeed(42, 1, 0))
+	var reseeded []string
+	for _, m := range AppendMetrics(nil, clone) {
+		reseeded = append(reseeded, string(*m.Tags))
+		assert.Equal(t, string(*m.Value), "1")
+	}
+	assert.DeepEqual(t, first, reseeded)
+}
+
+func TestCardinalityZipfActivity(t *testing.T) {
+	counter, err := NewIntCounterGenerator(CLIConfig{}, nil, nil)
+	assert.NilError(t, err)
+
+	gen, err := WithDimensions(counter, CLIConfig{Args: []string{"dimensions: series=100", "activity: zipf", "zipfExponent: 1"}})
+	assert.NilError(t, err)
+	assert.Equal(t, SeriesCount(gen), 100)
+
+	clone := gen.Clone("zipf", nil)
+	Seed(clone, 42)
+	emissions := make(map[string]int)
+	ticks := 2000
+	for i := 0; i < ticks; i++ {
+		for _, m := range AppendMetrics(nil, clone) {
+			emissions[string(*m.Tags)]++
+		}
+	}
+
+	counts := make([]int, 0, len(emissions))
+	for _, c := range emissions {
+		counts = append(counts, c)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+
+	// The hottest series emits at every tick, the second one every other tick and the tail only occasionally
+	assert.Equal(t, counts[0], ticks)
+	assert.Assert(t, counts[1] > ticks*4/10 && counts[1] < ticks*6/10, "second series emitted %d times", counts[1])
+	assert.Assert(t, counts[len(counts)-1] < ticks/20, "coldest series emitted %d times", counts[len(counts)-1])
+
+	for _, args := range [][]string{
+		{"activity: zipf"},
+		{"dimensions: series=10", "activity: pareto"},
+		{"dimensions: series=10", "activity: zipf", "zipfExponent: 0"},
+	} {
+		_, err := WithDimensions(counter, CLIConfig{Args: args})
+		assert.Assert(t, err != nil, "args %v", args)
+	}
+}
+
+func TestEmissionProbabilityAndOnce(t *testing.T) {
+	counter, err := NewIntCounterGenerator(CLIConfig{}, nil, nil)
+	assert.NilError(t, err)
+
+	// Without emission parameters, the generator is returned as is
+	gen, err := WithEmission(counter, CLIConfig{})
+	assert.NilError(t, err)
+	assert.Equal(t, gen, counter)
+
+	gen, err = WithEmission(counter, CLIConfig{Args: []string{"emitProbability: 0.25"}})
+	assert.NilError(t, err)
+	clone := gen.Clone("sparse", nil)
+	Seed(clone, 42)
+	emitted := 0
+	for i := 0; i < 4000; i++ {
+		metrics := AppendMetrics(nil, clone)
+		emitted += len(metrics)
+		// The counter keeps increasing on the skipped ticks
+		for _, m := range metrics {
+			assert.Equal(t, strconv.Itoa(42+i), string(*m.Value))
+		}
+	}
+	assert.Assert(t, emitted > 900 && emitted < 1100, "emitted %d times", emitted)
+
+	gen, err = WithEmission(counter, CLIConfig{Args: []string{"once: true"}})
+	assert.NilError(t, err)
+	clone = gen.Clone("once", nil)
+	assert.Equal(t, len(AppendMetrics(nil, clone)), 1)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, len(AppendMetrics(nil, clone)), 0)
+	}
+
+	for _, args := range [][]string{
+		{"emitProbability: 0"},
+		{"emitProbability: 1.5"},
+		{"gapEvery: 10m"},
+		{"gapEvery: 10m", "gapDuration: 10m"},
+		{"once: sometimes"},
+	} {
+		_, err := WithEmission(counter, CLIConfig{Args: args})
+		assert.Assert(t, err != nil, "args %v", args)
+	}
+}
+
+func TestEmissionGaps(t *testing.T) {
+	counter, err := NewIntCounterGenerator(CLIConfig{}, nil, nil)
+	assert.NilError(t, err)
+
+	gen, err := WithEmission(counter, CLIConfig{Args: []string{"gapEvery: 10m", "gapDuration: 2m"}})
+	assert.NilError(t, err)
+	g := gen.Clone("gappy", nil).(*emission)
+
+	// Over an hour, the series is missing 2 minutes out of every 10, in one block each time
+	missing, gaps := 0, 0
+	previous := true
+	for minute := 0; minute < 60; minute++ {
+		emits := g.emits(g.start.Add(time.Duration(minute)*time.Minute + time.Second))
+		if !emits {
+			missing++
+			if previous {
+				gaps++
+			}
+		}
+		previous = emits
+	}
+	assert.Equal(t, missing, 12)
+	assert.Assert(t, gaps == 6 || gaps == 7, "%d gaps", gaps)
+}
+
+func TestVirtualClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewVirtualClock(start)
+
+	newGenerators := []func() (Generator, error){
+		func() (Generator, error) { return NewIntCounterGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) { return NewFloatRandomGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) { return NewLatencyDistributionGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) { return NewCPUGenerator(CLIConfig{Args: []string{"cores: 2"}}, nil, nil) },
+		func() (Generator, error) { return NewMemoryGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) { return NewWaveGenerator(CLIConfig{}, nil, nil) },
+		func() (Generator, error) {
+			counter, err := NewIntCounterGenerator(CLIConfig{}, nil, nil)
+			assert.NilError(t, err)
+			config := CLIConfig{Args: []string{"dimensions: region=3", "gapEvery: 1h", "gapDuration: 1s"}}
+			gen, err := WithEmission(counter, config)
+			assert.NilError(t, err)
+			return WithDimensions(gen, config)
+		},
+	}
+
+	for _, newGenerator := range newGenerators {
+		gen, err := newGenerator()
+		assert.NilError(t, err)
+		clone := gen.Clone("clocked", nil)
+		Seed(clone, 42)
+		SetClock(clone, clock)
+
+		for i := 0; i < 3; i++ {
+			now := start.Add(time.Duration(i) * time.Minute)
+			clock.Set(now)
+			metrics := AppendMetrics(nil, clone)
+			assert.Assert(t, len(metrics) > 0, "generator %s", gen.ToString())
+			for _, m := range metrics {
+				assert.Equal(t, *m.Timestamp, now.UnixNano(), "generator %s", gen.ToString())
+			}
+		}
+	}
+
+	// The wave follows the virtual time, not the wall clock
+	gen, err := NewWaveGenerator(CLIConfig{Args: []string{"shape: square", "period: 1h", "phase: none", "precision: 0"}}, nil, nil)
+	assert.NilError(t, err)
+	clone := gen.Clone("wave", nil)
+	SetClock(clone, clock)
+	clock.Set(start.Add(10 * time.Minute))
+	assert.Equal(t, string(*clone.GenerateMetric().Value), "100")
+	clock.Set(start.Add(40 * time.Minute))
+	assert.Equal(t, string(*clone.GenerateMetric().Value), "0")
+}
+
+func TestOffsetAndAlignedClocks(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 34, 56, 789, time.UTC)
+	clock := NewVirtualClock(now)
+
+	assert.Equal(t, NewOffsetClock(clock, -time.Hour).Now(), now.Add(-time.Hour))
+	assert.Equal(t, NewAlignedClock(clock, 10*time.Second).Now(), time.Date(2020, 1, 1, 12, 34, 50, 0, time.UTC))
+	assert.Equal(t, NewAlignedClock(NewOffsetClock(clock, 5*time.Second), time.Minute).Now(), time.Date(2020, 1, 1, 12, 35, 0, 0, time.UTC))
+
+	// Generators read their timestamps from their clock
+	gen, err := NewIntRandomGenerator(CLIConfig{}, nil, nil)
+	assert.NilError(t, err)
+	clone := gen.Clone("aligned", nil)
+	SetClock(clone, NewAlignedClock(clock, time.Minute))
+	assert.Equal(t, *clone.GenerateMetric().Timestamp, time.Date(2020, 1, 1, 12, 34, 0, 0, time.UTC).UnixNano())
+
+	// A nil clock is the wall clock
+	before := time.Now()
+	assert.Assert(t, !NewOffsetClock(nil, time.Hour).Now().Before(before.Add(time.Hour)))
+}
+
+func TestExpressions(t *testing.T) {
+	for source, expected := range map[string]float64{
+		"1 + 2 * 3":             7,
+		"(1 + 2) * 3":           9,
+		"10 / 4 - -1":           3.5,
+		"2e2 - 0.5":             199.5,
+		"a * 2 + b / 4":         22,
+		"min(a, b) + max(a, b)": 18,
+		"abs(b - a)":            2,
+		"noise(0) + a":          10,
+	} {
+		e, err := parseExpression(source)
+		assert.NilError(t, err, source)
+		scope := &expressionScope{variables: make([]float64, len(e.variables)), random: newRandom(42)}
+		for i, name := range e.variables {
+			scope.variables[i] = map[string]float64{"a": 10, "b": 8}[name]
+		}
+		assert.Equal(t, e.evaluate(scope), expected, source)
+	}
+
+	for _, source := range []string{"", "1 +", "(1", "1 2", "f(1)", "min(1)", "1.2.3", "a $ b"} {
+		_, err := parseExpression(source)
+		assert.Assert(t, err != nil, "expression '%s'", source)
+	}
+}
+
+func TestDerivedGenerator(t *testing.T) {
+	requests, err := NewIntCounterGenerator(CLIConfig{Args: []string{"name: requests", "value: 0", "increment: 100"}}, nil, nil)
+	assert.NilError(t, err)
+	cpu, err := NewCPUGenerator(CLIConfig{Args: []string{"name: cpu", "cores: 2"}}, nil, nil)
+	assert.NilError(t, err)
+	errors, err := NewDerivedGenerator(CLIConfig{Args: []string{"name: errors", "expression: requests * 0.02", "precision: auto"}}, nil, nil)
+	assert.NilError(t, err)
+	busy, err := NewDerivedGenerator(CLIConfig{Args: []string{"name: busy", "expression: 1000 - cpu / 2"}}, nil, nil)
+	assert.NilError(t, err)
+	total, err := NewDerivedGenerator(CLIConfig{Args: []string{"name: total", "expression: total + errors", "precision: auto"}}, nil, nil)
+	assert.NilError(t, err)
+
+	gens := []Generator{requests, errors, cpu, busy, total}
+	names := []string{"requests", "errors", "cpu", "busy", "total"}
+	assert.NilError(t, CheckReferences(gens, names))
+	assert.ErrorContains(t, CheckReferences(gens, []string{"requests", "errors", "cpu", "busy", "busy"}), "Unknown generator 'total'")
+	assert.ErrorContains(t, CheckReferences(gens, []string{"requests", "errors", "requests", "busy", "total"}), "ambiguous")
+
+	workerGens := make([]Generator, len(gens))
+	for i, gen := range gens {
+		workerGens[i] = gen.Clone(names[i], nil)
+	}
+	workerGens = Bind(workerGens, names)
+
+	// Derived generators read the values of the tick of the generators declared before them, and the previous tick
+	// of the others
+	for tick := 0; tick < 3; tick++ {
+		var metrics []*metric.Metric
+		for _, gen := range workerGens {
+			metrics = AppendMetrics(metrics, gen)
+		}
+		assert.Equal(t, len(metrics), 1+1+10+1+1)
+
+		values := make(map[string]string)
+		cpuSum := 0.0
+		for _, m := range metrics {
+			values[string(*m.Name)] = string(*m.Value)
+			if string(*m.Name) == "cpu" {
+				v, err := strconv.ParseFloat(string(*m.Value), 64)
+				assert.NilError(t, err)
+				cpuSum += v
+			}
+		}
+		assert.Equal(t, values["requests"], strconv.Itoa(tick*100))
+		assert.Equal(t, values["errors"], strconv.Itoa(tick*2))
+		assert.Equal(t, values["total"], strconv.Itoa(tick*(tick+1)))
+		busyValue, err := strconv.ParseFloat(values["busy"], 64)
+		assert.NilError(t, err)
+		assert.Assert(t, math.Abs(busyValue-(1000-cpuSum/2)) < 0.001, "busy %f, cpu %f", busyValue, cpuSum)
+	}
+
+	_, err = NewDerivedGenerator(CLIConfig{Args: []string{"name: nothing"}}, nil, nil)
+	assert.ErrorContains(t, err, "requires an expression")
+}
+
+func TestDerivedGeneratorReferences(t *testing.T) {
+	histogram, err := NewHistogramGenerator(CLIConfig{Args: []string{"name: latency"}}, nil, nil)
+	assert.NilError(t, err)
+	http, err := NewHTTPServiceGenerator(CLIConfig{Args: []string{"name: api"}}, nil, nil)
+	assert.NilError(t, err)
+	histograms, err := WithDimensions(histogram, CLIConfig{Args: []string{"dimensions: region=3"}})
+	assert.NilError(t, err)
+	counter, err := NewIntCounterGenerator(CLIConfig{Args: []string{"name: requests", "value: 1", "increment: 0"}}, nil, nil)
+	assert.NilError(t, err)
+	counters, err := WithDimensions(counter, CLIConfig{Args: []string{"dimensions: region=3"}})
+	assert.NilError(t, err)
+	sparse, err := WithEmission(counter, CLIConfig{Args: []string{"emitProbability: 0.5"}})
+	assert.NilError(t, err)
+	// The special values sent instead of the value aren't read by the derived generators
+	special, err := NewFloatCounterGenerator(CLIConfig{Args: []string{"name: special", "value: 2", "increment: 0", "special: NaN stale", "specialProbability: 1"}}, nil, nil)
+	assert.NilError(t, err)
+
+	for _, source := range []Generator{histogram, http, histograms} {
+		derived, err := NewDerivedGenerator(CLIConfig{Args: []string{"name: derived", "expression: source * 2"}}, nil, nil)
+		assert.NilError(t, err)
+		err = CheckReferences([]Generator{source, derived}, []string{"source", "derived"})
+		assert.ErrorContains(t, err, "Generator 'source' referenced by 'derived' emits series that don't add up to a value", source.ToString())
+	}
+
+	for _, test := range []struct {
+		source   Generator
+		expected string
+	}{
+		{source: counters, expected: "6.0000"},
+		{source: sparse, expected: "2.0000"},
+		{source: special, expected: "4.0000"},
+	} {
+		derived, err := NewDerivedGenerator(CLIConfig{Args: []string{"name: derived", "expression: source * 2"}}, nil, nil)
+		assert.NilError(t, err)
+		gens := []Generator{test.source, derived}
+		names := []string{"source", "derived"}
+		assert.NilError(t, CheckReferences(gens, names))
+
+		workerGens := Bind([]Generator{test.source.Clone("source", nil), derived.Clone("derived", nil)}, names)
+		emitted := false
+		for tick := 0; tick < 10; tick++ {
+			emitted = emitted || len(AppendMetrics(nil, workerGens[0])) > 0
+			metrics := AppendMetrics(nil, workerGens[1])
+			// Before the first emission of the source, its value is 0
+			if !emitted {
+				assert.Equal(t, "0.0000", string(*metrics[0].Value), test.source.ToString())
+				continue
+			}
+			assert.Equal(t, test.expected, string(*metrics[0].Value), test.source.ToString())
+		}
+	}
+}
+
+func TestHTTPServiceConsistency(t *testing.T) {
+	gen, err := NewHTTPServiceGenerator(CLIConfig{Args: []string{"name: api", "rate: 100", "codes: 200=90 500=10", "buckets: 0.1 0.5 1"}}, nil, nil)
+	assert.NilError(t, err)
+	clone := gen.Clone("api", nil)
+	Seed(clone, 42)
+	clock := NewVirtualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	SetClock(clone, clock)
+	assert.Equal(t, SeriesCount(clone), 2+4+2+1)
+
+	var values map[string]float64
+	for tick := 0; tick <= 600; tick++ {
+		clock.Set(clock.Now().Add(time.Second))
+		metrics := AppendMetrics(nil, clone)
+		assert.Equal(t, len(metrics), SeriesCount(clone))
+
+		values = make(map[string]float64)
+		for _, m := range metrics {
+			v, err := strconv.ParseFloat(string(*m.Value), 64)
+			assert.NilError(t, err)
+			key := string(*m.Name)
+			if m.Tags != nil {
+				key += "," + string(*m.Tags)
+			}
+			values[key] = v
+		}
+
+		// The counters and the histogram count the same requests
+		requests := values["api_requests_total,code=200"] + values["api_requests_total,code=500"]
+		assert.Equal(t, requests, values["api_request_duration_seconds_count"])
+		assert.Equal(t, requests, values["api_request_duration_seconds_bucket,le=+Inf"])
+		assert.Assert(t, values["api_request_duration_seconds_bucket,le=0.1"] <= values["api_request_duration_seconds_bucket,le=0.5"])
+		assert.Assert(t, values["api_request_duration_seconds_bucket,le=0.5"] <= values["api_request_duration_seconds_bucket,le=1"])
+		assert.Assert(t, values["api_request_duration_seconds_bucket,le=1"] <= requests)
+	}
+
+	// 600s at 100 requests per second, minus the ones still in flight
+	requests := values["api_request_duration_seconds_count"]
+	assert.Assert(t, requests > 58000 && requests < 62000, "%f requests", requests)
+	errorRatio := values["api_requests_total,code=500"] / requests
+	assert.Assert(t, errorRatio > 0.09 && errorRatio < 0.11, "error ratio %f", errorRatio)
+	// Little's law: in flight = rate * mean duration
+	meanDuration := values["api_request_duration_seconds_sum"] / requests
+	inFlight := values["api_requests_in_flight"]
+	assert.Assert(t, math.Abs(inFlight-100*meanDuration) < 15, "%f in flight for a mean duration of %f", inFlight, meanDuration)
+
+	// GenerateMetric reads the requests of the first code without serving new requests
+	clock.Set(clock.Now().Add(time.Minute))
+	for i := 0; i < 3; i++ {
+		m := clone.GenerateMetric()
+		assert.Equal(t, string(*m.Name), "api_requests_total")
+		assert.Equal(t, string(*m.Tags), "code=200")
+		assert.Equal(t, string(*m.Value), strconv.Itoa(int(values["api_requests_total,code=200"])))
+	}
+	v, err := strconv.ParseFloat(string(*AppendMetrics(nil, clone)[0].Value), 64)
+	assert.NilError(t, err)
+	assert.Assert(t, v > values["api_requests_total,code=200"])
+
+	for _, args := range [][]string{
+		{"rate: -1"},
+		{"amplitude: 150"},
+		{"codes: 200"},
+		{"codes: 200=0"},
+		{"buckets: fast"},
+		{"min: 10"},
+	} {
+		_, err := NewHTTPServiceGenerator(CLIConfig{Args: args}, nil, nil)
+		assert.Assert(t, err != nil, "args %v", args)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	intCounter, err := NewIntCounterGenerator(CLIConfig{Args: []string{"name: int", "value: 5", "increment: 2"}}, nil, nil)
+	assert.NilError(t, err)
+	floatCounter, err := NewFloatCounterGenerator(CLIConfig{Args: []string{"name: float", "value: 0.5", "increment: 1"}}, nil, nil)
+	assert.NilError(t, err)
+	histogram, err := NewHistogramGenerator(CLIConfig{Args: []string{"name: h", "samples: 10"}}, nil, nil)
+	assert.NilError(t, err)
+	dimensions, err := WithDimensions(intCounter, CLIConfig{Args: []string{"dimensions: job=3"}})
+	assert.NilError(t, err)
+
+	gens := []Generator{intCounter, floatCounter, histogram, dimensions}
+	workerGens := make([]Generator, len(gens))
+	for i, gen := range gens {
+		workerGens[i] = gen.Clone(gen.GetName(), nil)
+	}
+	tick := func() map[string][]string {
+		values := make(map[string][]string)
+		for _, gen := range workerGens {
+			for _, m := range AppendMetrics(nil, gen) {
+				values[string(*m.Name)] = append(values[string(*m.Name)], string(*m.Value))
+			}
+		}
+		return values
+	}
+
+	first := tick()
+	second := tick()
+	for _, gen := range workerGens {
+		Restart(gen)
+	}
+	restarted := tick()
+
+	// Counters and histograms start again, all the combinations of the dimensions included
+	assert.DeepEqual(t, restarted["int"], []string{"5", "5", "5", "5"})
+	assert.DeepEqual(t, restarted["float"], first["float"])
+	assert.DeepEqual(t, restarted["h_count"], []string{"10"})
+	assert.Equal(t, second["h_count"][0], "20")
+}
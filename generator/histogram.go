@@ -0,0 +1,358 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+
+	rand "golang.org/x/exp/rand"
+	distuv "gonum.org/v1/gonum/stat/distuv"
+)
+
+// The histogram and summary generators draw samples from the same Gamma distribution as the latency generator (see
+// latency.go for the min, max, alpha and beta parameters) and aggregate them like a Prometheus client library would:
+//  - histogram: cumulative <name>_bucket{le="..."} counters, one per bucket boundary plus le="+Inf", and the
+//    <name>_sum and <name>_count counters
+//  - summary: <name>{quantile="..."} gauges computed over the last window samples, and the <name>_sum and
+//    <name>_count counters
+// Each worker draws samples values per tick. The counters are per worker and only grow, like in a real process.
+
+type histogram struct {
+	names      []*[]byte
+	tags       []*[]byte
+	metadata   []*metric.MetricStaticMetadata
+	distrib    distuv.Gamma // Each worker has its own random source, sources aren't thread-safe
+	counts     []int64      // Cumulative count of each bucket, the last one being +Inf
+	count      int64
+	sum        float64
+	window     []float64 // Ring buffer of the last samples, for the summary quantiles
+	windowNext int
+	name       *[]byte
+	clock      Clock // nil for the wall clock
+	sharedData *histogramSharedData
+}
+
+type histogramSharedData struct {
+	kind            string // "histogram" or "summary"
+	latency         *latencyDistribution
+	samples         int
+	buckets         []float64
+	quantiles       []float64
+	windowSize      int
+	gaugeMetadata   *metric.MetricStaticMetadata
+	counterMetadata *metric.MetricStaticMetadata
+
+	formatter *formatter.Formatter
+}
+
+// NewHistogramGenerator returns a struct compliant with the Generator and SeriesGenerator interfaces
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewHistogramGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	return newHistogramGenerator("histogram", config, tags, f)
+}
+
+// NewSummaryGenerator returns a struct compliant with the Generator and SeriesGenerator interfaces
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewSummaryGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	return newHistogramGenerator("summary", config, tags, f)
+}
+
+func newHistogramGenerator(kind string, config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	// The distribution parameters are parsed by the latency generator, which ignores the other parameters
+	latencyConfig := CLIConfig{Args: append([]string{"name: latency"}, config.Args...)}
+	latencyGen, err := NewLatencyDistributionGenerator(latencyConfig, tags, f)
+	if err != nil {
+		return nil, err
+	}
+	latency := latencyGen.(*latencyDistribution)
+
+	confSamples := 100
+	var confBuckets []float64
+	confQuantiles := []float64{0.5, 0.9, 0.99}
+	confWindow := 1000
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "samples":
+			v, err := strconv.Atoi(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing %s samples '%s', it must be a > 0 integer", kind, value)
+			}
+			confSamples = v
+		case "buckets":
+			if kind != "histogram" {
+				return nil, fmt.Errorf("The buckets parameter is only supported by the histogram generator")
+			}
+			v, err := parseFloatList(value)
+			if err != nil || len(v) == 0 {
+				return nil, fmt.Errorf("Error parsing histogram buckets '%s', it must be a space-delimited list of numbers", value)
+			}
+			if err := sortBuckets(v); err != nil {
+				return nil, fmt.Errorf("Error parsing histogram buckets '%s', %s", value, err)
+			}
+			confBuckets = v
+		case "quantiles":
+			if kind != "summary" {
+				return nil, fmt.Errorf("The quantiles parameter is only supported by the summary generator")
+			}
+			v, err := parseFloatList(value)
+			if err != nil || len(v) == 0 {
+				return nil, fmt.Errorf("Error parsing summary quantiles '%s', it must be a space-delimited list of numbers", value)
+			}
+			for _, q := range v {
+				if q < 0 || q > 1 {
+					return nil, fmt.Errorf("Invalid summary quantile '%g', it must be between 0 and 1", q)
+				}
+			}
+			if err := sortDistinct(v); err != nil {
+				return nil, fmt.Errorf("Error parsing summary quantiles '%s', %s", value, err)
+			}
+			confQuantiles = v
+		case "window":
+			if kind != "summary" {
+				return nil, fmt.Errorf("The window parameter is only supported by the summary generator")
+			}
+			v, err := strconv.Atoi(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing summary window '%s', it must be a > 0 integer", value)
+			}
+			confWindow = v
+		}
+	}
+
+	if confBuckets == nil {
+		// Default to boundaries around the usual quantiles of the distribution
+		for _, q := range []float64{0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 0.999} {
+			b, _ := strconv.ParseFloat(strconv.FormatFloat(latency.scale(latency.sharedData.distrib.Quantile(q)), 'g', 2, 64), 64)
+			if len(confBuckets) == 0 || b > confBuckets[len(confBuckets)-1] {
+				confBuckets = append(confBuckets, b)
+			}
+		}
+	}
+	sort.Float64s(confBuckets)
+
+	gaugeType := []byte("gauge")
+	counterType := []byte("counter")
+	sharedData := &histogramSharedData{
+		kind:            kind,
+		latency:         latency,
+		samples:         confSamples,
+		buckets:         confBuckets,
+		quantiles:       confQuantiles,
+		windowSize:      confWindow,
+		gaugeMetadata:   &metric.MetricStaticMetadata{Name: latency.sharedData.metadata.Name, Tags: tags, MetricType: &gaugeType},
+		counterMetadata: &metric.MetricStaticMetadata{Name: latency.sharedData.metadata.Name, Tags: tags, MetricType: &counterType},
+		formatter:       f,
+	}
+
+	g := &histogram{sharedData: sharedData}
+	g.initSeries(latency.sharedData.metadata.Name, nil)
+	return g, nil
+}
+
+// initSeries creates the per-worker state, names and tags of each series, in the order they're emitted
+func (g *histogram) initSeries(name *[]byte, workerTags *[]byte) {
+	s := g.sharedData
+	g.name = name
+	g.names = nil
+	g.tags = nil
+	g.metadata = nil
+	addSeries := func(suffix string, tags string, metadata *metric.MetricStaticMetadata) {
+		seriesName := []byte(string(*name) + suffix)
+		g.names = append(g.names, &seriesName)
+		if len(tags) > 0 {
+			g.tags = append(g.tags, seriesTags(workerTags, tags))
+		} else {
+			g.tags = append(g.tags, workerTags)
+		}
+		g.metadata = append(g.metadata, metadata)
+	}
+
+	if s.kind == "histogram" {
+		for _, b := range s.buckets {
+			addSeries("_bucket", "le="+strconv.FormatFloat(b, 'f', -1, 64), s.counterMetadata)
+		}
+		addSeries("_bucket", "le=+Inf", s.counterMetadata)
+		g.counts = make([]int64, len(s.buckets)+1)
+	} else {
+		for _, q := range s.quantiles {
+			addSeries("", "quantile="+strconv.FormatFloat(q, 'f', -1, 64), s.gaugeMetadata)
+		}
+		g.window = make([]float64, 0, s.windowSize)
+	}
+	addSeries("_sum", "", s.counterMetadata)
+	addSeries("_count", "", s.counterMetadata)
+
+	g.distrib = s.latency.sharedData.distrib
+	g.Seed(mathrand.Int63())
+}
+
+// Seed replaces the random source of the generator
+func (g *histogram) Seed(seed int64) {
+	g.distrib.Src = rand.NewSource(uint64(seed))
+}
+
+// Clone the current generator into a new struct with its own counters and the same pointer for sharedData
+func (g histogram) Clone(newName string, specificTags *[]byte) Generator {
+	newg := histogram{sharedData: g.sharedData}
+	newNameBytes := []byte(newName)
+	newg.initSeries(&newNameBytes, specificTags)
+	return &newg
+}
+
+// Restart empties the buckets (or the window of the quantiles), the sum and the count
+func (g *histogram) Restart() {
+	for i := range g.counts {
+		g.counts[i] = 0
+	}
+	g.count = 0
+	g.sum = 0
+	if g.window != nil {
+		g.window = g.window[:0]
+		g.windowNext = 0
+	}
+}
+
+// SetClock replaces the clock the timestamps are read from
+func (g *histogram) SetClock(clock Clock) {
+	g.clock = clock
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *histogram) GetName() string {
+	return string(*g.name)
+}
+
+// Return a human-readable description of the generator
+func (g *histogram) ToString() string {
+	s := g.sharedData
+	if s.kind == "histogram" {
+		return fmt.Sprintf("Histogram generator (%s) of %d latency samples per tick with buckets %s. %s", *s.latency.sharedData.metadata.Name, s.samples, formatFloatList(s.buckets), s.latency.ToString())
+	}
+	return fmt.Sprintf("Summary generator (%s) of %d latency samples per tick with quantiles %s over the last %d samples. %s", *s.latency.sharedData.metadata.Name, s.samples, formatFloatList(s.quantiles), s.windowSize, s.latency.ToString())
+}
+
+// Return the number of series generated at each tick: one per bucket (or quantile), plus the sum and the count
+func (g *histogram) SeriesCount() int {
+	return len(g.names)
+}
+
+// Generates the metric of the first bucket (or quantile) only, workers call GenerateMetrics to get all the series
+func (g *histogram) GenerateMetric() *metric.Metric {
+	return g.GenerateMetrics()[0]
+}
+
+// Generates a metric for each bucket (or quantile), the sum and the count
+func (g *histogram) GenerateMetrics() []*metric.Metric {
+	s := g.sharedData
+	timestamp := clockNow(g.clock).UnixNano()
+
+	g.count += int64(s.samples)
+	for i := 0; i < s.samples; i++ {
+		sample := s.latency.scale(g.distrib.Rand())
+		g.sum += sample
+		if s.kind == "histogram" {
+			// Buckets are cumulative: a sample is counted in every bucket whose boundary is greater or equal
+			bucket := sort.SearchFloat64s(s.buckets, sample)
+			for b := bucket; b < len(g.counts); b++ {
+				g.counts[b]++
+			}
+		} else if len(g.window) < s.windowSize {
+			g.window = append(g.window, sample)
+		} else {
+			g.window[g.windowNext] = sample
+			g.windowNext = (g.windowNext + 1) % s.windowSize
+		}
+	}
+
+	values := make([]*[]byte, 0, len(g.names))
+	if s.kind == "histogram" {
+		for _, c := range g.counts {
+			values = append(values, intToByteArrPtr(int(c)))
+		}
+	} else {
+		sorted := make([]float64, len(g.window))
+		copy(sorted, g.window)
+		sort.Float64s(sorted)
+		for _, q := range s.quantiles {
+			values = append(values, float64ToByteArrPtr(quantile(sorted, q)))
+		}
+	}
+	values = append(values, float64ToByteArrPtr(g.sum), intToByteArrPtr(int(g.count)))
+
+	metrics := make([]*metric.Metric, len(g.names))
+	for i := range g.names {
+		metrics[i] = &metric.Metric{
+			Metadata:  g.metadata[i],
+			Name:      g.names[i],
+			Value:     values[i],
+			Tags:      g.tags[i],
+			Timestamp: &timestamp,
+		}
+	}
+
+	return metrics
+}
+
+// quantile returns the q-quantile of sorted values using the nearest-rank method
+func quantile(sorted []float64, q float64) float64 {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// parseFloatList parses a space-delimited list of numbers, eg: '0.1 0.5 1 5'
+func parseFloatList(s string) ([]float64, error) {
+	var values []float64
+	for _, token := range strings.Fields(s) {
+		v, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// sortDistinct sorts values, and returns an error if a value is repeated: it would be the le (or quantile) tag of two
+// series
+func sortDistinct(values []float64) error {
+	sort.Float64s(values)
+	for i := 1; i < len(values); i++ {
+		if values[i] == values[i-1] {
+			return fmt.Errorf("'%s' is repeated", strconv.FormatFloat(values[i], 'f', -1, 64))
+		}
+	}
+	return nil
+}
+
+// sortBuckets sorts bucket boundaries, and returns an error if a boundary is repeated or isn't finite: the +Inf bucket
+// is always emitted
+func sortBuckets(buckets []float64) error {
+	for _, b := range buckets {
+		if math.IsInf(b, 0) || math.IsNaN(b) {
+			return fmt.Errorf("'%s' isn't a finite boundary, the +Inf bucket is always emitted", strconv.FormatFloat(b, 'f', -1, 64))
+		}
+	}
+	return sortDistinct(buckets)
+}
+
+func formatFloatList(values []float64) string {
+	tokens := make([]string, len(values))
+	for i, v := range values {
+		tokens[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strings.Join(tokens, " ")
+}
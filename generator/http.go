@@ -0,0 +1,378 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+
+	rand "golang.org/x/exp/rand"
+	distuv "gonum.org/v1/gonum/stat/distuv"
+)
+
+// The http generator simulates the requests served by an HTTP service, so that its series are consistent with each
+// other like the ones of a real service:
+//  - requests arrive following a Poisson process of rate requests per second per worker. With amplitude, the rate
+//    goes up and down by amplitude percent over period, peaking in the middle of the period like the cpu generator
+//  - each request gets a status code drawn from the codes weights and a duration drawn from the same Gamma
+//    distribution as the latency generator (see latency.go for the min, max, alpha and beta parameters), in seconds
+//  - requests are counted once they complete, in <name>_requests_total{code="..."} and in the
+//    <name>_request_duration_seconds histogram (_bucket{le="..."}, _sum and _count)
+//  - <name>_requests_in_flight is the number of requests that arrived but didn't complete yet
+// So the sum of the requests_total counters is always the histogram _count, and in-flight follows Little's law. The
+// counters are per worker and only grow, like in a real process.
+
+// Buckets of the Prometheus client libraries
+var httpDefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Above this mean number of arrivals per tick, the Poisson distribution is approximated by a normal distribution
+const httpPoissonNormalThreshold = 30
+
+type httpRequest struct {
+	completion int64 // In nanoseconds
+	code       int   // Index in the codes
+	duration   float64
+}
+
+type httpService struct {
+	name       *[]byte
+	names      []*[]byte
+	tags       []*[]byte
+	metadata   []*metric.MetricStaticMetadata
+	requests   []int64 // Completed requests, by code
+	buckets    []int64 // Cumulative count of each bucket, the last one being +Inf
+	sum        float64
+	inFlight   []httpRequest
+	previous   int64        // Time of the previous tick in nanoseconds, 0 before the first tick
+	distrib    distuv.Gamma // Each worker has its own random sources, sources aren't thread-safe
+	random     *mathrand.Rand
+	clock      Clock // nil for the wall clock
+	sharedData *httpServiceSharedData
+}
+
+type httpServiceSharedData struct {
+	latency         *latencyDistribution
+	rate            float64
+	amplitude       float64
+	period          time.Duration
+	codes           []string
+	cumulative      []float64 // Cumulative weights of the codes
+	buckets         []float64
+	gaugeMetadata   *metric.MetricStaticMetadata
+	counterMetadata *metric.MetricStaticMetadata
+
+	formatter *formatter.Formatter
+}
+
+// NewHTTPServiceGenerator returns a struct compliant with the Generator and SeriesGenerator interfaces
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewHTTPServiceGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	// The distribution parameters are parsed by the latency generator, which ignores the other parameters. The
+	// defaults are in seconds, with a mean around 300ms.
+	latencyConfig := CLIConfig{Args: append([]string{"name: latency", "min: 0.005", "max: 2"}, config.Args...)}
+	latencyGen, err := NewLatencyDistributionGenerator(latencyConfig, tags, f)
+	if err != nil {
+		return nil, err
+	}
+	latency := latencyGen.(*latencyDistribution)
+
+	confName := "http"
+	confRate := 10.0
+	confAmplitude := 0.0
+	confPeriod := 24 * time.Hour
+	confCodes := []string{"200", "404", "500"}
+	confWeights := []float64{95, 3, 2}
+	confBuckets := httpDefaultBuckets
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "name":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("Error parsing http name '%s'", value)
+			}
+			confName = value
+		case "rate":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing http rate '%s', it must be a >= 0 number", value)
+			}
+			confRate = v
+		case "amplitude":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 || v > 100 {
+				return nil, fmt.Errorf("Error parsing http amplitude '%s', it must be a percentage between 0 and 100", value)
+			}
+			confAmplitude = v
+		case "period":
+			v, err := time.ParseDuration(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing http period '%s', it must be a > 0 Go Duration", value)
+			}
+			confPeriod = v
+		case "codes":
+			confCodes = nil
+			confWeights = nil
+			for _, token := range strings.Fields(value) {
+				codeWeight := strings.SplitN(token, "=", 2)
+				if len(codeWeight) != 2 || len(codeWeight[0]) == 0 {
+					return nil, fmt.Errorf("Error parsing http code '%s', it must be <code>=<weight>", token)
+				}
+				w, err := strconv.ParseFloat(codeWeight[1], 64)
+				if err != nil || w <= 0 {
+					return nil, fmt.Errorf("Error parsing http code '%s', its weight must be a > 0 number", token)
+				}
+				confCodes = append(confCodes, codeWeight[0])
+				confWeights = append(confWeights, w)
+			}
+			if len(confCodes) == 0 {
+				return nil, fmt.Errorf("Error parsing http codes '%s', it must be a space-delimited list of <code>=<weight>", value)
+			}
+		case "buckets":
+			v, err := parseFloatList(value)
+			if err != nil || len(v) == 0 {
+				return nil, fmt.Errorf("Error parsing http buckets '%s', it must be a space-delimited list of numbers", value)
+			}
+			if err := sortBuckets(v); err != nil {
+				return nil, fmt.Errorf("Error parsing http buckets '%s', %s", value, err)
+			}
+			confBuckets = v
+		}
+	}
+
+	cumulative := make([]float64, len(confWeights))
+	total := 0.0
+	for i, w := range confWeights {
+		total += w
+		cumulative[i] = total
+	}
+
+	metricName := []byte(confName)
+	gaugeType := []byte("gauge")
+	counterType := []byte("counter")
+	sharedData := &httpServiceSharedData{
+		latency:         latency,
+		rate:            confRate,
+		amplitude:       confAmplitude,
+		period:          confPeriod,
+		codes:           confCodes,
+		cumulative:      cumulative,
+		buckets:         confBuckets,
+		gaugeMetadata:   &metric.MetricStaticMetadata{Name: &metricName, Tags: tags, MetricType: &gaugeType},
+		counterMetadata: &metric.MetricStaticMetadata{Name: &metricName, Tags: tags, MetricType: &counterType},
+		formatter:       f,
+	}
+
+	g := &httpService{sharedData: sharedData}
+	g.initSeries(&metricName, nil)
+	return g, nil
+}
+
+// initSeries creates the per-worker state, names and tags of each series, in the order they're emitted
+func (g *httpService) initSeries(name *[]byte, workerTags *[]byte) {
+	s := g.sharedData
+	g.name = name
+	g.names = nil
+	g.tags = nil
+	g.metadata = nil
+	addSeries := func(suffix string, tags string, metadata *metric.MetricStaticMetadata) {
+		seriesName := []byte(string(*name) + suffix)
+		g.names = append(g.names, &seriesName)
+		if len(tags) > 0 {
+			g.tags = append(g.tags, seriesTags(workerTags, tags))
+		} else {
+			g.tags = append(g.tags, workerTags)
+		}
+		g.metadata = append(g.metadata, metadata)
+	}
+
+	for _, code := range s.codes {
+		addSeries("_requests_total", "code="+code, s.counterMetadata)
+	}
+	for _, b := range s.buckets {
+		addSeries("_request_duration_seconds_bucket", "le="+strconv.FormatFloat(b, 'f', -1, 64), s.counterMetadata)
+	}
+	addSeries("_request_duration_seconds_bucket", "le=+Inf", s.counterMetadata)
+	addSeries("_request_duration_seconds_sum", "", s.counterMetadata)
+	addSeries("_request_duration_seconds_count", "", s.counterMetadata)
+	addSeries("_requests_in_flight", "", s.gaugeMetadata)
+
+	g.requests = make([]int64, len(s.codes))
+	g.buckets = make([]int64, len(s.buckets)+1)
+	g.distrib = s.latency.sharedData.distrib
+	g.Seed(mathrand.Int63())
+}
+
+// Clone the current generator into a new struct with its own counters and the same pointer for sharedData
+func (g httpService) Clone(newName string, specificTags *[]byte) Generator {
+	newg := httpService{sharedData: g.sharedData}
+	newNameBytes := []byte(newName)
+	newg.initSeries(&newNameBytes, specificTags)
+	return &newg
+}
+
+// Seed replaces the random sources of the generator
+func (g *httpService) Seed(seed int64) {
+	g.distrib.Src = rand.NewSource(uint64(seed))
+	g.random = newRandom(int64(splitMix64(uint64(seed))))
+}
+
+// SetClock replaces the clock the requests arrive on, the requests in flight are dropped and the next tick starts the
+// arrivals again
+func (g *httpService) SetClock(clock Clock) {
+	g.clock = clock
+	g.previous = 0
+	g.inFlight = nil
+}
+
+// Restart sets the counters and the histogram back to 0, the requests in flight are lost
+func (g *httpService) Restart() {
+	for i := range g.requests {
+		g.requests[i] = 0
+	}
+	for i := range g.buckets {
+		g.buckets[i] = 0
+	}
+	g.sum = 0
+	g.inFlight = nil
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *httpService) GetName() string {
+	return string(*g.name)
+}
+
+// Return a human-readable description of the generator
+func (g *httpService) ToString() string {
+	s := g.sharedData
+	codes := make([]string, len(s.codes))
+	previous := 0.0
+	for i, code := range s.codes {
+		codes[i] = fmt.Sprintf("%s %.2f%%", code, (s.cumulative[i]-previous)/s.cumulative[len(s.cumulative)-1]*100)
+		previous = s.cumulative[i]
+	}
+	description := fmt.Sprintf("HTTP service generator (%s) of %.2f requests per second per worker", *s.counterMetadata.Name, s.rate)
+	if s.amplitude > 0 {
+		description += fmt.Sprintf(" +/- %.2f%% over %s", s.amplitude, s.period)
+	}
+	return description + fmt.Sprintf(" with codes %s and buckets %s. %s", strings.Join(codes, ", "), formatFloatList(s.buckets), s.latency.ToString())
+}
+
+// Return the number of series generated at each tick: one per code and bucket, the sum, the count and in-flight
+func (g *httpService) SeriesCount() int {
+	return len(g.names)
+}
+
+// rateAt returns the arrival rate at now, in requests per second
+func (g *httpService) rateAt(now int64) float64 {
+	s := g.sharedData
+	if s.amplitude == 0 {
+		return s.rate
+	}
+	phase := float64(now%int64(s.period)) / float64(s.period)
+	return s.rate * (1 - s.amplitude/100*math.Cos(2*math.Pi*phase))
+}
+
+// poisson draws the number of arrivals of a Poisson process of mean lambda
+func (g *httpService) poisson(lambda float64) int {
+	if lambda <= 0 {
+		return 0
+	}
+	if lambda > httpPoissonNormalThreshold {
+		return int(math.Max(0, math.Round(lambda+math.Sqrt(lambda)*g.random.NormFloat64())))
+	}
+	// Knuth's algorithm
+	limit := math.Exp(-lambda)
+	n := 0
+	for p := g.random.Float64(); p > limit; p *= g.random.Float64() {
+		n++
+	}
+	return n
+}
+
+// serve makes the requests arrived since the previous tick and completes the requests done by now
+func (g *httpService) serve(now int64) {
+	s := g.sharedData
+	if g.previous > 0 && now > g.previous {
+		elapsed := now - g.previous
+		arrivals := g.poisson(g.rateAt(now) * float64(elapsed) / float64(time.Second))
+		for i := 0; i < arrivals; i++ {
+			duration := s.latency.scale(g.distrib.Rand())
+			arrival := g.previous + g.random.Int63n(elapsed) + 1
+			code := sort.SearchFloat64s(s.cumulative, g.random.Float64()*s.cumulative[len(s.cumulative)-1])
+			if code >= len(s.codes) {
+				code = len(s.codes) - 1
+			}
+			g.inFlight = append(g.inFlight, httpRequest{
+				completion: arrival + int64(duration*float64(time.Second)),
+				code:       code,
+				duration:   duration,
+			})
+		}
+	}
+	g.previous = now
+
+	pending := g.inFlight[:0]
+	for _, request := range g.inFlight {
+		if request.completion > now {
+			pending = append(pending, request)
+			continue
+		}
+		g.requests[request.code]++
+		g.sum += request.duration
+		// Buckets are cumulative: a request is counted in every bucket whose boundary is greater or equal
+		for b := sort.SearchFloat64s(s.buckets, request.duration); b < len(g.buckets); b++ {
+			g.buckets[b]++
+		}
+	}
+	g.inFlight = pending
+}
+
+// Generates the metric of the requests of the first code as of the last tick, without serving new requests. Workers
+// call GenerateMetrics to get all the series.
+func (g *httpService) GenerateMetric() *metric.Metric {
+	timestamp := clockNow(g.clock).UnixNano()
+	return g.metric(0, intToByteArrPtr(int(g.requests[0])), &timestamp)
+}
+
+// Generates a metric for each code and bucket, the sum, the count and the requests in flight
+func (g *httpService) GenerateMetrics() []*metric.Metric {
+	timestamp := clockNow(g.clock).UnixNano()
+	g.serve(timestamp)
+
+	values := make([]*[]byte, 0, len(g.names))
+	for _, c := range g.requests {
+		values = append(values, intToByteArrPtr(int(c)))
+	}
+	for _, c := range g.buckets {
+		values = append(values, intToByteArrPtr(int(c)))
+	}
+	values = append(values, float64ToByteArrPtr(g.sum), intToByteArrPtr(int(g.buckets[len(g.buckets)-1])), intToByteArrPtr(len(g.inFlight)))
+
+	metrics := make([]*metric.Metric, len(g.names))
+	for i := range g.names {
+		metrics[i] = g.metric(i, values[i], &timestamp)
+	}
+
+	return metrics
+}
+
+// metric returns the metric of the series at index
+func (g *httpService) metric(index int, value *[]byte, timestamp *int64) *metric.Metric {
+	return &metric.Metric{
+		Metadata:  g.metadata[index],
+		Name:      g.names[index],
+		Value:     value,
+		Tags:      g.tags[index],
+		Timestamp: timestamp,
+	}
+}
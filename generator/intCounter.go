@@ -5,7 +5,6 @@ import (
 	"math"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aleveille/lagrande/formatter"
 	"github.com/aleveille/lagrande/metric"
@@ -14,18 +13,22 @@ import (
 type intCounter struct {
 	name       *[]byte
 	value      int
+	increment  int // Per worker since a worker stops incrementing once it reaches min or max with reset: false
 	tags       *[]byte
+	last       float64 // Value of the last tick, read by the derived generators
+	clock      Clock   // nil for the wall clock
 	sharedData *intCounterSharedData
 }
 
 type intCounterSharedData struct {
 	metadata  *metric.MetricStaticMetadata
+	value     int // Initial value
 	increment int
 	min       int
 	max       int
 	reset     bool
 
-	cache     []*[]byte
+	cache     *intValueCache
 	formatter *formatter.Formatter
 }
 
@@ -43,7 +46,7 @@ func NewIntCounterGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatt
 	confReset := true
 
 	for _, arg := range config.Args {
-		kv := strings.Split(arg, ":")
+		kv := strings.SplitN(arg, ":", 2)
 		key := strings.TrimSpace(kv[0])
 		value := strings.TrimSpace(kv[1])
 
@@ -98,14 +101,7 @@ func NewIntCounterGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatt
 	metricName := []byte(confName)
 	metricType := []byte("counter")
 
-	var cache []*[]byte
-	cacheSize := confMax - confMin + 1
-	if cacheSize <= intCounterMaxCacheSize && cacheSize > 0 {
-		cache = make([]*[]byte, cacheSize)
-		if confIncrement == 0 {
-			cache[0] = intToByteArrPtr(confValue)
-		}
-	}
+	cache := newIntValueCache(confMin, confMax, intCounterMaxCacheSize)
 
 	staticMeta := &metric.MetricStaticMetadata{
 		Name:       &metricName,
@@ -115,6 +111,7 @@ func NewIntCounterGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatt
 
 	sharedData := &intCounterSharedData{
 		metadata:  staticMeta,
+		value:     confValue,
 		increment: confIncrement,
 		min:       confMin,
 		max:       confMax,
@@ -123,18 +120,29 @@ func NewIntCounterGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatt
 		formatter: f,
 	}
 
-	return &intCounter{value: confValue, sharedData: sharedData}, nil
+	return &intCounter{value: confValue, increment: confIncrement, sharedData: sharedData}, nil
 }
 
 // Clone the current generator into a new struct with the current value for value and the same pointer for sharedData
 func (g intCounter) Clone(newName string, specificTags *[]byte) Generator {
-	newg := intCounter{value: g.value, sharedData: g.sharedData}
+	newg := intCounter{value: g.value, increment: g.increment, sharedData: g.sharedData}
 	newNameBytes := []byte(newName)
 	newg.name = &newNameBytes
 	newg.tags = specificTags
 	return &newg
 }
 
+// Restart sets the counter back to its initial value
+func (g *intCounter) Restart() {
+	g.value = g.sharedData.value
+	g.increment = g.sharedData.increment
+}
+
+// SetClock replaces the clock the timestamps are read from
+func (g *intCounter) SetClock(clock Clock) {
+	g.clock = clock
+}
+
 // Return the name of the generator (as specificed on the command-line)
 func (g *intCounter) GetName() string {
 	if g.name != nil {
@@ -167,64 +175,40 @@ func (g *intCounter) ToString() string {
 	return sb.String()
 }
 
+func (g *intCounter) lastValue() (float64, bool) {
+	return g.last, true
+}
+
 // Generates a metric struct with a value computed from the generator's rules
 func (g *intCounter) GenerateMetric() *metric.Metric {
-	timestamp := time.Now().UnixNano()
+	timestamp := clockNow(g.clock).UnixNano()
 	var retMetric *metric.Metric
 
-	if g.sharedData.increment == 0 { // Static value, use cache[0] right away
-		retMetric = &metric.Metric{
-			Metadata:  g.sharedData.metadata,
-			Name:      g.name,
-			Value:     g.sharedData.cache[0],
-			Tags:      g.tags,
-			Timestamp: &timestamp,
-		}
-	} else { // If counter value, first check if the cache was initialized
-		if g.sharedData.cache != nil {
-			// It the cache is initialized, check if the current value is in cache. If not, add it
-			if g.sharedData.cache[(g.value-g.sharedData.min)] == nil {
-				g.sharedData.cache[(g.value - g.sharedData.min)] = intToByteArrPtr(g.value)
-			}
-
-			retMetric = &metric.Metric{
-				Metadata:  g.sharedData.metadata,
-				Name:      g.name,
-				Value:     g.sharedData.cache[(g.value - g.sharedData.min)],
-				Tags:      g.tags,
-				Timestamp: &timestamp,
-			}
-		} else { // If the cache is not initialize, create a metric without cache
-			byteArr := intToByteArrPtr(g.value)
-			retMetric = &metric.Metric{
-				Metadata:  g.sharedData.metadata,
-				Name:      g.name,
-				Value:     byteArr,
-				Tags:      g.tags,
-				Timestamp: &timestamp}
-		}
+	retMetric = &metric.Metric{
+		Metadata:  g.sharedData.metadata,
+		Name:      g.name,
+		Value:     g.sharedData.cache.get(g.value),
+		Tags:      g.tags,
+		Timestamp: &timestamp,
+	}
+	g.last = float64(g.value)
 
-		g.value += g.sharedData.increment
+	if g.increment != 0 {
+		g.value += g.increment
 		if g.value > g.sharedData.max || g.value < g.sharedData.min {
-			if g.sharedData.increment > 0 {
+			if g.increment > 0 {
 				if g.sharedData.reset {
 					g.value = g.sharedData.min
 				} else {
-					// bug: or "limitation" if multiple workers are using the same generator, the first worker to attain min/max will "set" the single-value cache
-					// for all workers so all of them will return the max value as soon as the first worker does
-					g.value = g.sharedData.max // If we hit the max and we'll stay there, change to 'increment == 0' mode
-					g.sharedData.increment = 0
-					g.sharedData.cache = make([]*[]byte, 1)
-					g.sharedData.cache[0] = intToByteArrPtr(g.value)
+					g.value = g.sharedData.max // If we hit the max and we'll stay there, stop incrementing
+					g.increment = 0
 				}
 			} else {
 				if g.sharedData.reset {
 					g.value = g.sharedData.max
 				} else {
-					g.value = g.sharedData.min // If we hit the min and we'll stay there, change to 'increment == 0' mode
-					g.sharedData.increment = 0
-					g.sharedData.cache = make([]*[]byte, 1)
-					g.sharedData.cache[0] = intToByteArrPtr(g.value)
+					g.value = g.sharedData.min // If we hit the min and we'll stay there, stop incrementing
+					g.increment = 0
 				}
 			}
 		}
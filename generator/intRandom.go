@@ -6,7 +6,6 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/aleveille/lagrande/formatter"
 	"github.com/aleveille/lagrande/metric"
@@ -15,6 +14,9 @@ import (
 type intRandom struct {
 	name       *[]byte
 	tags       *[]byte
+	last       float64    // Value of the last tick, read by the derived generators
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
 	sharedData *intRandomSharedData
 }
 
@@ -24,7 +26,7 @@ type intRandomSharedData struct {
 	max      int
 	reset    bool
 
-	cache     []*[]byte
+	cache     *intValueCache
 	formatter *formatter.Formatter
 }
 
@@ -38,7 +40,7 @@ func NewIntRandomGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatte
 	confMax := math.MaxInt32
 
 	for _, arg := range config.Args {
-		kv := strings.Split(arg, ":")
+		kv := strings.SplitN(arg, ":", 2)
 		key := strings.TrimSpace(kv[0])
 		value := strings.TrimSpace(kv[1])
 
@@ -70,11 +72,7 @@ func NewIntRandomGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatte
 	metricName := []byte(confName)
 	metricType := []byte("gauge")
 
-	var cache []*[]byte
-	cacheSize := confMax - confMin + 1
-	if cacheSize <= intRandomMaxCacheSize && cacheSize > 0 {
-		cache = make([]*[]byte, cacheSize)
-	}
+	cache := newIntValueCache(confMin, confMax, intRandomMaxCacheSize)
 
 	staticMeta := &metric.MetricStaticMetadata{
 		Name:       &metricName,
@@ -90,7 +88,9 @@ func NewIntRandomGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatte
 		formatter: f,
 	}
 
-	return &intRandom{sharedData: sharedData}, nil
+	g := &intRandom{sharedData: sharedData}
+	g.Seed(rand.Int63())
+	return g, nil
 }
 
 // Clone the current generator into a new struct with the current value for value and the same pointer for sharedData
@@ -99,9 +99,20 @@ func (g intRandom) Clone(newName string, specificTags *[]byte) Generator {
 	newNameBytes := []byte(newName)
 	newg.name = &newNameBytes
 	newg.tags = specificTags
+	newg.Seed(rand.Int63())
 	return &newg
 }
 
+// Seed replaces the random source of the generator
+func (g *intRandom) Seed(seed int64) {
+	g.random = newRandom(seed)
+}
+
+// SetClock replaces the clock the timestamps are read from
+func (g *intRandom) SetClock(clock Clock) {
+	g.clock = clock
+}
+
 // Return the name of the generator (as specificed on the command-line)
 func (g *intRandom) GetName() string {
 	if g.name != nil {
@@ -115,32 +126,21 @@ func (g *intRandom) ToString() string {
 	return fmt.Sprintf("Random int generator (%s) between %d and %d", *g.sharedData.metadata.Name, g.sharedData.min, g.sharedData.max)
 }
 
+func (g *intRandom) lastValue() (float64, bool) {
+	return g.last, true
+}
+
 // Generates a metric struct with a value computed from the generator's rules
 func (g *intRandom) GenerateMetric() *metric.Metric {
-	timestamp := time.Now().UnixNano()
+	timestamp := clockNow(g.clock).UnixNano()
 	var retMetric *metric.Metric
 
-	randomInt := rand.Intn(g.sharedData.max-g.sharedData.min) + g.sharedData.min
-	if g.sharedData.cache != nil {
-		if g.sharedData.cache[randomInt-g.sharedData.min] == nil {
-			g.sharedData.cache[randomInt-g.sharedData.min] = intToByteArrPtr(randomInt)
-		}
-
-		retMetric = &metric.Metric{
-			Metadata:  g.sharedData.metadata,
-			Name:      g.name,
-			Value:     g.sharedData.cache[randomInt-g.sharedData.min],
-			Tags:      g.tags,
-			Timestamp: &timestamp}
-
-		return retMetric
-	}
-
-	byteArr := []byte(fmt.Sprintf("%d", randomInt))
+	randomInt := g.random.Intn(g.sharedData.max-g.sharedData.min) + g.sharedData.min
+	g.last = float64(randomInt)
 	retMetric = &metric.Metric{
 		Metadata:  g.sharedData.metadata,
 		Name:      g.name,
-		Value:     &byteArr,
+		Value:     g.sharedData.cache.get(randomInt),
 		Tags:      g.tags,
 		Timestamp: &timestamp}
 
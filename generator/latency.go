@@ -2,6 +2,8 @@ package generator
 
 import (
 	"fmt"
+	"math"
+	mathrand "math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -14,9 +16,15 @@ import (
 )
 
 type latencyDistribution struct {
-	name       *[]byte
-	tags       *[]byte
-	sharedData *latencyDistributionSharedData
+	name    *[]byte
+	tags    *[]byte
+	distrib distuv.Gamma   // Each worker has its own random source, sources aren't thread-safe
+	random  *mathrand.Rand // Only used for the special values
+	last    float64        // Value of the last tick, read by the derived generators
+	// End of the previous interval, which is the start of the current one for exponential histograms
+	previousTimestamp int64
+	clock             Clock // nil for the wall clock
+	sharedData        *latencyDistributionSharedData
 }
 
 type latencyDistributionSharedData struct {
@@ -24,6 +32,12 @@ type latencyDistributionSharedData struct {
 	min      float64
 	max      float64
 	distrib  distuv.Gamma
+	// With histogram: exponential, samples values are drawn at each tick and aggregated into an exponential histogram
+	// of the given scale
+	histogram string
+	scale     int32
+	samples   int
+	encoding  *valueEncoding
 
 	formatter *formatter.Formatter
 }
@@ -54,6 +68,16 @@ type latencyDistributionSharedData struct {
 // Where the X axis is the response time and the Y axis is the likelyhood of generating this response time
 
 // If you want to vizualize Gamma distribution interactively: https://www.medcalc.org/manual/gamma_distribution_functions.php
+//
+// With histogram: exponential, the generator draws samples values at each tick and aggregates them into a sparse
+// exponential histogram (OTLP ExponentialHistogram, Prometheus native histogram) of the given scale, also known as
+// schema. The histogram is sent by the formats that support it (otlp), the other formats get the mean of the samples.
+
+const (
+	// Scales supported by Prometheus native histograms, OTLP supports a wider range
+	latencyMinScale = -4
+	latencyMaxScale = 8
+)
 
 // NewLatencyDistributionGenerator returns a struct compliant with the Generator interface
 // You want to call this method once per config and then clone the generator using Clone() so that metadata and cache are shared for all workers
@@ -63,9 +87,12 @@ func NewLatencyDistributionGenerator(config CLIConfig, tags *[]byte, f *formatte
 	confMax := 10000.0
 	confAlpha := 1.5
 	confBeta := 10.0
+	confHistogram := ""
+	confScale := 3
+	confSamples := 100
 
 	for _, arg := range config.Args {
-		kv := strings.Split(arg, ":")
+		kv := strings.SplitN(arg, ":", 2)
 		key := strings.TrimSpace(kv[0])
 		value := strings.TrimSpace(kv[1])
 
@@ -99,8 +126,28 @@ func NewLatencyDistributionGenerator(config CLIConfig, tags *[]byte, f *formatte
 				return nil, fmt.Errorf("Error parsing latency distribution beta '%s'", value)
 			}
 			confBeta = v
+		case "histogram":
+			if value != "none" && value != "exponential" {
+				return nil, fmt.Errorf("Invalid latency distribution histogram '%s', must be 'none' or 'exponential'", value)
+			}
+			confHistogram = value
+		case "scale":
+			v, err := strconv.Atoi(value)
+			if err != nil || v < latencyMinScale || v > latencyMaxScale {
+				return nil, fmt.Errorf("Error parsing latency distribution scale '%s', it must be an integer between %d and %d", value, latencyMinScale, latencyMaxScale)
+			}
+			confScale = v
+		case "samples":
+			v, err := strconv.Atoi(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing latency distribution samples '%s', it must be a > 0 integer", value)
+			}
+			confSamples = v
 		}
 	}
+	if confHistogram == "none" {
+		confHistogram = ""
+	}
 
 	if confMax < confMin {
 		return nil, fmt.Errorf("Maximum '%f' cannot be inferior to minimum '%f'", confMax, confMin)
@@ -112,6 +159,11 @@ func NewLatencyDistributionGenerator(config CLIConfig, tags *[]byte, f *formatte
 		return nil, fmt.Errorf("Beta must be a positive number")
 	}
 
+	encoding, err := parseValueEncoding("latency", config)
+	if err != nil {
+		return nil, err
+	}
+
 	metricName := []byte(confName)
 	metricType := []byte("gauge")
 
@@ -121,7 +173,7 @@ func NewLatencyDistributionGenerator(config CLIConfig, tags *[]byte, f *formatte
 		MetricType: &metricType,
 	}
 
-	randSource := rand.NewSource(uint64(time.Now().UnixNano()))
+	randSource := rand.NewSource(uint64(mathrand.Int63()))
 	betaDistrib := distuv.Gamma{Alpha: confAlpha, Beta: confBeta, Src: randSource}
 
 	sharedData := &latencyDistributionSharedData{
@@ -129,21 +181,40 @@ func NewLatencyDistributionGenerator(config CLIConfig, tags *[]byte, f *formatte
 		min:       confMin,
 		max:       confMax,
 		distrib:   betaDistrib,
+		histogram: confHistogram,
+		scale:     int32(confScale),
+		samples:   confSamples,
+		encoding:  encoding,
 		formatter: f,
 	}
 
-	return &latencyDistribution{sharedData: sharedData}, nil
+	g := &latencyDistribution{sharedData: sharedData, distrib: betaDistrib, previousTimestamp: time.Now().UnixNano()}
+	g.random = newRandom(mathrand.Int63())
+	return g, nil
 }
 
-// Clone the current generator into a new struct with the current value for value and the same pointer for sharedData
+// Clone the current generator into a new struct with its own random source and the same pointer for sharedData
 func (g latencyDistribution) Clone(newName string, specificTags *[]byte) Generator {
-	newg := latencyDistribution{sharedData: g.sharedData}
+	newg := latencyDistribution{sharedData: g.sharedData, distrib: g.sharedData.distrib, previousTimestamp: time.Now().UnixNano()}
+	newg.Seed(mathrand.Int63())
 	newNameBytes := []byte(newName)
 	newg.name = &newNameBytes
 	newg.tags = specificTags
 	return &newg
 }
 
+// Seed replaces the random source of the generator
+func (g *latencyDistribution) Seed(seed int64) {
+	g.distrib.Src = rand.NewSource(uint64(seed))
+	g.random = newRandom(seed)
+}
+
+// SetClock replaces the clock the timestamps are read from and starts the interval of the histograms at its time
+func (g *latencyDistribution) SetClock(clock Clock) {
+	g.clock = clock
+	g.previousTimestamp = clockNow(clock).UnixNano()
+}
+
 // Return the name of the generator (as specificed on the command-line)
 func (g *latencyDistribution) GetName() string {
 	if g.name != nil {
@@ -158,22 +229,91 @@ func (g *latencyDistribution) scale(num float64) float64 {
 
 // Return a human-readable description of the generators
 func (g *latencyDistribution) ToString() string {
-	return fmt.Sprintf("Latency distribution generator (%s) between %.4f and %.4f with a mean of %.4f, P50=%.4f, P95=%.4f and P99=%.4f", *g.sharedData.metadata.Name, g.sharedData.min, g.sharedData.max, g.scale(g.sharedData.distrib.Mean()), g.scale(g.sharedData.distrib.Quantile(0.5)), g.scale(g.sharedData.distrib.Quantile(0.95)), g.scale(g.sharedData.distrib.Quantile(0.99)))
+	description := fmt.Sprintf("Latency distribution generator (%s) between %.4f and %.4f with a mean of %.4f, P50=%.4f, P95=%.4f and P99=%.4f", *g.sharedData.metadata.Name, g.sharedData.min, g.sharedData.max, g.scale(g.sharedData.distrib.Mean()), g.scale(g.sharedData.distrib.Quantile(0.5)), g.scale(g.sharedData.distrib.Quantile(0.95)), g.scale(g.sharedData.distrib.Quantile(0.99)))
+	if g.sharedData.histogram == "exponential" {
+		description += fmt.Sprintf(", aggregating %d samples per tick into an exponential histogram of scale %d", g.sharedData.samples, g.sharedData.scale)
+	}
+	return description + g.sharedData.encoding.String()
+}
+
+func (g *latencyDistribution) lastValue() (float64, bool) {
+	return g.last, true
 }
 
 // Generates a metric struct with a value computed from the generator's rules
 func (g *latencyDistribution) GenerateMetric() *metric.Metric {
-	value := g.scale(g.sharedData.distrib.Rand())
-
-	timestamp := time.Now().UnixNano() / int64(time.Nanosecond)
+	timestamp := clockNow(g.clock).UnixNano()
 
 	retMetric := &metric.Metric{
 		Metadata:  g.sharedData.metadata,
 		Name:      g.name,
-		Value:     float64ToByteArrPtr(value),
 		Tags:      g.tags,
 		Timestamp: &timestamp,
 	}
 
+	if g.sharedData.histogram == "exponential" {
+		h := newExponentialHistogram(g.sharedData.scale)
+		h.StartTimestamp = g.previousTimestamp
+		for i := 0; i < g.sharedData.samples; i++ {
+			h.record(g.scale(g.distrib.Rand()))
+		}
+		retMetric.Histogram = h.ExponentialHistogram
+		g.last = h.Sum / float64(h.Count)
+	} else {
+		g.last = g.scale(g.distrib.Rand())
+	}
+	retMetric.Value, retMetric.Stale = g.sharedData.encoding.encode(g.last, g.random)
+	g.previousTimestamp = timestamp
+
 	return retMetric
 }
+
+// exponentialHistogram builds a metric.ExponentialHistogram sample by sample
+type exponentialHistogram struct {
+	*metric.ExponentialHistogram
+	scaleFactor float64
+}
+
+func newExponentialHistogram(scale int32) *exponentialHistogram {
+	return &exponentialHistogram{
+		ExponentialHistogram: &metric.ExponentialHistogram{Scale: scale, Min: math.Inf(1), Max: math.Inf(-1)},
+		scaleFactor:          math.Ldexp(math.Log2E, int(scale)),
+	}
+}
+
+// bucketIndex returns the index of the bucket of a > 0 value: the bucket i counts the values in (base^i, base^(i+1)]
+func (h *exponentialHistogram) bucketIndex(value float64) int32 {
+	return int32(math.Ceil(math.Log(value)*h.scaleFactor)) - 1
+}
+
+func (h *exponentialHistogram) record(value float64) {
+	h.Count++
+	h.Sum += value
+	h.Min = math.Min(h.Min, value)
+	h.Max = math.Max(h.Max, value)
+
+	switch {
+	case value > 0:
+		h.PositiveOffset, h.PositiveCounts = incrementBucket(h.PositiveOffset, h.PositiveCounts, h.bucketIndex(value))
+	case value < 0:
+		h.NegativeOffset, h.NegativeCounts = incrementBucket(h.NegativeOffset, h.NegativeCounts, h.bucketIndex(-value))
+	default:
+		h.ZeroCount++
+	}
+}
+
+// incrementBucket increments the bucket at index, growing the dense range of buckets starting at offset if needed
+func incrementBucket(offset int32, counts []uint64, index int32) (int32, []uint64) {
+	if len(counts) == 0 {
+		return index, []uint64{1}
+	}
+	if index < offset {
+		counts = append(make([]uint64, offset-index), counts...)
+		offset = index
+	}
+	for int(index-offset) >= len(counts) {
+		counts = append(counts, 0)
+	}
+	counts[index-offset]++
+	return offset, counts
+}
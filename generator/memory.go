@@ -0,0 +1,334 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+)
+
+// The memory generator emits the used, free, cached and buffers memory of a host, in bytes and tagged with
+// state=<state>. The four series always sum to exactly total.
+//
+// The used memory follows one of these behaviors:
+//  - steady: stays around used% of total
+//  - leak: grows from used% of total by rate% of total per minute and plateaus at peak% of total
+//  - sawtooth: like a GC'ed heap (JVM, Go...), grows by rate% of total per minute up to peak% and drops back to used%
+//  - oom: grows like a leak up to peak% and is reset as if the process was OOM-killed and restarted. Unlike a GC, the
+//    page cache and buffers are dropped too and slowly fill up again.
+// The page cache fills up to cache% of the memory left by the used memory and the buffers stay around 2% of total.
+
+var memoryStates = []string{"used", "free", "cached", "buffers"}
+
+const (
+	memoryBuffersPercent = 2.0
+	// Share of the missing page cache filled every minute
+	memoryCacheFillPerMinute = 0.1
+	// After an OOM, the page cache and buffers restart from this share of their usual size
+	memoryOOMCacheRatio = 0.05
+)
+
+type memory struct {
+	name       *[]byte
+	tags       []*[]byte // state tags of each series, stable for the whole life of the worker
+	used       float64   // Used memory, in percent of total, without the noise
+	cached     float64   // Page cache, in percent of total
+	buffers    float64   // Buffers, in percent of total
+	lastTick   time.Time
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
+	sharedData *memorySharedData
+}
+
+type memorySharedData struct {
+	metadata *metric.MetricStaticMetadata
+	total    int64
+	behavior string
+	used     float64
+	rate     float64
+	peak     float64
+	cache    float64
+	noise    float64
+
+	formatter *formatter.Formatter
+}
+
+// NewMemoryGenerator returns a struct compliant with the Generator and SeriesGenerator interfaces
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewMemoryGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	confName := "memory"
+	confTotal := int64(16 << 30)
+	confBehavior := "sawtooth"
+	confUsed := 30.0
+	confRate := 5.0
+	confPeak := 80.0
+	confCache := 50.0
+	confNoise := 0.5
+
+	parsePercent := func(key string, value string) (float64, error) {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 || v > 100 {
+			return 0, fmt.Errorf("Error parsing memory %s '%s', it must be a percentage between 0 and 100", key, value)
+		}
+		return v, nil
+	}
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		var err error
+		switch key {
+		case "name":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("Error parsing memory name '%s'", value)
+			}
+			confName = value
+		case "total":
+			v, parseErr := parseBytes(value)
+			if parseErr != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing memory total '%s', it must be a > 0 number of bytes, optionally with a K, M, G or T suffix", value)
+			}
+			confTotal = v
+		case "behavior":
+			if value != "steady" && value != "leak" && value != "sawtooth" && value != "oom" {
+				return nil, fmt.Errorf("Invalid memory behavior '%s', must be one of 'steady', 'leak', 'sawtooth' or 'oom'", value)
+			}
+			confBehavior = value
+		case "used":
+			confUsed, err = parsePercent(key, value)
+		case "rate":
+			confRate, err = parsePercent(key, value)
+		case "peak":
+			confPeak, err = parsePercent(key, value)
+		case "cache":
+			confCache, err = parsePercent(key, value)
+		case "noise":
+			confNoise, err = parsePercent(key, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if confPeak < confUsed {
+		return nil, fmt.Errorf("Peak '%.2f' cannot be inferior to used '%.2f'", confPeak, confUsed)
+	}
+	if confPeak+memoryBuffersPercent > 100 {
+		return nil, fmt.Errorf("Peak '%.2f' must leave room for the buffers, it cannot be greater than %.2f", confPeak, 100-memoryBuffersPercent)
+	}
+
+	metricName := []byte(confName)
+	metricType := []byte("gauge")
+
+	staticMeta := &metric.MetricStaticMetadata{
+		Name:       &metricName,
+		Tags:       tags,
+		MetricType: &metricType,
+	}
+
+	sharedData := &memorySharedData{
+		metadata:  staticMeta,
+		total:     confTotal,
+		behavior:  confBehavior,
+		used:      confUsed,
+		rate:      confRate,
+		peak:      confPeak,
+		cache:     confCache,
+		noise:     confNoise,
+		formatter: f,
+	}
+
+	g := &memory{sharedData: sharedData}
+	g.initSeries(nil)
+	g.Seed(rand.Int63())
+	return g, nil
+}
+
+// initSeries creates the tags of each series, Seed picks the starting point of the worker
+func (g *memory) initSeries(workerTags *[]byte) {
+	g.tags = make([]*[]byte, len(memoryStates))
+	for i, state := range memoryStates {
+		g.tags[i] = seriesTags(workerTags, fmt.Sprintf("state=%s", state))
+	}
+}
+
+// Seed replaces the random source of the generator and picks the starting point of the worker
+func (g *memory) Seed(seed int64) {
+	g.random = newRandom(seed)
+	g.used = g.sharedData.used
+	if g.sharedData.behavior == "sawtooth" || g.sharedData.behavior == "oom" {
+		// Start anywhere in the cycle so that the workers don't all drop at the same time
+		g.used += g.random.Float64() * (g.sharedData.peak - g.sharedData.used)
+	}
+	g.cached = (100 - g.used - memoryBuffersPercent) * g.sharedData.cache / 100
+	g.buffers = memoryBuffersPercent
+	g.lastTick = clockNow(g.clock)
+}
+
+// SetClock replaces the clock the generator follows, the memory grows from its time on
+func (g *memory) SetClock(clock Clock) {
+	g.clock = clock
+	g.lastTick = clockNow(clock)
+}
+
+// Clone the current generator into a new struct with its own memory state and the same pointer for sharedData
+func (g memory) Clone(newName string, specificTags *[]byte) Generator {
+	newg := memory{sharedData: g.sharedData}
+	newNameBytes := []byte(newName)
+	newg.name = &newNameBytes
+	newg.initSeries(specificTags)
+	newg.Seed(rand.Int63())
+	return &newg
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *memory) GetName() string {
+	if g.name != nil {
+		return string(*g.name)
+	}
+	return string(*g.sharedData.metadata.Name)
+}
+
+// Return a human-readable description of the generator
+func (g *memory) ToString() string {
+	s := g.sharedData
+	switch s.behavior {
+	case "steady":
+		return fmt.Sprintf("Memory generator (%s) of %s with a steady %.2f%% used", *s.metadata.Name, humanReadableBytes(s.total), s.used)
+	case "leak":
+		return fmt.Sprintf("Memory generator (%s) of %s leaking from %.2f%% used by %.2f%% per minute up to %.2f%%", *s.metadata.Name, humanReadableBytes(s.total), s.used, s.rate, s.peak)
+	case "oom":
+		return fmt.Sprintf("Memory generator (%s) of %s leaking from %.2f%% used by %.2f%% per minute and OOM-killed at %.2f%%", *s.metadata.Name, humanReadableBytes(s.total), s.used, s.rate, s.peak)
+	default:
+		return fmt.Sprintf("Memory generator (%s) of %s with a GC sawtooth from %.2f%% to %.2f%% used, growing by %.2f%% per minute", *s.metadata.Name, humanReadableBytes(s.total), s.used, s.peak, s.rate)
+	}
+}
+
+// Return the number of series generated at each tick: one per memory state
+func (g *memory) SeriesCount() int {
+	return len(memoryStates)
+}
+
+// Generates the metric of the used memory only, workers call GenerateMetrics to get all the series
+// lastValue is the sum of the states: the total memory
+func (g *memory) lastValue() (float64, bool) {
+	return float64(g.sharedData.total), true
+}
+
+func (g *memory) GenerateMetric() *metric.Metric {
+	return g.GenerateMetrics()[0]
+}
+
+// Generates a metric for each memory state
+func (g *memory) GenerateMetrics() []*metric.Metric {
+	now := clockNow(g.clock)
+	timestamp := now.UnixNano()
+	g.advance(now.Sub(g.lastTick))
+	g.lastTick = now
+
+	metrics := make([]*metric.Metric, len(memoryStates))
+	for i, bytes := range g.split() {
+		metrics[i] = &metric.Metric{
+			Metadata:  g.sharedData.metadata,
+			Name:      g.name,
+			Value:     intToByteArrPtr(int(bytes)),
+			Tags:      g.tags[i],
+			Timestamp: &timestamp,
+		}
+	}
+
+	return metrics
+}
+
+// advance moves the memory state forward by elapsed
+func (g *memory) advance(elapsed time.Duration) {
+	s := g.sharedData
+
+	if s.behavior != "steady" {
+		g.used += s.rate * elapsed.Minutes()
+		if g.used >= s.peak {
+			switch s.behavior {
+			case "leak":
+				g.used = s.peak
+			case "sawtooth":
+				g.used = s.used
+			case "oom":
+				g.used = s.used
+				g.cached *= memoryOOMCacheRatio
+				g.buffers *= memoryOOMCacheRatio
+			}
+		}
+	}
+
+	// The page cache and buffers slowly fill up what the used memory leaves
+	targetCached := (100 - g.used - memoryBuffersPercent) * s.cache / 100
+	fill := math.Min(1, memoryCacheFillPerMinute*elapsed.Minutes())
+	g.cached += (targetCached - g.cached) * fill
+	g.buffers += (memoryBuffersPercent - g.buffers) * fill
+}
+
+// split returns the used, free, cached and buffers memory in bytes, summing to exactly total
+func (g *memory) split() []int64 {
+	total := g.sharedData.total
+	toBytes := func(percent float64) int64 {
+		return int64(math.Max(0, percent) / 100 * float64(total))
+	}
+
+	used := toBytes(g.used + g.random.NormFloat64()*g.sharedData.noise)
+	buffers := toBytes(g.buffers)
+	cached := toBytes(g.cached)
+	// The noise may push the used memory over what's left by the cache, the kernel would evict the cache first
+	if used+buffers+cached > total {
+		cached = total - used - buffers
+		if cached < 0 {
+			cached = 0
+			used = total - buffers
+		}
+	}
+
+	return []int64{used, total - used - cached - buffers, cached, buffers}
+}
+
+// parseBytes parses a number of bytes with an optional K, M, G or T suffix (powers of 1024), eg: 512M, 16GiB
+func parseBytes(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(s), "B"), "I")
+	multiplier := int64(1)
+	if len(s) > 0 {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v * float64(multiplier)), nil
+}
+
+func humanReadableBytes(b int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	v := float64(b)
+	i := 0
+	for ; v >= 1024 && i < len(units)-1; i++ {
+		v /= 1024
+	}
+	return fmt.Sprintf("%.4g%s", v, units[i])
+}
@@ -0,0 +1,253 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+)
+
+// The random walk generator emits a smoothly-varying float gauge: at each tick, the value moves by a random step drawn
+// from a normal (standard deviation step) or uniform (between -step and step) distribution. Unlike randomFloat, two
+// consecutive values are close, like most real gauges, which matters for the XOR compression of the TSDBs.
+//
+// With reversion > 0, the walk is pulled back towards mean by that share of the distance at every tick (an
+// Ornstein-Uhlenbeck process), so that it hovers around mean instead of wandering between the bounds. The value
+// bounces back off min and max.
+
+type randomWalk struct {
+	name       *[]byte
+	tags       *[]byte
+	value      float64
+	last       float64    // Value of the last tick, read by the derived generators
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
+	sharedData *randomWalkSharedData
+}
+
+type randomWalkSharedData struct {
+	metadata     *metric.MetricStaticMetadata
+	min          float64
+	max          float64
+	value        float64
+	randomValue  bool // Whether each worker starts at a random value between min and max
+	step         float64
+	distribution string
+	reversion    float64
+	mean         float64
+	encoding     *valueEncoding
+
+	formatter *formatter.Formatter
+}
+
+// NewRandomWalkGenerator returns a struct compliant with the Generator interface
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewRandomWalkGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	confName := "randomWalk"
+	confMin := 0.0
+	confMax := 100.0
+	var confValue, confMean *float64
+	confStep := 1.0
+	confDistribution := "normal"
+	confReversion := 0.0
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "name":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("Error parsing random walk name '%s'", value)
+			}
+			confName = value
+		case "min":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing random walk min '%s'", value)
+			}
+			confMin = v
+		case "max":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing random walk max '%s'", value)
+			}
+			confMax = v
+		case "value":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing random walk value '%s'", value)
+			}
+			confValue = &v
+		case "step":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing random walk step '%s', it must be a >= 0 number", value)
+			}
+			confStep = v
+		case "distribution":
+			if value != "normal" && value != "uniform" {
+				return nil, fmt.Errorf("Invalid random walk distribution '%s', must be 'normal' or 'uniform'", value)
+			}
+			confDistribution = value
+		case "reversion":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 || v > 1 {
+				return nil, fmt.Errorf("Error parsing random walk reversion '%s', it must be between 0 and 1", value)
+			}
+			confReversion = v
+		case "mean":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing random walk mean '%s'", value)
+			}
+			confMean = &v
+		}
+	}
+
+	if confMax < confMin {
+		return nil, fmt.Errorf("Maximum '%f' cannot be inferior to minimum '%f'", confMax, confMin)
+	}
+	if confValue != nil && (*confValue < confMin || *confValue > confMax) {
+		return nil, fmt.Errorf("Value '%f' must be between minimum '%f' and maximum '%f'", *confValue, confMin, confMax)
+	}
+	if confMean == nil {
+		mean := (confMin + confMax) / 2
+		confMean = &mean
+	} else if *confMean < confMin || *confMean > confMax {
+		return nil, fmt.Errorf("Mean '%f' must be between minimum '%f' and maximum '%f'", *confMean, confMin, confMax)
+	}
+
+	encoding, err := parseValueEncoding("random walk", config)
+	if err != nil {
+		return nil, err
+	}
+
+	metricName := []byte(confName)
+	metricType := []byte("gauge")
+
+	staticMeta := &metric.MetricStaticMetadata{
+		Name:       &metricName,
+		Tags:       tags,
+		MetricType: &metricType,
+	}
+
+	sharedData := &randomWalkSharedData{
+		metadata:     staticMeta,
+		min:          confMin,
+		max:          confMax,
+		randomValue:  confValue == nil,
+		step:         confStep,
+		distribution: confDistribution,
+		reversion:    confReversion,
+		mean:         *confMean,
+		encoding:     encoding,
+		formatter:    f,
+	}
+	if confValue != nil {
+		sharedData.value = *confValue
+	}
+
+	g := &randomWalk{sharedData: sharedData}
+	g.Seed(rand.Int63())
+	return g, nil
+}
+
+// Seed replaces the random source of the generator and sets the value the worker starts its walk from
+func (g *randomWalk) Seed(seed int64) {
+	s := g.sharedData
+	g.random = newRandom(seed)
+	if s.randomValue {
+		g.value = s.min + g.random.Float64()*(s.max-s.min)
+	} else {
+		g.value = s.value
+	}
+}
+
+// Clone the current generator into a new struct with its own walk and the same pointer for sharedData
+func (g randomWalk) Clone(newName string, specificTags *[]byte) Generator {
+	newg := randomWalk{sharedData: g.sharedData}
+	newNameBytes := []byte(newName)
+	newg.name = &newNameBytes
+	newg.tags = specificTags
+	newg.Seed(rand.Int63())
+	return &newg
+}
+
+// SetClock replaces the clock the timestamps are read from
+func (g *randomWalk) SetClock(clock Clock) {
+	g.clock = clock
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *randomWalk) GetName() string {
+	if g.name != nil {
+		return string(*g.name)
+	}
+	return string(*g.sharedData.metadata.Name)
+}
+
+// Return a human-readable description of the generator
+func (g *randomWalk) ToString() string {
+	s := g.sharedData
+	description := fmt.Sprintf("Random walk generator (%s) between %f and %f with %s steps of %f", *s.metadata.Name, s.min, s.max, s.distribution, s.step)
+	if s.reversion > 0 {
+		description += fmt.Sprintf(", reverting to %f by %.2f%% per tick", s.mean, s.reversion*100)
+	}
+	return description + s.encoding.String()
+}
+
+// walk moves the value by one step and returns it
+func (g *randomWalk) walk() float64 {
+	s := g.sharedData
+
+	var step float64
+	if s.distribution == "uniform" {
+		step = (2*g.random.Float64() - 1) * s.step
+	} else {
+		step = g.random.NormFloat64() * s.step
+	}
+	g.value = reflectInRange(g.value+s.reversion*(s.mean-g.value)+step, s.min, s.max)
+	return g.value
+}
+
+// reflectInRange bounces v back off min and max, as many times as needed if the step is larger than the range
+func reflectInRange(v float64, min float64, max float64) float64 {
+	width := max - min
+	if width == 0 {
+		return min
+	}
+	offset := math.Mod(v-min, 2*width)
+	if offset < 0 {
+		offset += 2 * width
+	}
+	if offset > width {
+		offset = 2*width - offset
+	}
+	return min + offset
+}
+
+func (g *randomWalk) lastValue() (float64, bool) {
+	return g.last, true
+}
+
+// Generates a metric struct with a value computed from the generator's rules
+func (g *randomWalk) GenerateMetric() *metric.Metric {
+	timestamp := clockNow(g.clock).UnixNano()
+	g.last = g.walk()
+	value, stale := g.sharedData.encoding.encode(g.last, g.random)
+
+	return &metric.Metric{
+		Metadata:  g.sharedData.metadata,
+		Name:      g.name,
+		Value:     value,
+		Tags:      g.tags,
+		Timestamp: &timestamp,
+		Stale:     stale,
+	}
+}
@@ -0,0 +1,283 @@
+package generator
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+)
+
+// The replay generator replays recorded series (see replayFormats.go for the supported files) so that benchmarks use
+// real data, which compresses very differently from random data. Each worker replays one of the series of the file,
+// picked round-robin, one point per tick.
+//
+// With timestamps: true, the recorded timestamps are replayed, shifted so that the series starts when the worker
+// starts. Otherwise the points get the current time. Once the series is over, it's replayed from the beginning
+// (loop: true) or its last value is repeated (loop: false).
+
+type replay struct {
+	name       *[]byte
+	tags       *[]byte
+	points     []replayPoint
+	position   int
+	loops      int64
+	done       bool       // Without loop, set once the last point was replayed
+	shift      int64      // Added to the recorded timestamps so that the series starts when the worker starts
+	last       float64    // Value of the last tick, read by the derived generators
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
+	sharedData *replaySharedData
+}
+
+type replaySharedData struct {
+	metadata    *metric.MetricStaticMetadata
+	file        string
+	format      string
+	series      [][]replayPoint
+	timestamps  bool
+	loop        bool
+	randomStart bool
+	encoding    *valueEncoding
+	nextSeries  int64 // Index of the series the next clone replays, incremented atomically so that Clone is safe to call concurrently
+
+	formatter *formatter.Formatter
+}
+
+// NewReplayGenerator returns a struct compliant with the Generator interface
+// You want to call this method once per config and then clone the generator using Clone() so that the recorded series are shared for all workers
+func NewReplayGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	confName := "replay"
+	confFile := ""
+	confFormat := "csv"
+	confSeries := ""
+	confTimestamps := false
+	confLoop := true
+	confRandomStart := false
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "name":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("Error parsing replay name '%s'", value)
+			}
+			confName = value
+		case "file":
+			confFile = value
+		case "format":
+			if _, ok := replayParsers[value]; !ok {
+				return nil, fmt.Errorf("Invalid replay format '%s', must be one of 'csv', 'whisper', 'influx' or 'prometheus'", value)
+			}
+			confFormat = value
+		case "series":
+			confSeries = value
+		case "timestamps":
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing replay timestamps '%s'", value)
+			}
+			confTimestamps = v
+		case "loop":
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing replay loop '%s'", value)
+			}
+			confLoop = v
+		case "start":
+			if value != "beginning" && value != "random" {
+				return nil, fmt.Errorf("Invalid replay start '%s', must be 'beginning' or 'random'", value)
+			}
+			confRandomStart = value == "random"
+		}
+	}
+
+	if len(confFile) == 0 {
+		return nil, fmt.Errorf("The replay generator requires a file")
+	}
+	file, err := os.Open(confFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening replay file: %s", err)
+	}
+	defer file.Close()
+
+	recorded, err := replayParsers[confFormat](file)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing replay file %s: %s", confFile, err)
+	}
+	sortReplayPoints(recorded)
+
+	// Sort the series so that workers replay the same series from one run to the other
+	keys := make([]string, 0, len(recorded))
+	for k, points := range recorded {
+		if len(points) > 0 && (len(confSeries) == 0 || k == confSeries) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		if len(confSeries) > 0 {
+			return nil, fmt.Errorf("Series '%s' not found in replay file %s", confSeries, confFile)
+		}
+		return nil, fmt.Errorf("No points found in replay file %s", confFile)
+	}
+
+	series := make([][]replayPoint, len(keys))
+	for i, k := range keys {
+		series[i] = recorded[k]
+		if confTimestamps && series[i][len(series[i])-1].timestamp == 0 {
+			return nil, fmt.Errorf("The replay file %s doesn't have timestamps, use timestamps: false", confFile)
+		}
+	}
+
+	encoding, err := parseValueEncoding("replay", config)
+	if err != nil {
+		return nil, err
+	}
+
+	metricName := []byte(confName)
+	metricType := []byte("gauge")
+
+	staticMeta := &metric.MetricStaticMetadata{
+		Name:       &metricName,
+		Tags:       tags,
+		MetricType: &metricType,
+	}
+
+	sharedData := &replaySharedData{
+		metadata:    staticMeta,
+		file:        confFile,
+		format:      confFormat,
+		series:      series,
+		timestamps:  confTimestamps,
+		loop:        confLoop,
+		randomStart: confRandomStart,
+		encoding:    encoding,
+		formatter:   f,
+	}
+
+	g := &replay{sharedData: sharedData}
+	g.initSeries(0)
+	g.Seed(rand.Int63())
+	return g, nil
+}
+
+// initSeries sets the series replayed by the worker, Seed sets where it starts
+func (g *replay) initSeries(index int64) {
+	s := g.sharedData
+	g.points = s.series[index%int64(len(s.series))]
+}
+
+// Seed replaces the random source of the generator and sets where the worker starts in its series
+func (g *replay) Seed(seed int64) {
+	g.random = newRandom(seed)
+	if g.sharedData.randomStart {
+		g.position = g.random.Intn(len(g.points))
+	}
+	g.shift = clockNow(g.clock).UnixNano() - g.points[g.position].timestamp
+}
+
+// SetClock replaces the clock the timestamps are read from, the series is shifted to start at its time
+func (g *replay) SetClock(clock Clock) {
+	g.clock = clock
+	g.shift = clockNow(clock).UnixNano() - g.points[g.position].timestamp
+}
+
+// Clone the current generator into a new struct replaying the next series, with the same pointer for sharedData
+func (g replay) Clone(newName string, specificTags *[]byte) Generator {
+	newg := replay{sharedData: g.sharedData}
+	newNameBytes := []byte(newName)
+	newg.name = &newNameBytes
+	newg.tags = specificTags
+	newg.initSeries(atomic.AddInt64(&g.sharedData.nextSeries, 1) - 1)
+	newg.Seed(rand.Int63())
+	return &newg
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *replay) GetName() string {
+	if g.name != nil {
+		return string(*g.name)
+	}
+	return string(*g.sharedData.metadata.Name)
+}
+
+// Return a human-readable description of the generator
+func (g *replay) ToString() string {
+	s := g.sharedData
+	points := 0
+	for _, series := range s.series {
+		points += len(series)
+	}
+
+	var options []string
+	if s.timestamps {
+		options = append(options, "with the recorded timestamps")
+	}
+	if s.loop {
+		options = append(options, "looping")
+	}
+	if s.randomStart {
+		options = append(options, "starting at a random point")
+	}
+	description := fmt.Sprintf("Replay generator (%s) of %d series (%d points) from %s (%s)", *s.metadata.Name, len(s.series), points, s.file, s.format)
+	if len(options) > 0 {
+		description += ", " + strings.Join(options, ", ")
+	}
+	return description + s.encoding.String()
+}
+
+// span returns how long a loop of the series lasts: from its first to its last point, plus the average step
+func (g *replay) span() int64 {
+	first := g.points[0].timestamp
+	last := g.points[len(g.points)-1].timestamp
+	if len(g.points) == 1 || last == first {
+		return int64(time.Second)
+	}
+	return last - first + (last-first)/int64(len(g.points)-1)
+}
+
+func (g *replay) lastValue() (float64, bool) {
+	return g.last, true
+}
+
+// Generates a metric struct with the next recorded point
+func (g *replay) GenerateMetric() *metric.Metric {
+	point := g.points[g.position]
+
+	var timestamp int64
+	if g.sharedData.timestamps && !g.done {
+		timestamp = point.timestamp + g.shift + g.loops*g.span()
+	} else {
+		timestamp = clockNow(g.clock).UnixNano()
+	}
+
+	if g.position < len(g.points)-1 {
+		g.position++
+	} else if g.sharedData.loop {
+		g.position = 0
+		g.loops++
+	} else {
+		g.done = true
+	}
+
+	g.last = point.value
+	value, stale := g.sharedData.encoding.encode(point.value, g.random)
+	return &metric.Metric{
+		Metadata:  g.sharedData.metadata,
+		Name:      g.name,
+		Value:     value,
+		Tags:      g.tags,
+		Timestamp: &timestamp,
+		Stale:     stale,
+	}
+}
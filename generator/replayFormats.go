@@ -0,0 +1,253 @@
+package generator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parsers of the files replayed by the replay generator. Each parser returns the recorded series by series key (eg:
+// the influx measurement, tags and field). The points are sorted by timestamp, in nanoseconds (0 if the file doesn't
+// have timestamps).
+
+type replayPoint struct {
+	timestamp int64
+	value     float64
+}
+
+type replayParser func(r io.Reader) (map[string][]replayPoint, error)
+
+var replayParsers = map[string]replayParser{
+	"csv":        parseReplayCSV,
+	"whisper":    parseReplayWhisperDump,
+	"influx":     parseReplayInfluxLineProtocol,
+	"prometheus": parseReplayPrometheusDump,
+}
+
+// parseReplayCSV parses lines of 'value', 'timestamp,value' or 'series,timestamp,value'. A header line is skipped.
+// Timestamps are either Unix timestamps (in s, ms, µs or ns, guessed from their magnitude) or RFC3339 dates.
+func parseReplayCSV(r io.Reader) (map[string][]replayPoint, error) {
+	series := make(map[string][]replayPoint)
+
+	err := forEachLine(r, func(lineNum int, line string) error {
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		key := ""
+		var timestampField string
+		switch len(fields) {
+		case 1:
+		case 2:
+			timestampField = fields[0]
+		case 3:
+			key = fields[0]
+			timestampField = fields[1]
+		default:
+			return fmt.Errorf("expected 'value', 'timestamp,value' or 'series,timestamp,value', got %d fields", len(fields))
+		}
+
+		value, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+		if err != nil {
+			if lineNum == 1 {
+				return nil // Header
+			}
+			return fmt.Errorf("invalid value '%s'", fields[len(fields)-1])
+		}
+		var timestamp int64
+		if len(timestampField) > 0 {
+			timestamp, err = parseReplayTimestamp(timestampField)
+			if err != nil {
+				if lineNum == 1 {
+					return nil // Header
+				}
+				return err
+			}
+		}
+
+		series[key] = append(series[key], replayPoint{timestamp: timestamp, value: value})
+		return nil
+	})
+
+	return series, err
+}
+
+var whisperArchiveRE = regexp.MustCompile(`^Archive (\d+) data:`)
+var whisperPointRE = regexp.MustCompile(`^\d+:\s*(\d+),\s*(\S+)$`)
+
+// parseReplayWhisperDump parses the output of whisper-dump.py. Only the first (highest precision) archive is used and
+// its empty slots are skipped.
+func parseReplayWhisperDump(r io.Reader) (map[string][]replayPoint, error) {
+	var points []replayPoint
+	archive := -1
+
+	err := forEachLine(r, func(lineNum int, line string) error {
+		if m := whisperArchiveRE.FindStringSubmatch(line); m != nil {
+			archive, _ = strconv.Atoi(m[1])
+			return nil
+		}
+		if archive != 0 {
+			return nil
+		}
+		m := whisperPointRE.FindStringSubmatch(line)
+		if m == nil {
+			return nil
+		}
+		seconds, _ := strconv.ParseInt(m[1], 10, 64)
+		if seconds == 0 {
+			return nil // Empty slot
+		}
+		value, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			return fmt.Errorf("invalid value '%s'", m[2])
+		}
+		points = append(points, replayPoint{timestamp: seconds * int64(time.Second), value: value})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]replayPoint{"": points}, nil
+}
+
+// parseReplayInfluxLineProtocol parses InfluxDB line protocol, each numeric field being a series:
+// <measurement>[,<tag-key>=<tag-value>...] <field-key>=<field-value>[,...] [unix-nano-timestamp]
+// String and boolean fields are skipped.
+func parseReplayInfluxLineProtocol(r io.Reader) (map[string][]replayPoint, error) {
+	series := make(map[string][]replayPoint)
+
+	err := forEachLine(r, func(lineNum int, line string) error {
+		parts := strings.Fields(line)
+		if len(parts) < 2 || len(parts) > 3 {
+			return fmt.Errorf("expected '<measurement>[,<tags>] <fields> [timestamp]'")
+		}
+
+		var timestamp int64
+		if len(parts) == 3 {
+			ts, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid timestamp '%s'", parts[2])
+			}
+			timestamp = ts
+		}
+
+		for _, field := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid field '%s'", field)
+			}
+			value, err := strconv.ParseFloat(strings.TrimRight(kv[1], "iu"), 64)
+			if err != nil {
+				continue
+			}
+			key := parts[0] + " " + kv[0]
+			series[key] = append(series[key], replayPoint{timestamp: timestamp, value: value})
+		}
+		return nil
+	})
+
+	return series, err
+}
+
+// parseReplayPrometheusDump parses the output of 'promtool tsdb dump' ({<labels>} <value> <timestamp in ms>) and
+// 'promtool tsdb dump-openmetrics' (<name>{<labels>} <value> <timestamp in s>)
+func parseReplayPrometheusDump(r io.Reader) (map[string][]replayPoint, error) {
+	series := make(map[string][]replayPoint)
+
+	err := forEachLine(r, func(lineNum int, line string) error {
+		if strings.HasPrefix(line, "#") {
+			return nil // OpenMetrics metadata and EOF
+		}
+
+		// Label values may contain spaces, the value and timestamp are the last two fields
+		timestampStart := strings.LastIndexByte(line, ' ')
+		if timestampStart <= 0 {
+			return fmt.Errorf("expected '<series> <value> <timestamp>'")
+		}
+		valueStart := strings.LastIndexByte(line[:timestampStart], ' ')
+		if valueStart <= 0 {
+			return fmt.Errorf("expected '<series> <value> <timestamp>'")
+		}
+
+		value, err := strconv.ParseFloat(line[valueStart+1:timestampStart], 64)
+		if err != nil {
+			return fmt.Errorf("invalid value '%s'", line[valueStart+1:timestampStart])
+		}
+		timestampField := line[timestampStart+1:]
+		var timestamp int64
+		if strings.Contains(timestampField, ".") {
+			seconds, err := strconv.ParseFloat(timestampField, 64)
+			if err != nil {
+				return fmt.Errorf("invalid timestamp '%s'", timestampField)
+			}
+			timestamp = int64(seconds * float64(time.Second))
+		} else {
+			ms, err := strconv.ParseInt(timestampField, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid timestamp '%s'", timestampField)
+			}
+			timestamp = ms * int64(time.Millisecond)
+		}
+
+		key := strings.TrimSpace(line[:valueStart])
+		series[key] = append(series[key], replayPoint{timestamp: timestamp, value: value})
+		return nil
+	})
+
+	return series, err
+}
+
+// parseReplayTimestamp parses a Unix timestamp in s, ms, µs or ns, or an RFC3339 date, into nanoseconds
+func parseReplayTimestamp(s string) (int64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		switch {
+		case v < 1e11: // Seconds, until year 5138
+			return int64(v * float64(time.Second)), nil
+		case v < 1e14:
+			return int64(v * float64(time.Millisecond)), nil
+		case v < 1e17:
+			return int64(v * float64(time.Microsecond)), nil
+		default:
+			return int64(v), nil
+		}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp '%s', it must be a Unix timestamp or an RFC3339 date", s)
+	}
+	return t.UnixNano(), nil
+}
+
+// forEachLine calls fn for each non-empty line, prefixing its errors with the line number
+func forEachLine(r io.Reader, fn func(lineNum int, line string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(lineNum, line); err != nil {
+			return fmt.Errorf("line %d: %s", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// sortReplayPoints sorts the points of each series by timestamp, keeping the file order for equal timestamps
+func sortReplayPoints(series map[string][]replayPoint) {
+	for _, points := range series {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].timestamp < points[j].timestamp
+		})
+	}
+}
@@ -1,19 +1,74 @@
 package generator
 
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+	"unsafe"
+)
+
+// intValueCache caches the formatted values of a range of integers. It's shared by all the clones of a generator and
+// filled lazily by the workers, concurrently: entries are loaded and stored atomically. Two workers may format the same
+// value at the same time, the last one wins and both values are identical anyway.
+type intValueCache struct {
+	min     int
+	entries []unsafe.Pointer // *[]byte
+}
+
+// newIntValueCache returns a cache for the integers between min and max, or nil if there are more than maxSize of them
+func newIntValueCache(min int, max int, maxSize int) *intValueCache {
+	size := max - min + 1
+	if size > maxSize || size <= 0 {
+		return nil
+	}
+	return &intValueCache{min: min, entries: make([]unsafe.Pointer, size)}
+}
+
+// get returns the formatted value of n, formatting and caching it if needed. A nil cache formats n every time.
+func (c *intValueCache) get(n int) *[]byte {
+	if c == nil || n < c.min || n-c.min >= len(c.entries) {
+		return intToByteArrPtr(n)
+	}
+	entry := &c.entries[n-c.min]
+	if value := atomic.LoadPointer(entry); value != nil {
+		return (*[]byte)(value)
+	}
+	value := intToByteArrPtr(n)
+	atomic.StorePointer(entry, unsafe.Pointer(value))
+	return value
+}
+
+// Largest absolute value float64ToByteArrPtr formats digit by digit: with its 4 decimals, it must fit in an int64
+// (9e14 * 10000 = 9e18 < 2^63)
+const maxFastFormatFloat = 9e14
+
 func float64ToByteArrPtr(f float64) *[]byte {
 	// Convert the float to an ascii representation []byte array
 	// Instead of converting the float to a string and then the string to a byte array, we go through each digit and set the ascii value in the byte array
 	// We have a fixed precision of 4 digits on generated floats for now, which makes this easier
+	// NaN, infinities and values too large for the int64 below (|f| >= maxFastFormatFloat) go through strconv instead
+	if math.IsNaN(f) || math.Abs(f) >= maxFastFormatFloat {
+		b := strconv.AppendFloat(nil, f, 'f', 4, 64)
+		return &b
+	}
 
-	// Keep two local temp values, tempValueForDigitCount will be used to find how many digits there's to the left of the decimal point.
-	tempValueForDigitCount := int64(f)
 	// tempValue will be used to go through each digit
 	tempValue := int64(f * 10000)
+	if f < 0 {
+		tempValue = 0 - tempValue
+	}
+
+	return decimalsToByteArrPtr(tempValue, f < 0)
+}
+
+// decimalsToByteArrPtr converts a number of 1/10000, without sign, to an ascii representation with 4 decimals
+func decimalsToByteArrPtr(tempValue int64, negative bool) *[]byte {
+	// tempValueForDigitCount will be used to find how many digits there's to the left of the decimal point.
+	tempValueForDigitCount := tempValue / 10000
 
 	byteArrayLen := 5 // Start with a byte array len of 5 which is the decimal point + 4 digits: .0000
-	if f < 0 {        // If the value is negative, increase the byte array len by one to account for the minus sign we'll add
+	if negative {     // If the value is negative, increase the byte array len by one to account for the minus sign we'll add
 		byteArrayLen++
-		tempValue = 0 - tempValue
 	}
 	for dowhile := true; dowhile; dowhile = tempValueForDigitCount != 0 { // Do while makes sure we at least do this once to account for numbers where (-1 < n < 1)
 		tempValueForDigitCount /= 10
@@ -41,7 +96,7 @@ func float64ToByteArrPtr(f float64) *[]byte {
 	}
 
 	// Set the minus sign if appropriate
-	if f < 0 {
+	if negative {
 		byteArr[0] = 0x2D
 	}
 
@@ -0,0 +1,242 @@
+package generator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+)
+
+// The wave generator emits a periodic float value, to validate dashboards, alerting and downsampling visually:
+//  - sine: offset +/- amplitude, following a sine wave
+//  - square: offset + amplitude for the first half of the period and offset - amplitude for the second half
+//  - sawtooth: from offset - amplitude up to offset + amplitude over the period, then back down at once
+//  - trend: offset, without a wave, so that only the trend and the noise remain
+// The wave follows the wall clock rather than the ticks, so that changing the interval doesn't change its period. To
+// that wave are added a linear trend, in units per hour since the worker started, and a gaussian noise.
+//
+// Workers are shifted in the period so that they aren't in lockstep: spread shifts each worker by a hash of its seed,
+// which spreads them uniformly over the period and gives a worker the same phase for the same seed, whichever agent
+// or clone order. random draws the shift from the random source of the worker and none keeps them in phase.
+
+var waveShapes = map[string]func(position float64) float64{
+	"sine": func(position float64) float64 {
+		return math.Sin(2 * math.Pi * position)
+	},
+	"square": func(position float64) float64 {
+		if position < 0.5 {
+			return 1
+		}
+		return -1
+	},
+	"sawtooth": func(position float64) float64 {
+		return 2*position - 1
+	},
+	"trend": func(position float64) float64 {
+		return 0
+	},
+}
+
+type wave struct {
+	name       *[]byte
+	tags       *[]byte
+	phase      int64 // Shift of the worker in the period, in nanoseconds
+	start      time.Time
+	last       float64    // Value of the last tick, read by the derived generators
+	random     *rand.Rand // Each worker has its own random source, sources aren't thread-safe
+	clock      Clock      // nil for the wall clock
+	sharedData *waveSharedData
+}
+
+type waveSharedData struct {
+	metadata  *metric.MetricStaticMetadata
+	shape     string
+	offset    float64
+	amplitude float64
+	period    time.Duration
+	phase     string
+	trend     float64
+	noise     float64
+	encoding  *valueEncoding
+
+	formatter *formatter.Formatter
+}
+
+// NewWaveGenerator returns a struct compliant with the Generator interface
+// You want to call this method once per config and then clone the generator using Clone() so that metadata is shared for all workers
+func NewWaveGenerator(config CLIConfig, tags *[]byte, f *formatter.Formatter) (Generator, error) {
+	confName := "wave"
+	confShape := "sine"
+	confOffset := 50.0
+	confAmplitude := 50.0
+	confPeriod := time.Hour
+	confPhase := "spread"
+	confTrend := 0.0
+	confNoise := 0.0
+
+	for _, arg := range config.Args {
+		kv := strings.SplitN(arg, ":", 2)
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "name":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("Error parsing wave name '%s'", value)
+			}
+			confName = value
+		case "shape":
+			if _, ok := waveShapes[value]; !ok {
+				return nil, fmt.Errorf("Invalid wave shape '%s', must be one of 'sine', 'square', 'sawtooth' or 'trend'", value)
+			}
+			confShape = value
+		case "offset":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing wave offset '%s'", value)
+			}
+			confOffset = v
+		case "amplitude":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing wave amplitude '%s', it must be a >= 0 number", value)
+			}
+			confAmplitude = v
+		case "period":
+			v, err := time.ParseDuration(value)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("Error parsing wave period '%s', it must be a > 0 Go Duration", value)
+			}
+			confPeriod = v
+		case "phase":
+			if value != "spread" && value != "random" && value != "none" {
+				return nil, fmt.Errorf("Invalid wave phase '%s', must be one of 'spread', 'random' or 'none'", value)
+			}
+			confPhase = value
+		case "trend":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing wave trend '%s'", value)
+			}
+			confTrend = v
+		case "noise":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("Error parsing wave noise '%s', it must be a >= 0 number", value)
+			}
+			confNoise = v
+		}
+	}
+
+	encoding, err := parseValueEncoding("wave", config)
+	if err != nil {
+		return nil, err
+	}
+
+	metricName := []byte(confName)
+	metricType := []byte("gauge")
+
+	staticMeta := &metric.MetricStaticMetadata{
+		Name:       &metricName,
+		Tags:       tags,
+		MetricType: &metricType,
+	}
+
+	sharedData := &waveSharedData{
+		metadata:  staticMeta,
+		shape:     confShape,
+		offset:    confOffset,
+		amplitude: confAmplitude,
+		period:    confPeriod,
+		phase:     confPhase,
+		trend:     confTrend,
+		noise:     confNoise,
+		encoding:  encoding,
+		formatter: f,
+	}
+
+	g := &wave{sharedData: sharedData, start: time.Now()}
+	g.Seed(rand.Int63())
+	return g, nil
+}
+
+// Clone the current generator into a new struct with its own phase and start time, and the same pointer for sharedData
+func (g wave) Clone(newName string, specificTags *[]byte) Generator {
+	s := g.sharedData
+	newg := wave{sharedData: s, start: time.Now()}
+	newNameBytes := []byte(newName)
+	newg.name = &newNameBytes
+	newg.tags = specificTags
+	newg.Seed(rand.Int63())
+	return &newg
+}
+
+// Seed replaces the random source of the generator and, unless phase: none, the phase of the worker
+func (g *wave) Seed(seed int64) {
+	g.random = newRandom(seed)
+	switch g.sharedData.phase {
+	case "spread":
+		g.phase = int64(splitMix64(uint64(seed)) % uint64(g.sharedData.period))
+	case "random":
+		g.phase = g.random.Int63n(int64(g.sharedData.period))
+	}
+}
+
+// SetClock replaces the clock the wave follows, the trend starts from its time
+func (g *wave) SetClock(clock Clock) {
+	g.clock = clock
+	g.start = clockNow(clock)
+}
+
+// Return the name of the generator (as specificed on the command-line)
+func (g *wave) GetName() string {
+	if g.name != nil {
+		return string(*g.name)
+	}
+	return string(*g.sharedData.metadata.Name)
+}
+
+// Return a human-readable description of the generator
+func (g *wave) ToString() string {
+	s := g.sharedData
+	description := fmt.Sprintf("Wave generator (%s) of shape %s", *s.metadata.Name, s.shape)
+	if s.shape == "trend" {
+		description += fmt.Sprintf(" from %.4f", s.offset)
+	} else {
+		description += fmt.Sprintf(" %.4f +/- %.4f over %s with phase %s", s.offset, s.amplitude, s.period, s.phase)
+	}
+	return description + fmt.Sprintf(", a trend of %.4f per hour and a noise of %.4f", s.trend, s.noise) + s.encoding.String()
+}
+
+// value returns the value of the worker at now, without the noise
+func (g *wave) value(now time.Time) float64 {
+	s := g.sharedData
+	position := float64((now.UnixNano()+g.phase)%int64(s.period)) / float64(s.period)
+	return s.offset + s.amplitude*waveShapes[s.shape](position) + s.trend*now.Sub(g.start).Hours()
+}
+
+func (g *wave) lastValue() (float64, bool) {
+	return g.last, true
+}
+
+// Generates a metric struct with a value computed from the generator's rules
+func (g *wave) GenerateMetric() *metric.Metric {
+	now := clockNow(g.clock)
+	timestamp := now.UnixNano()
+	g.last = g.value(now) + g.random.NormFloat64()*g.sharedData.noise
+	value, stale := g.sharedData.encoding.encode(g.last, g.random)
+
+	return &metric.Metric{
+		Metadata:  g.sharedData.metadata,
+		Name:      g.name,
+		Value:     value,
+		Tags:      g.tags,
+		Timestamp: &timestamp,
+		Stale:     stale,
+	}
+}
@@ -5,15 +5,18 @@ import (
 	"flag"
 	"fmt"
 	"math"
+	"math/rand"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/aleveille/lagrande/formatter"
 	"github.com/aleveille/lagrande/generator"
 	"github.com/aleveille/lagrande/metric"
 	"github.com/aleveille/lagrande/publisher"
@@ -21,11 +24,6 @@ import (
 
 // TODO:
 //   Support prometheus format (pushing to pushgateway)
-//   Support histogram/summary (Prometheus)
-//   New generator: CPU-like
-//   New generator: Memory-like
-//   Control channel(s)
-//   Potentially: Support destroying && creating workers over time (to simulate hosts going down, coming up)
 
 var (
 	version   string
@@ -37,6 +35,8 @@ var (
 	format                string
 	protocol              string
 	profile               string
+	configFile            string
+	stages                string
 	logLevel              string
 	dryRun                bool
 	interval              string
@@ -45,17 +45,41 @@ var (
 	metricNamespaceSuffix string
 	tags                  string
 	versionFlag           bool
+	mode                  string
+	listenAddress         string
+	coordinatorAddress    string
+	agentsCount           int
+	controlListen         string
+	targetsFlags          targetsFlag
+	targetsMode           string
+	churnPercent          float64
+	churnInterval         string
 	workersCount          int
 	workersInterval       string
+	seed                  int64
+	lateSkew              string
+	futureSkew            string
+	backfillStart         string
+	backfillEnd           string
+	backfillStep          string
+	timestampsMode        string
+	clockOffset           string
+	restartEvery          string
 
 	// Variables computed from CLI flags
 	generatorsArr           []generator.Generator
 	intervalDuration        time.Duration
 	workersIntervalDuration time.Duration
+	loadStages              []loadStage
+	holdLastStage           bool
+	churnIntervalDuration   time.Duration
+	pointsDisorder          disorder
+	clockOffsetDuration     time.Duration
+	workersRestarts         restarts
 	sharedTags              string
 	workersTags             string
 
-	localFormatter        formatter.Formatter
+	targets               []*target
 	stringPid             string
 	statsPrintToPushRatio = int(math.Round(float64(statsPrintInterval.Seconds()) / float64(statsPushInterval.Seconds())))
 )
@@ -63,13 +87,18 @@ var (
 const (
 	statsPushInterval  = 500 * time.Millisecond
 	statsPrintInterval = 30 * time.Second
+	// The stats channel is sized for the workers of the load stages, but the control API can run more of them. When
+	// it's full, stats are only discarded if the stats handler doesn't catch up within this delay.
+	statsPushTimeout = statsPushInterval
 )
 
 type emissionStat struct {
 	workerNum          int
+	target             int
 	successfullySent   int64
 	unsuccessfullySent int64
 	duration           time.Duration
+	disorder           disorderCounts
 }
 
 func init() {
@@ -83,9 +112,13 @@ func init() {
 
 	stringPid = strconv.Itoa(os.Getpid())
 
+	flag.StringVar(&configFile, "config", "", "YAML or JSON file describing the run. Flags set on the command line override the values of the file")
 	flag.StringVar(&endpoint, "endpoint", "", "Endpoint to publish metrics to")
-	flag.StringVar(&format, "format", "carbon", "Publish format: \"atlas\",\"carbon\", \"influxdb\", \"m3db\" or \"timescale\"")
+	flag.StringVar(&format, "format", "carbon", "Publish format: \"atlas\",\"carbon\", \"influxdb\", \"m3db\", \"otlp\" or \"timescale\"")
 	flag.StringVar(&protocol, "protocol", "auto", "Publish protocol: \"auto\", \"http\", \"tcp\" or \"udp\". NB: not all format support all protocol!")
+	flag.Var(&targetsFlags, "target", "Target to publish metrics to, of format format=<format>,protocol=<protocol>,endpoint=<endpoint>,weight=<weight>. Can be repeated to publish to several targets, overrides -format, -protocol and -endpoint")
+	flag.StringVar(&targetsMode, "targetsMode", "mirror", "How metrics are published when there are several targets: \"mirror\" sends the same data to all targets, \"split\" splits the workers across targets by weight")
+	flag.StringVar(&stages, "stages", "", "Comma-delimited list of load stages of format <kind>:<duration>[:<workers>[:<interval>]] where kind is \"ramp\", \"step\" or \"hold\". Overrides -workers and -workersInterval")
 	flag.StringVar(&profile, "profile", "counterInt={name: fixedValue, value: 10, increment: 0},randomInt={name: jiggle, min: 50, max: 75}", "")
 	flag.StringVar(&logLevel, "logLevel", "info", "Log level: \"trace\", \"debug\", \"info\", \"warn\", \"error\", \"fatal\", \"panic\"")
 	flag.BoolVar(&dryRun, "dry-run", false, "Don't send any metrics")
@@ -95,7 +128,29 @@ func init() {
 	flag.StringVar(&tags, "tags", "", "Comma-delimited list of tags of format name=value. Support placeholders: NODENAME, PID, WORKERNUM, WORKERFULLNAME, METRICNAME") // If defaulting to 'node=NODENAME,process=lagrande,thread=WORKERFULLNAME', make sure it plays nice with TSDB that don't support tags
 	flag.BoolVar(&versionFlag, "version", false, "Print version information")
 	flag.IntVar(&workersCount, "workers", 10, "Number of parallel workers that will send metrics")
+	flag.StringVar(&mode, "mode", "standalone", "Run mode: \"standalone\", \"coordinator\" (runs the load stages and spreads the workers across agents) or \"agent\" (runs the workers a coordinator asks for)")
+	flag.StringVar(&listenAddress, "listen", ":7070", "Coordinator mode: address to listen on for agents")
+	flag.StringVar(&coordinatorAddress, "coordinator", "127.0.0.1:7070", "Agent mode: address of the coordinator")
+	flag.IntVar(&agentsCount, "agents", 1, "Coordinator mode: number of agents to wait for before starting")
+	flag.StringVar(&controlListen, "controlListen", "", "Address (or unix:<path> socket) to serve the control API on, to change the workers, send interval, target rate or pause the run while it's running. Disabled if empty")
 	flag.StringVar(&workersInterval, "workersInterval", "1s", "Wait time between starting workers, must be a >= 0 Go Duration")
+	flag.Float64Var(&churnPercent, "churnPercent", 10, "Percentage of workers to replace with new workers (new WORKERNUM and WORKERFULLNAME) every churnInterval")
+	flag.StringVar(&churnInterval, "churnInterval", "0s", "How often workers are churned, must be a >= 0 Go Duration. 0 disables churn")
+	flag.Float64Var(&pointsDisorder.latePercent, "latePercent", 0, "Percentage of points sent with a timestamp in the past, up to lateSkew, to test the out-of-order window of the TSDB")
+	flag.StringVar(&lateSkew, "lateSkew", "1m", "Maximum skew of the late points, must be a > 0 Go Duration")
+	flag.Float64Var(&pointsDisorder.futurePercent, "futurePercent", 0, "Percentage of points sent with a timestamp in the future, up to futureSkew")
+	flag.StringVar(&futureSkew, "futureSkew", "24h", "Maximum skew of the future points, must be a > 0 Go Duration")
+	flag.Float64Var(&pointsDisorder.duplicatePercent, "duplicatePercent", 0, "Percentage of points sent twice, with the same timestamp and value")
+	flag.Float64Var(&pointsDisorder.conflictPercent, "conflictPercent", 0, "Percentage of points sent a second time with the same timestamp and a conflicting value")
+	flag.StringVar(&backfillStart, "backfillStart", "", "Backfill mode: generate the history from this RFC 3339 time or Go Duration before now (eg: 168h) as fast as the targets accept, instead of running the load stages. Disabled if empty")
+	flag.StringVar(&backfillEnd, "backfillEnd", "", "Backfill mode: RFC 3339 time or Go Duration before now the history ends at. Defaults to now")
+	flag.StringVar(&backfillStep, "backfillStep", "", "Backfill mode: time between two points of a series, must be a > 0 Go Duration. Defaults to the interval")
+	flag.StringVar(&timestampsMode, "timestamps", "generation", "How points are timestamped: \"generation\" (when each generator generates them), \"batch\" (one timestamp per worker tick) or \"aligned\" (one timestamp per worker tick, truncated to a multiple of the interval)")
+	flag.StringVar(&clockOffset, "clockOffset", "0s", "Shift every timestamp by this Go Duration, can be negative, eg: to simulate hosts whose clock is skewed")
+	flag.Float64Var(&workersRestarts.probability, "restartProbability", 0, "Probability for each worker, at each tick, to restart like a process: its counters go back to their initial value, all together")
+	flag.StringVar(&restartEvery, "restartEvery", "0s", "How often each worker restarts like a process, shifted randomly for each worker, must be a >= 0 Go Duration. 0 disables scheduled restarts")
+	flag.StringVar(&workersRestarts.tag, "restartTag", "", "Tag key whose value changes every time a worker restarts, like an instance or pod tag. Disabled if empty")
+	flag.Int64Var(&seed, "seed", 0, "Seed of the random generators, combined with the worker number and the generator index so that two runs with the same seed generate the same data. 0 picks a random seed, printed at startup")
 }
 
 func main() {
@@ -106,6 +161,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	if len(configFile) > 0 {
+		err := loadConfigFile(configFile)
+		if err != nil {
+			log.Fatal(err)
+			os.Exit(1)
+		}
+	}
+
 	switch logLevel {
 	case "trace":
 		log.SetLevel(log.TraceLevel)
@@ -123,6 +186,36 @@ func main() {
 		log.SetLevel(log.PanicLevel)
 	}
 
+	if mode != "standalone" && mode != "coordinator" && mode != "agent" {
+		log.Fatal("The specified mode is invalid, it must be \"standalone\", \"coordinator\" or \"agent\"")
+		os.Exit(1)
+	}
+	if mode == "coordinator" && agentsCount < 1 {
+		log.Fatal("Invalid number of agents specified, it must be a > 0 integer")
+		os.Exit(1)
+	}
+
+	if mode == "agent" && len(controlListen) > 0 {
+		log.Fatal("The control API isn't available in agent mode, use it on the coordinator")
+		os.Exit(1)
+	}
+
+	var assignment *agentAssignment
+	if mode == "agent" {
+		var err error
+		assignment, err = registerAgent(coordinatorAddress)
+		if err != nil {
+			log.Fatal(err)
+			os.Exit(1)
+		}
+		// The NODENAME given by the coordinator is unique across agents, even if several agents run on the same host
+		hostname = assignment.NodeName
+		// Unless given its own, the agent uses the seed of the coordinator so that the whole run can be replayed
+		if seed == 0 {
+			seed = assignment.Seed
+		}
+	}
+
 	err := processCliConfiguration()
 	if err != nil {
 		log.Fatal(err)
@@ -132,45 +225,134 @@ func main() {
 	printConfig()
 	// At this point we're done with parsing & validating the CLI configuration. Congrats!
 
+	if mode == "agent" {
+		err = runAgent(coordinatorAddress, assignment)
+		if err != nil {
+			log.Fatal(err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Stats channel
-	statsChan := make(chan emissionStat, workersCount*(statsPrintToPushRatio+1))
-	go handleStats(statsChan)
+	statsChan := make(chan emissionStat, maxStagesWorkers(loadStages)*len(targets)*(statsPrintToPushRatio+1))
+	stageChan := make(chan stageEvent)
+	statsDone := make(chan bool)
+	go handleStats(statsChan, stageChan, statsDone)
+
+	var pool workersScaler
+	if mode == "coordinator" {
+		pool, err = startCoordinator(listenAddress, agentsCount, intervalDuration, statsChan)
+		if err != nil {
+			log.Fatal(err)
+			os.Exit(1)
+		}
+	} else {
+		pool = newWorkerPool(intervalDuration, statsChan)
+	}
 
-	workersSpawnTicker := time.Tick(workersIntervalDuration)
-	workersSpawnCount := 0
-	//controlChan := make(chan bool, workersCount*(StatsPrintToPushRatio+1))
+	var controlChan <-chan controlRequest
+	if len(controlListen) > 0 {
+		controlChan, err = startControlServer(controlListen)
+		if err != nil {
+			log.Fatal(err)
+			os.Exit(1)
+		}
+	}
 
-	for {
+	if backfillRun != nil {
+		backfillRun.run(pool, stageChan)
+	} else {
+		runStages(pool, stageChan, controlChan)
+	}
+	close(stageChan)
+	<-statsDone
+}
+
+// runStages runs the load stages (and churns workers if enabled) until the stages are over or lagrande is interrupted.
+// Requests received on controlChan (nil when the control API is disabled) take precedence over the load stages.
+func runStages(pool workersScaler, stageChan chan<- stageEvent, controlChan <-chan controlRequest) {
+	plan := &stagePlan{stages: loadStages, holdLastStage: holdLastStage}
+	control := newControlState(intervalDuration)
+	startStage(plan, pool, control, time.Now(), stageChan)
+
+	interruptChan := make(chan os.Signal, 1)
+	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+
+	stagesTicker := time.NewTicker(stagesTickInterval)
+	defer stagesTicker.Stop()
+	var churnTicker <-chan time.Time
+	workersChurner := &churner{percent: churnPercent}
+	if churnIntervalDuration > 0 {
+		churnTicker = time.Tick(churnIntervalDuration)
+	}
+
+	for plan.current < len(plan.stages) {
 		select {
-		// Need a control channel here
-		case <-workersSpawnTicker:
-			if workersSpawnCount < workersCount {
-				// TODO: give a control channel for the workers to stop working
-				go spawnWorker(workersSpawnCount, statsChan)
-				log.Infof("Launched worker-%s-%d", stringPid, workersSpawnCount)
-				workersSpawnCount++
+		case req := <-controlChan:
+			req.reply <- control.handle(req, plan, pool, time.Now())
+		case now := <-stagesTicker.C:
+			if plan.stageOver(now) {
+				pool.scaleTo(control.desiredWorkers(plan, now))
+				log.Infof("End of %s with %d workers", plan.stageName(), pool.count())
+				plan.current++
+				if plan.current < len(plan.stages) {
+					pool.syncStats()
+					startStage(plan, pool, control, now, stageChan)
+				}
 			} else {
-				log.Info("All workers launched")
-				workersSpawnTicker = nil
+				pool.scaleTo(control.desiredWorkers(plan, now))
+				control.applyInterval(pool)
 			}
+		case <-churnTicker:
+			workersChurner.churn(pool)
+		case sig := <-interruptChan:
+			log.Infof("Received %s, stopping workers", sig)
+			signal.Stop(interruptChan)
+			stopStages(plan, pool, stageChan)
+			return
 		}
 	}
 
-	// TODO check controlChan, set workersSpawnTicker to nil if we stop
+	log.Info("All load stages completed, stopping workers")
+	stopStages(plan, pool, stageChan)
+}
+
+func stopStages(plan *stagePlan, pool workersScaler, stageChan chan<- stageEvent) {
+	finalWorkers := pool.count()
+	pool.stopAll()
+	stageChan <- stageEvent{name: plan.stageName(), previousWorkers: finalWorkers}
+}
+
+func startStage(plan *stagePlan, pool workersScaler, control *controlState, now time.Time, stageChan chan<- stageEvent) {
+	stageChan <- stageEvent{name: plan.stageName(), previousWorkers: pool.count()}
+	newInterval := plan.start(now, pool.count())
+	if newInterval > 0 {
+		control.interval = newInterval
+	}
+	log.Infof("Starting %s: %s", plan.stageName(), plan.stages[plan.current])
+	pool.scaleTo(control.desiredWorkers(plan, now))
+	control.applyInterval(pool)
+}
+
+func maxStagesWorkers(stages []loadStage) int {
+	max := 1
+	for _, stage := range stages {
+		if stage.workers > max {
+			max = stage.workers
+		}
+	}
+	return max
 }
 
 func processCliConfiguration() error {
 	var err error
 
-	err = processFormatAndProtocol()
+	err = processTargets()
 	if err != nil {
 		return err
 	}
 
-	if dryRun {
-		protocol = "dry-run"
-	}
-
 	intervalDuration, err = time.ParseDuration(interval)
 	if err != nil || intervalDuration.Nanoseconds() <= int64(0) {
 		return errors.New("Invalid interval specified. Make sure it's a duration greater than 0 and parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
@@ -181,6 +363,57 @@ func processCliConfiguration() error {
 		return errors.New("Invalid workersInterval specified. Make sure it's a duration greater or equal than 0 and parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
 	}
 
+	churnIntervalDuration, err = time.ParseDuration(churnInterval)
+	if err != nil || churnIntervalDuration.Nanoseconds() < int64(0) {
+		return errors.New("Invalid churnInterval specified. Make sure it's a duration greater or equal than 0 and parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
+	}
+	if churnPercent < 0 || churnPercent > 100 {
+		return errors.New("Invalid churnPercent specified. Make sure it's a percentage between 0 and 100")
+	}
+
+	pointsDisorder.lateSkew, err = time.ParseDuration(lateSkew)
+	if err != nil {
+		return errors.New("Invalid lateSkew specified. Make sure it's a duration parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
+	}
+	pointsDisorder.futureSkew, err = time.ParseDuration(futureSkew)
+	if err != nil {
+		return errors.New("Invalid futureSkew specified. Make sure it's a duration parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
+	}
+	if err = pointsDisorder.validate(); err != nil {
+		return err
+	}
+
+	workersRestarts.every, err = time.ParseDuration(restartEvery)
+	if err != nil {
+		return errors.New("Invalid restartEvery specified. Make sure it's a duration parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
+	}
+	if err = processTimestampsConfiguration(); err != nil {
+		return err
+	}
+
+	if len(backfillStart) > 0 {
+		if err = processBackfillConfiguration(); err != nil {
+			return err
+		}
+	}
+
+	if len(stages) > 0 {
+		loadStages, err = parseStages(stages)
+		if err != nil {
+			return err
+		}
+	} else if len(loadStages) == 0 {
+		loadStages = legacyStages(workersCount, workersIntervalDuration)
+		holdLastStage = true
+	}
+
+	// The generators draw their per-worker seeds from the seed, the global source is used for what isn't per worker
+	// (eg: which workers are churned)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rand.Seed(seed)
+
 	err = processTags()
 	if err != nil {
 		return err
@@ -197,75 +430,45 @@ func processCliConfiguration() error {
 		return err
 	}
 
+	// Once the tags and the generators are known, so that the restart tag doesn't clash with their keys
+	if err = workersRestarts.validate(configuredTagKeys()); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func processFormatAndProtocol() error {
-	if protocol != "auto" && protocol != "tcp" && protocol != "udp" && protocol != "http" && protocol != "null" && protocol != "log" {
-		return errors.New("The specified protocol is invalid")
+func processTargets() error {
+	if len(targetsFlags) > 0 {
+		targets = nil
+		for _, tf := range targetsFlags {
+			t, err := parseTarget(tf)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, t)
+		}
+	} else if len(targets) == 0 {
+		targets = []*target{{format: format, protocol: protocol, endpoint: endpoint, weight: 1}}
 	}
 
-	if protocol == "null" || protocol == "log" {
-		// Todo: validate format anyway
-		return nil
+	if targetsMode != "mirror" && targetsMode != "split" {
+		return errors.New("The specified targetsMode is invalid, it must be \"mirror\" or \"split\"")
 	}
 
-	// Validate format --> Protocol combinations and initialize formatters accordingly
-	switch format {
-	case "atlas":
-		if protocol != "http" && protocol != "auto" {
-			return errors.New("Only the HTTP protocol is supported with Atlas")
-		}
-
-		localFormatter = formatter.NewAtlasFormatter()
-
-		if len(endpoint) == 0 {
-			endpoint = "http://127.0.0.1:7101/api/v1/publish"
-		}
-		if protocol == "auto" {
-			protocol = "http"
-		}
-	case "carbon":
-		if protocol == "http" {
-			return errors.New("The HTTP protocol isn't supported with Carbon")
-		}
-
-		localFormatter = formatter.NewCarbonFormatter()
-
-		if len(endpoint) == 0 {
-			endpoint = "127.0.0.1:2003"
-		}
-		if protocol == "auto" {
-			protocol = "tcp"
-		}
-	case "influxdb":
-		if protocol != "http" && protocol != "auto" {
-			return errors.New("Only the HTTP protocol is supported with InfluxDB")
-		}
-
-		localFormatter = formatter.NewInfluxdbFormatter()
-
-		if len(endpoint) == 0 {
-			endpoint = "http://127.0.0.1:8086/write?db=mydb"
-		}
-		if protocol == "auto" {
-			protocol = "http"
-		}
-	case "m3db":
-		if protocol != "http" && protocol != "auto" {
-			return errors.New("Only the HTTP protocol is supported with M3DB")
-		}
-
-		localFormatter = formatter.NewM3DBFormatter()
-
-		if len(endpoint) == 0 {
-			endpoint = "http://localhost:9003/writetagged"
+	totalWeight := 0
+	for i, t := range targets {
+		err := t.processFormatAndProtocol()
+		if err != nil {
+			return fmt.Errorf("Error with target #%d (%s): %s", i+1, t.format, err)
 		}
-		if protocol == "auto" {
-			protocol = "http"
+		if dryRun {
+			t.protocol = "dry-run"
 		}
-	default:
-		return errors.New("The specified format is invalid")
+		totalWeight += t.weight
+	}
+	if targetsMode == "split" && totalWeight == 0 {
+		return errors.New("At least one target must have a weight greater than 0 to split workers across targets")
 	}
 
 	return nil
@@ -308,44 +511,127 @@ func appendTag(tagList *string, tag *string) {
 }
 
 func processGenerators() error {
+	specs := configGenerators
+	if len(specs) == 0 {
+		var err error
+		specs, err = parseProfile(profile)
+		if err != nil {
+			return err
+		}
+	}
+
+	generatorsArr = make([]generator.Generator, len(specs), len(specs))
+
+	for i, spec := range specs {
+		var err error
+		generatorsArr[i], err = newGenerator(spec)
+		if err != nil {
+			if len(configGenerators) > 0 {
+				return fmt.Errorf("generators[%d] (%s): %s", i, spec.kind, err)
+			}
+			return fmt.Errorf("Error while instanciating generator #%d (%s): %s", i+1, spec.kind, err)
+		}
+	}
+
+	return generator.CheckReferences(generatorsArr, generatorNames())
+}
+
+// configuredTagKeys returns the keys of the tags given by -tags and by the dimensions of the generators
+func configuredTagKeys() []string {
+	var keys []string
+	for _, tagList := range []string{sharedTags, workersTags} {
+		if len(tagList) == 0 {
+			continue
+		}
+		for _, tag := range strings.Split(tagList, ",") {
+			keys = append(keys, strings.SplitN(tag, "=", 2)[0])
+		}
+	}
+	for _, gen := range generatorsArr {
+		keys = append(keys, generator.TagKeys(gen)...)
+	}
+	return keys
+}
+
+// generatorNames returns the names of the generators, as specified in their config
+func generatorNames() []string {
+	names := make([]string, len(generatorsArr))
+	for i, gen := range generatorsArr {
+		names[i] = gen.GetName()
+	}
+	return names
+}
+
+func parseProfile(profile string) ([]generatorSpec, error) {
 	generatorsRE := regexp.MustCompile(`[a-zA-Z]*={[^}]*}(,[a-zA-Z]*={[^}]*})*`)
 	generatorTokenizerRE := regexp.MustCompile(`[a-zA-Z]*={[^}]*}`)
 	argumentsRE := regexp.MustCompile(`[a-zA-Z]*:\s?[^,}]*`)
 
 	matched := generatorsRE.MatchString(profile)
 	if matched == false {
-		return errors.New("Error while validating profile string. Please refer to the doc and examples")
+		return nil, errors.New("Error while validating profile string. Please refer to the doc and examples")
 	}
 
 	cliGenerators := generatorTokenizerRE.FindAllString(profile, -1)
-	generatorsArr = make([]generator.Generator, len(cliGenerators), len(cliGenerators))
-
+	specs := make([]generatorSpec, len(cliGenerators))
 	for i, gen := range cliGenerators {
-		var err error
-		generatorName := strings.Split(gen, "=")[0]
-		cliArguments := argumentsRE.FindAllString(gen, -1)
-
-		switch generatorName {
-		case "counterInt":
-			generatorsArr[i], err = generator.NewIntCounterGenerator(generator.CLIConfig{Args: cliArguments}, localFormatter.FormatTags(&sharedTags), &localFormatter)
-		case "counterFloat":
-			generatorsArr[i], err = generator.NewFloatCounterGenerator(generator.CLIConfig{Args: cliArguments}, localFormatter.FormatTags(&sharedTags), &localFormatter)
-		case "latency":
-			generatorsArr[i], err = generator.NewLatencyDistributionGenerator(generator.CLIConfig{Args: cliArguments}, localFormatter.FormatTags(&sharedTags), &localFormatter)
-		case "randomInt":
-			generatorsArr[i], err = generator.NewIntRandomGenerator(generator.CLIConfig{Args: cliArguments}, localFormatter.FormatTags(&sharedTags), &localFormatter)
-		case "randomFloat":
-			generatorsArr[i], err = generator.NewFloatRandomGenerator(generator.CLIConfig{Args: cliArguments}, localFormatter.FormatTags(&sharedTags), &localFormatter)
-		default:
-			return errors.New("Invalid generatorName in the profile string, please refer to the doc")
-		}
+		specs[i] = generatorSpec{kind: strings.Split(gen, "=")[0], args: argumentsRE.FindAllString(gen, -1)}
+	}
 
-		if err != nil {
-			log.Errorf("Error while instanciating %s generator:\n%s", generatorName, err)
-		}
+	return specs, nil
+}
+
+// newGenerator instanciates a generator from its spec. Generators carry raw 'key=value,...' tags which are formatted for
+// each target by the workers.
+func newGenerator(spec generatorSpec) (generator.Generator, error) {
+	config := generator.CLIConfig{Args: spec.args}
+	gen, err := newKindGenerator(spec.kind, config)
+	if err != nil {
+		return nil, err
+	}
+	// Wrapped before the dimensions so that each combination skips its ticks on its own
+	gen, err = generator.WithEmission(gen, config)
+	if err != nil {
+		return nil, err
 	}
+	return generator.WithDimensions(gen, config)
+}
 
-	return nil
+func newKindGenerator(kind string, config generator.CLIConfig) (generator.Generator, error) {
+	rawSharedTags := []byte(sharedTags)
+
+	switch kind {
+	case "counterInt":
+		return generator.NewIntCounterGenerator(config, &rawSharedTags, nil)
+	case "counterFloat":
+		return generator.NewFloatCounterGenerator(config, &rawSharedTags, nil)
+	case "latency":
+		return generator.NewLatencyDistributionGenerator(config, &rawSharedTags, nil)
+	case "randomInt":
+		return generator.NewIntRandomGenerator(config, &rawSharedTags, nil)
+	case "randomFloat":
+		return generator.NewFloatRandomGenerator(config, &rawSharedTags, nil)
+	case "cpu":
+		return generator.NewCPUGenerator(config, &rawSharedTags, nil)
+	case "memory":
+		return generator.NewMemoryGenerator(config, &rawSharedTags, nil)
+	case "histogram":
+		return generator.NewHistogramGenerator(config, &rawSharedTags, nil)
+	case "summary":
+		return generator.NewSummaryGenerator(config, &rawSharedTags, nil)
+	case "replay":
+		return generator.NewReplayGenerator(config, &rawSharedTags, nil)
+	case "randomWalk":
+		return generator.NewRandomWalkGenerator(config, &rawSharedTags, nil)
+	case "wave":
+		return generator.NewWaveGenerator(config, &rawSharedTags, nil)
+	case "http":
+		return generator.NewHTTPServiceGenerator(config, &rawSharedTags, nil)
+	case "derived":
+		return generator.NewDerivedGenerator(config, &rawSharedTags, nil)
+	default:
+		return nil, errors.New("Invalid generator type, please refer to the doc")
+	}
 }
 
 func printConfig() {
@@ -355,175 +641,371 @@ func printConfig() {
 		log.Info("\tDRY-RUN: no metrics will actually be sent")
 	}
 	log.Info("Configuration:")
+	if mode == "coordinator" {
+		log.Infof("\tCoordinator of %d agents listening on %s", agentsCount, listenAddress)
+	} else if mode == "agent" {
+		log.Infof("\tAgent of coordinator %s. The workers count, send interval and churn are driven by the coordinator", coordinatorAddress)
+	}
+	if len(controlListen) > 0 {
+		log.Infof("\tControl API: %s", controlListen)
+	}
+	log.Infof("\tSeed: %d (use -seed %d to generate the same data again)", seed, seed)
 	if !dryRun {
-		log.Infof("\tEndpoint:")
-		log.Infof("\t\tAddress: %s", endpoint)
-		log.Infof("\t\tProtocol: %s", protocol)
-		log.Infof("\t\tFormat: %s", format)
+		if len(targets) > 1 {
+			log.Infof("\tTargets (%s):", targetsMode)
+		}
+		for _, t := range targets {
+			log.Infof("\tEndpoint:")
+			log.Infof("\t\tAddress: %s", t.endpoint)
+			log.Infof("\t\tProtocol: %s", t.protocol)
+			log.Infof("\t\tFormat: %s", t.format)
+			if len(targets) > 1 && targetsMode == "split" {
+				log.Infof("\t\tWeight: %d", t.weight)
+			}
+		}
 	}
 	log.Infof("\tWorkers")
-	log.Infof("\t\tCount: %d", workersCount)
-	log.Infof("\t\tStart interval: %s", workersInterval)
-	if !dryRun {
+	if backfillRun != nil {
+		log.Infof("\t\tCount: %d", workersCount)
+		log.Infof("\t\tBackfill: %s, %d points per series", backfillRun, backfillRun.ticks())
+	} else if !holdLastStage {
+		log.Infof("\t\tLoad stages:")
+		for i, stage := range loadStages {
+			log.Infof("\t\t\t%d. %s", i+1, stage)
+		}
+	} else {
+		log.Infof("\t\tCount: %d", workersCount)
+		log.Infof("\t\tStart interval: %s", workersInterval)
+	}
+	if !dryRun && backfillRun == nil {
 		log.Infof("\t\tSend interval: %s", interval)
 	}
-	log.Infof("\tEach worker will generate %d time series:", len(generatorsArr))
+	if churnIntervalDuration > 0 {
+		log.Infof("\t\tChurn: %.2f%% of workers replaced every %s", churnPercent, churnIntervalDuration)
+		if !strings.Contains(metricNamespacePrefix+metricNamespaceSuffix+tags, "WORKER") {
+			log.Warn("\t\tNeither the metric namespace nor the tags use WORKERNUM or WORKERFULLNAME, churned workers will not generate new series")
+		}
+	}
+	if timestampsMode != "generation" || clockOffsetDuration != 0 {
+		log.Infof("\tTimestamps: %s, shifted by %s", timestampsMode, clockOffsetDuration)
+	}
+	if pointsDisorder.enabled() {
+		log.Infof("\tDisorder: %s", pointsDisorder.String())
+	}
+	if workersRestarts.enabled() {
+		log.Infof("\tRestarts: %s", workersRestarts.String())
+	}
+	log.Infof("\tEach worker will generate %d time series:", seriesPerWorker())
 	for _, gen := range generatorsArr {
 		log.Infof("\t\t- %s", gen.ToString())
 	}
+	if mode != "agent" {
+		maxWorkers := maxStagesWorkers(loadStages)
+		log.Infof("\tTotal cardinality: up to %d time series with %d workers, not counting the ones created by churn", seriesPerWorker()*maxWorkers, maxWorkers)
+	}
 }
 
-func handleStats(statsChan <-chan emissionStat) {
-	accumulation := statsPrintToPushRatio * workersCount
+// handleStats aggregates the stats pushed by workers and prints them every statsPrintInterval.
+// A new stage received on stageChan closes the current print window so that each report covers a single stage.
+// Once stageChan is closed, the last window is printed and statsDone is closed.
+func handleStats(statsChan <-chan emissionStat, stageChan <-chan stageEvent, statsDone chan<- bool) {
+	printTicker := time.NewTicker(statsPrintInterval)
+	defer printTicker.Stop()
 
-	log.Infof("The stats print to push ratio is %d, so we'll accumulate %d data before printing.\n", statsPrintToPushRatio, accumulation)
-	for { // Keep reading from channel(s) forever
-		metricsSucessfullySentOverPrintWindow := int64(0)
-		metricsUnsucessfullySentOverPrintWindow := int64(0)
-		durationOverPrintWindow := time.Duration(0)
+	stage := ""
+	window := newStatsWindow()
 
-		for i := 0; i < accumulation; i++ { // Accumulate X stats structs to print average over all workers over the print duration
-			select {
-			// Need a control channel here
-			case stats := <-statsChan:
-				log.Tracef("Received %d/%d stats data\n", i, accumulation)
-				metricsSucessfullySentOverPrintWindow += stats.successfullySent
-				metricsUnsucessfullySentOverPrintWindow += stats.unsuccessfullySent
-				durationOverPrintWindow += stats.duration
+	log.Infof("Stats are pushed by workers every %s and printed every %s or at the end of each stage.\n", statsPushInterval, statsPrintInterval)
+	for { // Keep reading from channel(s) until the stages are over
+		select {
+		case stats := <-statsChan:
+			log.Tracef("Received stats data from worker %d\n", stats.workerNum)
+			window.add(stats)
+		case <-printTicker.C:
+			window.print(stage, int(atomic.LoadInt64(&activeWorkers)))
+			window = newStatsWindow()
+		case event, ok := <-stageChan:
+			// Drain the stats that were pushed before the stage boundary
+			for drained := false; !drained; {
+				select {
+				case stats := <-statsChan:
+					window.add(stats)
+				default:
+					drained = true
+				}
+			}
+			if !ok {
+				close(statsDone)
+				return
 			}
+
+			window.print(stage, event.previousWorkers)
+			window = newStatsWindow()
+			stage = event.name
 		}
+	}
+}
 
-		averageSuccessfulMPS := float64(metricsSucessfullySentOverPrintWindow) / durationOverPrintWindow.Seconds()
-		successRatio := float64(metricsSucessfullySentOverPrintWindow) / float64(metricsSucessfullySentOverPrintWindow+metricsUnsucessfullySentOverPrintWindow) * 100
+// pushStat hands stats over to the stats handler, waiting up to statsPushTimeout if the stats channel is full
+func pushStat(statsChan chan<- emissionStat, stats emissionStat) {
+	select {
+	case statsChan <- stats:
+		return
+	default:
+	}
 
-		// Accumulaton done, we can print an average
-		// TODO use logger
-		log.Infof("%d workers successfully sent an average of %.3f metrics per second. A total of %s metrics were successfully sent out of %s generated. Success sent ratio if %6.2f%%\n", workersCount, averageSuccessfulMPS, humanReadableNumber(metricsSucessfullySentOverPrintWindow), humanReadableNumber(metricsSucessfullySentOverPrintWindow+metricsUnsucessfullySentOverPrintWindow), successRatio)
-		// <Worker count>, <avg succ mps>, <total succ>, <total metrics>, <succ %>
-		log.Infof("MRS: %d,%.3f,%s,%s,%6.2f\n", workersCount, averageSuccessfulMPS, humanReadableNumber(metricsSucessfullySentOverPrintWindow), humanReadableNumber(metricsSucessfullySentOverPrintWindow+metricsUnsucessfullySentOverPrintWindow), successRatio)
+	select {
+	case statsChan <- stats:
+	case <-time.After(statsPushTimeout):
+		log.Error("Channel full, discarding stats")
 	}
 }
 
-func spawnWorker(id int, statsChan chan<- emissionStat) {
-	workerFullname := fmt.Sprintf("worker-%s-%d", stringPid, id)
+// stageEvent tells the stats handler that a new stage (or the end of the run) was reached
+type stageEvent struct {
+	name            string
+	previousWorkers int
+}
 
-	workerMetricNamespacePrefix := &metricNamespacePrefix
-	workerMetricNamespacePrefix = replaceOnlyIfRequired(workerMetricNamespacePrefix, "WORKERNUM", strconv.Itoa(id))
-	workerMetricNamespacePrefix = replaceOnlyIfRequired(workerMetricNamespacePrefix, "WORKERFULLNAME", workerFullname)
+type statsCounters struct {
+	metricsSucessfullySent   int64
+	metricsUnsucessfullySent int64
+	duration                 time.Duration
+	disorder                 disorderCounts
+}
 
-	workerMetricNamespaceSuffix := &metricNamespaceSuffix
-	workerMetricNamespaceSuffix = replaceOnlyIfRequired(workerMetricNamespaceSuffix, "WORKERNUM", strconv.Itoa(id))
-	workerMetricNamespaceSuffix = replaceOnlyIfRequired(workerMetricNamespaceSuffix, "WORKERFULLNAME", workerFullname)
+type statsWindow struct {
+	statsCounters
+	perTarget []statsCounters
 
-	var workerTags *string
-	workerTags = replaceOnlyIfRequired(&workersTags, "WORKERNUM", strconv.Itoa(id))
-	workerTags = replaceOnlyIfRequired(workerTags, "WORKERFULLNAME", workerFullname)
+	start          time.Time
+	churnedAtStart int64
+}
 
-	var workerPublisher publisher.Publisher
-
-	switch format {
-	case "atlas":
-		switch protocol {
-		case "http":
-			workerPublisher = publisher.NewHttpPublisher(endpoint)
-		case "dry-run":
-			workerPublisher = publisher.NewLogPublisher("na")
-		}
-	case "carbon":
-		switch protocol {
-		case "tcp":
-			workerPublisher = publisher.NewTcpPublisher(endpoint)
-		case "dry-run":
-			workerPublisher = publisher.NewLogPublisher("na")
-		}
-	case "carbon-pickle":
-		log.Fatal("Not supported yet")
-		switch protocol {
-		case "tcp":
-			workerPublisher = publisher.NewTcpPublisher(endpoint)
-		case "dry-run":
-			workerPublisher = publisher.NewLogPublisher("na")
-		}
-	case "influxdb":
-		switch protocol {
-		case "http":
-			workerPublisher = publisher.NewHttpPublisher(endpoint)
-		case "dry-run":
-			workerPublisher = publisher.NewLogPublisher("na")
-		}
-	case "m3db":
-		switch protocol {
-		case "http":
-			workerPublisher = publisher.NewHttpPublisher(endpoint)
-		case "dry-run":
-			workerPublisher = publisher.NewLogPublisher("na")
-		}
-	}
-
-	workerGeneratorsArr := cloneDefaultGenerators(workerMetricNamespacePrefix, workerMetricNamespaceSuffix, workerTags)
-
-	var metricsSucessfullyTotal int64
-	var metricsUnsucessfullyTotal int64
-	var metricsSucessfullyStats int64
-	var metricsUnsucessfullyStats int64
-	previousStatsTimestamp := time.Now()
-	metricTicker := time.Tick(intervalDuration)
+func newStatsWindow() statsWindow {
+	return statsWindow{perTarget: make([]statsCounters, len(targets)), start: time.Now(), churnedAtStart: atomic.LoadInt64(&churnedWorkers)}
+}
 
-	for {
-		select {
-		// Need a control channel here
-		case <-metricTicker:
-			var metricArr []*metric.Metric
-			// TODO replace
-			metricArr = make([]*metric.Metric, len(workerGeneratorsArr), len(workerGeneratorsArr))
-
-			for i, gen := range workerGeneratorsArr {
-				metricArr[i] = gen.GenerateMetric()
+func (c *statsCounters) add(stats emissionStat) {
+	c.metricsSucessfullySent += stats.successfullySent
+	c.metricsUnsucessfullySent += stats.unsuccessfullySent
+	c.duration += stats.duration
+	c.disorder.add(stats.disorder)
+}
+
+func (w *statsWindow) add(stats emissionStat) {
+	w.statsCounters.add(stats)
+	atomic.AddInt64(&totalSuccessfullySent, stats.successfullySent)
+	atomic.AddInt64(&totalUnsuccessfullySent, stats.unsuccessfullySent)
+	// In distributed mode, agents may have more targets than the coordinator knows of
+	if stats.target < len(w.perTarget) {
+		w.perTarget[stats.target].add(stats)
+	}
+}
+
+func (w *statsWindow) print(stage string, workers int) {
+	if w.duration == 0 {
+		return
+	}
+
+	averageSuccessfulMPS := float64(w.metricsSucessfullySent) / w.duration.Seconds()
+	successRatio := float64(w.metricsSucessfullySent) / float64(w.metricsSucessfullySent+w.metricsUnsucessfullySent) * 100
+
+	log.Infof("[%s] %d workers successfully sent an average of %.3f metrics per second. A total of %s metrics were successfully sent out of %s generated. Success sent ratio if %6.2f%%\n", stage, workers, averageSuccessfulMPS, humanReadableNumber(w.metricsSucessfullySent), humanReadableNumber(w.metricsSucessfullySent+w.metricsUnsucessfullySent), successRatio)
+	// <Stage>, <Worker count>, <avg succ mps>, <total succ>, <total metrics>, <succ %>
+	log.Infof("MRS: %s,%d,%.3f,%s,%s,%6.2f\n", stage, workers, averageSuccessfulMPS, humanReadableNumber(w.metricsSucessfullySent), humanReadableNumber(w.metricsSucessfullySent+w.metricsUnsucessfullySent), successRatio)
+
+	if len(targets) > 1 {
+		for i, c := range w.perTarget {
+			if c.duration == 0 {
+				continue
 			}
+			targetMPS := float64(c.metricsSucessfullySent) / c.duration.Seconds()
+			targetSuccessRatio := float64(c.metricsSucessfullySent) / float64(c.metricsSucessfullySent+c.metricsUnsucessfullySent) * 100
+			log.Infof("[%s] Target #%d (%s): an average of %.3f metrics per second per worker. A total of %s metrics were successfully sent out of %s generated. Success sent ratio if %6.2f%%\n", stage, i+1, targets[i], targetMPS, humanReadableNumber(c.metricsSucessfullySent), humanReadableNumber(c.metricsSucessfullySent+c.metricsUnsucessfullySent), targetSuccessRatio)
+		}
+	}
 
-			var publishErr error
-			formattedMetric := localFormatter.FormatData(&metricArr)
-			publishErr = workerPublisher.PublishBytes(formattedMetric)
+	if pointsDisorder.enabled() {
+		d := w.disorder
+		log.Infof("[%s] Disorder: %s late, %s future, %s duplicate and %s conflicting points were injected\n", stage, humanReadableNumber(d.Late), humanReadableNumber(d.Future), humanReadableNumber(d.Duplicates), humanReadableNumber(d.Conflicts))
+	}
 
-			if publishErr != nil {
-				metricsUnsucessfullyStats++
-			} else {
-				metricsSucessfullyStats++
+	if churnIntervalDuration > 0 {
+		replaced := atomic.LoadInt64(&churnedWorkers) - w.churnedAtStart
+		log.Infof("[%s] Churn: %d workers were replaced, creating %s new series. Churn rate of %.2f workers per minute\n", stage, replaced, humanReadableNumber(replaced*int64(seriesPerWorker())), churnRate(replaced, time.Since(w.start)))
+	}
+}
+
+func spawnWorker(id int, workerGeneratorsArr []generator.Generator, workerTargetsIndexes []int, interval time.Duration, statsChan chan<- emissionStat, stopChan <-chan bool, intervalChan <-chan time.Duration) {
+	// Publishers and tags formatters for each of the targets this worker publishes to
+	workerPublishers := make([]publisher.Publisher, len(workerTargetsIndexes))
+	workerTagsFormatters := make([]*tagsFormatter, len(workerTargetsIndexes))
+	for i, t := range workerTargetsIndexes {
+		workerPublishers[i] = targets[t].newPublisher()
+		workerTagsFormatters[i] = newTagsFormatter(targets[t].formatter)
+	}
+
+	workerSeries := seriesPerWorker()
+
+	// Stats are kept per target
+	metricsSucessfullyStats := make([]int64, len(workerTargetsIndexes))
+	metricsUnsucessfullyStats := make([]int64, len(workerTargetsIndexes))
+	// The injected points are counted once, whatever the number of targets they are published to
+	var disorderStats disorderCounts
+	disorderRandom := rand.New(rand.NewSource(generator.WorkerSeed(seed, id, len(workerGeneratorsArr))))
+	var restarter *workerRestarter
+	if workersRestarts.enabled() {
+		restarter = workersRestarts.newWorker(fmt.Sprintf("worker-%s-%d", stringPid, id), rand.New(rand.NewSource(generator.WorkerSeed(seed, id, len(workerGeneratorsArr)+1))))
+		for _, f := range workerTagsFormatters {
+			f.setExtraTags(restarter.tags())
+		}
+	}
+	previousStatsTimestamp := time.Now()
+
+	pushStats := func() {
+		newStatsTimestamp := time.Now()
+
+		for i, t := range workerTargetsIndexes {
+			pushStat(statsChan, emissionStat{workerNum: id, target: t, successfullySent: metricsSucessfullyStats[i], unsuccessfullySent: metricsUnsucessfullyStats[i], duration: newStatsTimestamp.Sub(previousStatsTimestamp), disorder: disorderStats})
+
+			metricsSucessfullyStats[i] = 0
+			metricsUnsucessfullyStats[i] = 0
+			// Sent with the stats of the first target only
+			disorderStats = disorderCounts{}
+		}
+		previousStatsTimestamp = newStatsTimestamp
+	}
+
+	// emit generates the metrics of the tick at now, publishes them and pushes the stats if it's time to. The clock of
+	// the generators is set beforehand.
+	emit := func(now time.Time) {
+		if restarter != nil && restarter.due(now) {
+			for _, gen := range workerGeneratorsArr {
+				generator.Restart(gen)
 			}
+			for _, f := range workerTagsFormatters {
+				f.setExtraTags(restarter.tags())
+			}
+			log.Debugf("Restarted worker-%s-%d", stringPid, id)
+		}
 
-			// Push stats every XXXXms
-			// TODO: Optimization: for really low sending interval, compute this every X ticks
-			if previousStatsTimestamp.Add(statsPushInterval).Before(time.Now()) {
-				metricsSucessfullyTotal += metricsSucessfullyStats
-				metricsUnsucessfullyTotal += metricsUnsucessfullyStats
+		var metricArr []*metric.Metric
+		// TODO replace
+		metricArr = make([]*metric.Metric, 0, workerSeries)
 
-				newStatsTimestamp := time.Now()
+		for _, gen := range workerGeneratorsArr {
+			metricArr = generator.AppendMetrics(metricArr, gen)
+		}
+		if pointsDisorder.enabled() {
+			metricArr = pointsDisorder.apply(metricArr, disorderRandom, &disorderStats)
+		}
 
-				select {
-				case statsChan <- emissionStat{workerNum: workersCount, successfullySent: metricsSucessfullyStats, unsuccessfullySent: metricsUnsucessfullyStats, duration: newStatsTimestamp.Sub(previousStatsTimestamp)}:
-				default:
-					log.Error("Channel full, discarding stats")
+		// The same metrics are published to every target of this worker. Nothing is published when every series
+		// of the worker skips the tick (eg: emitProbability or gaps)
+		if len(metricArr) > 0 {
+			for i, t := range workerTargetsIndexes {
+				targetMetricArr := workerTagsFormatters[i].format(metricArr)
+				formattedMetric := targets[t].formatter.FormatData(&targetMetricArr)
+				publishErr := workerPublishers[i].PublishBytes(formattedMetric)
+
+				if publishErr != nil {
+					metricsUnsucessfullyStats[i]++
+				} else {
+					metricsSucessfullyStats[i]++
 				}
+			}
+		}
+
+		// Push stats every XXXXms
+		// TODO: Optimization: for really low sending interval, compute this every X ticks
+		if previousStatsTimestamp.Add(statsPushInterval).Before(time.Now()) {
+			pushStats()
+		}
+	}
+
+	if backfillRun != nil {
+		backfillRun.runWorker(workerGeneratorsArr, emit, stopChan)
+		// Push the stats accumulated since the last push before exiting
+		pushStats()
+		return
+	}
 
-				metricsSucessfullyStats = 0
-				metricsUnsucessfullyStats = 0
-				previousStatsTimestamp = newStatsTimestamp
+	clock := newWorkerClock(interval)
+	for _, gen := range workerGeneratorsArr {
+		generator.SetClock(gen, clock.generators)
+	}
+
+	metricTicker := time.NewTicker(interval)
+	defer metricTicker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			// Push the stats accumulated since the last push before exiting
+			pushStats()
+			return
+		case newInterval := <-intervalChan:
+			metricTicker.Stop()
+			metricTicker = time.NewTicker(newInterval)
+			clock.setInterval(newInterval)
+		case <-metricTicker.C:
+			if atomic.LoadInt32(&workersPaused) == 1 {
+				// Keep pushing stats while paused so that the reports show the emission stopped
+				if previousStatsTimestamp.Add(statsPushInterval).Before(time.Now()) {
+					pushStats()
+				}
+				continue
 			}
+
+			clock.startTick()
+			emit(clock.generators.Now())
 		}
 	}
 }
 
-func cloneDefaultGenerators(workerMetricNamespacePrefix *string, workerMetricNamespaceSuffix *string, workerTags *string) []generator.Generator {
+// newWorkerGenerators clones the generators for the worker id. It's called from the goroutine spawning the workers, so
+// that the clones are made in the same order from one run to the other (eg: the series a replay worker replays).
+func newWorkerGenerators(id int) []generator.Generator {
+	workerFullname := fmt.Sprintf("worker-%s-%d", stringPid, id)
+
+	workerMetricNamespacePrefix := &metricNamespacePrefix
+	workerMetricNamespacePrefix = replaceOnlyIfRequired(workerMetricNamespacePrefix, "WORKERNUM", strconv.Itoa(id))
+	workerMetricNamespacePrefix = replaceOnlyIfRequired(workerMetricNamespacePrefix, "WORKERFULLNAME", workerFullname)
+
+	workerMetricNamespaceSuffix := &metricNamespaceSuffix
+	workerMetricNamespaceSuffix = replaceOnlyIfRequired(workerMetricNamespaceSuffix, "WORKERNUM", strconv.Itoa(id))
+	workerMetricNamespaceSuffix = replaceOnlyIfRequired(workerMetricNamespaceSuffix, "WORKERFULLNAME", workerFullname)
+
+	var workerTags *string
+	workerTags = replaceOnlyIfRequired(&workersTags, "WORKERNUM", strconv.Itoa(id))
+	workerTags = replaceOnlyIfRequired(workerTags, "WORKERFULLNAME", workerFullname)
+
+	return cloneDefaultGenerators(id, workerMetricNamespacePrefix, workerMetricNamespaceSuffix, workerTags)
+}
+
+func cloneDefaultGenerators(id int, workerMetricNamespacePrefix *string, workerMetricNamespaceSuffix *string, workerTags *string) []generator.Generator {
 	var workerGeneratorsArr []generator.Generator
 	workerGeneratorsArr = make([]generator.Generator, len(generatorsArr), len(generatorsArr))
 	for i, gen := range generatorsArr {
 		metricName := fmt.Sprintf("%s%s%s", *workerMetricNamespacePrefix, gen.GetName(), *workerMetricNamespaceSuffix)
 
 		workerMetricTags := replaceOnlyIfRequired(workerTags, "METRICNAME", metricName)
-		workerGeneratorsArr[i] = gen.Clone(metricName, localFormatter.FormatTags(workerMetricTags))
+		rawWorkerMetricTags := []byte(*workerMetricTags)
+		workerGeneratorsArr[i] = gen.Clone(metricName, &rawWorkerMetricTags)
+		generator.Seed(workerGeneratorsArr[i], generator.WorkerSeed(seed, id, i))
 	}
 
-	return workerGeneratorsArr
+	return generator.Bind(workerGeneratorsArr, generatorNames())
+}
+
+// seriesPerWorker returns the number of series each worker generates at every tick
+func seriesPerWorker() int {
+	series := 0
+	for _, gen := range generatorsArr {
+		series += generator.SeriesCount(gen)
+	}
+	return series
 }
 
 func replaceOnlyIfRequired(source *string, old string, new string) *string {
@@ -12,4 +12,28 @@ type Metric struct {
 	Tags      *[]byte
 	Value     *[]byte
 	Timestamp *int64
+	// Histogram is only set by generators that aggregate samples into an exponential histogram. Formats that support
+	// it send the histogram, the others send Value.
+	Histogram *ExponentialHistogram
+	// Stale is set for staleness markers, which tell that the series disappeared. Formats that support it flag the
+	// point as having no value, the others send Value (NaN).
+	Stale bool
+}
+
+// ExponentialHistogram is a sparse exponential histogram, as in OTLP ExponentialHistogram and Prometheus native
+// histograms. The bucket at index i counts the values in (base^i, base^(i+1)] where base = 2^(2^-Scale). Values whose
+// absolute value is 0 are counted in ZeroCount and negative values in the negative buckets, by absolute value.
+type ExponentialHistogram struct {
+	Scale          int32
+	Count          uint64
+	Sum            float64
+	Min            float64
+	Max            float64
+	ZeroCount      uint64
+	PositiveOffset int32 // Index of the first bucket of PositiveCounts
+	PositiveCounts []uint64
+	NegativeOffset int32 // Index of the first bucket of NegativeCounts
+	NegativeCounts []uint64
+	// Start of the interval the samples were drawn over, in nanoseconds
+	StartTimestamp int64
 }
@@ -17,7 +17,7 @@ func NewLogPublisher(endpoint string) Publisher {
 // PublishMetrics prints all the passed metrics to the logger
 func (p *logPublisher) PublishMetrics(metrics *[]*metric.Metric) error {
 	for _, m := range *metrics {
-		fmt.Printf("%i %s[%s][%s]=%v", m.Timestamp, string(*m.Name), string(*m.Tags), string(*m.Tags), string(*m.Metadata.Tags), m.Value)
+		fmt.Printf("%d %s[%s][%s]=%s\n", *m.Timestamp, string(*m.Name), string(*m.Tags), string(*m.Metadata.Tags), string(*m.Value))
 	}
 
 	return nil
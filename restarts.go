@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// restarts simulates the restarts of the processes the workers stand for, to test how the TSDB and its queries (eg:
+// rate() or increase()) handle counter resets: all the counters of a worker go back to their initial value together,
+// at random (probability at each tick) and/or on a schedule (every, each worker being shifted randomly in the
+// schedule). With tag, the series of each worker are tagged with <tag>=<worker full name>-<restarts>, so that each
+// incarnation of the process has its own series like with a real instance or pod tag.
+type restarts struct {
+	probability float64
+	every       time.Duration
+	tag         string
+}
+
+// workerRestarter decides when a worker restarts, each worker has its own
+type workerRestarter struct {
+	restarts *restarts
+	count    int
+	next     time.Time // Time of the next scheduled restart, zero until the first tick
+	random   *rand.Rand
+	instance string
+}
+
+func (r *restarts) enabled() bool {
+	return r.probability > 0 || r.every > 0
+}
+
+// validate checks the restarts parameters, tagKeys being the keys of the tags the series already have
+func (r *restarts) validate(tagKeys []string) error {
+	if r.probability < 0 || r.probability > 1 {
+		return errors.New("Invalid restartProbability specified. Make sure it's a probability between 0 and 1")
+	}
+	if r.every < 0 {
+		return errors.New("Invalid restartEvery specified. Make sure it's a duration greater or equal than 0")
+	}
+	if strings.ContainsAny(r.tag, "=, ") {
+		return errors.New("Invalid restartTag specified. Make sure it's a tag key, without '=', ',' or spaces")
+	}
+	if len(r.tag) > 0 && !r.enabled() {
+		return errors.New("Invalid restartTag specified. Make sure restartProbability or restartEvery is set too")
+	}
+	for _, key := range tagKeys {
+		if key == r.tag && len(r.tag) > 0 {
+			return fmt.Errorf("Invalid restartTag specified. Make sure it's not already the key of a tag of the series (%s)", key)
+		}
+	}
+	return nil
+}
+
+func (r *restarts) String() string {
+	var parts []string
+	if r.probability > 0 {
+		parts = append(parts, fmt.Sprintf("with a probability of %.4f%% per tick", r.probability*100))
+	}
+	if r.every > 0 {
+		parts = append(parts, fmt.Sprintf("every %s", r.every))
+	}
+	description := "workers restart " + strings.Join(parts, " and ")
+	if len(r.tag) > 0 {
+		description += fmt.Sprintf(", changing their %s tag", r.tag)
+	}
+	return description
+}
+
+func (r *restarts) newWorker(instance string, random *rand.Rand) *workerRestarter {
+	return &workerRestarter{restarts: r, random: random, instance: instance}
+}
+
+// due returns whether the worker restarts at now, it's called once per tick
+func (w *workerRestarter) due(now time.Time) bool {
+	due := false
+	if every := w.restarts.every; every > 0 {
+		if w.next.IsZero() {
+			w.next = now.Add(time.Duration(w.random.Int63n(int64(every))) + 1)
+		} else if !now.Before(w.next) {
+			// Restart once even if several restarts were scheduled since the previous tick (eg: backfill steps
+			// longer than every)
+			due = true
+			w.next = w.next.Add((now.Sub(w.next)/every + 1) * every)
+		}
+	}
+	if w.restarts.probability > 0 && w.random.Float64() < w.restarts.probability {
+		due = true
+	}
+
+	if due {
+		w.count++
+	}
+	return due
+}
+
+// tags returns the tag of the current incarnation of the worker, empty without restartTag
+func (w *workerRestarter) tags() string {
+	if len(w.restarts.tag) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s=%s-%d", w.restarts.tag, w.instance, w.count)
+}
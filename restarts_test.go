@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestRestartsValidate(t *testing.T) {
+	tests := []struct {
+		restarts restarts
+		tagKeys  []string
+		err      string
+	}{
+		{restarts: restarts{}},
+		{restarts: restarts{probability: 0.01, every: time.Minute, tag: "pod"}},
+		{restarts: restarts{probability: 1.5}, err: "Invalid restartProbability"},
+		{restarts: restarts{probability: -0.1}, err: "Invalid restartProbability"},
+		{restarts: restarts{every: -time.Second}, err: "Invalid restartEvery"},
+		{restarts: restarts{every: time.Minute, tag: "pod=a"}, err: "Invalid restartTag"},
+		{restarts: restarts{tag: "pod"}, err: "Make sure restartProbability or restartEvery is set too"},
+		{restarts: restarts{every: time.Minute, tag: "pod"}, tagKeys: []string{"node", "region"}},
+		{restarts: restarts{every: time.Minute}, tagKeys: []string{"node", ""}},
+		{restarts: restarts{every: time.Minute, tag: "node"}, tagKeys: []string{"node", "region"}, err: "Make sure it's not already the key of a tag of the series (node)"},
+	}
+
+	for _, test := range tests {
+		err := test.restarts.validate(test.tagKeys)
+		if len(test.err) > 0 {
+			assert.ErrorContains(t, err, test.err, "%+v", test.restarts)
+		} else {
+			assert.NilError(t, err, "%+v", test.restarts)
+		}
+	}
+}
+
+func TestRestarterDue(t *testing.T) {
+	r := &restarts{every: 10 * time.Second, tag: "pod"}
+	w := r.newWorker("worker-1-0", rand.New(rand.NewSource(42)))
+	start := time.Unix(1600000000, 0)
+
+	// The first tick schedules the first restart, shifted randomly within every
+	assert.Assert(t, !w.due(start))
+	first := w.next
+	assert.Assert(t, first.After(start) && !first.After(start.Add(r.every)))
+	assert.Equal(t, "pod=worker-1-0-0", w.tags())
+
+	tests := []struct {
+		elapsed time.Duration // Since the first scheduled restart
+		due     bool
+		count   int
+		next    time.Duration // Since the first scheduled restart
+	}{
+		{elapsed: -time.Millisecond, due: false, count: 0, next: 0},
+		{elapsed: 0, due: true, count: 1, next: 10 * time.Second},
+		{elapsed: 5 * time.Second, due: false, count: 1, next: 10 * time.Second},
+		{elapsed: 12 * time.Second, due: true, count: 2, next: 20 * time.Second},
+		// Several restarts fell between two ticks (eg: a long backfill step): a single restart, and the schedule
+		// catches up
+		{elapsed: 55 * time.Second, due: true, count: 3, next: 60 * time.Second},
+		{elapsed: 59 * time.Second, due: false, count: 3, next: 60 * time.Second},
+		{elapsed: 60 * time.Second, due: true, count: 4, next: 70 * time.Second},
+		{elapsed: 100 * time.Second, due: true, count: 5, next: 110 * time.Second},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.due, w.due(first.Add(test.elapsed)), "tick at %s", test.elapsed)
+		assert.Equal(t, test.count, w.count, "tick at %s", test.elapsed)
+		assert.Equal(t, first.Add(test.next), w.next, "tick at %s", test.elapsed)
+	}
+	assert.Equal(t, "pod=worker-1-0-5", w.tags())
+
+	// Without tag, the series don't change
+	r.tag = ""
+	assert.Equal(t, "", w.tags())
+}
+
+func TestRestarterProbability(t *testing.T) {
+	r := &restarts{probability: 0.1}
+	w := r.newWorker("worker-1-0", rand.New(rand.NewSource(42)))
+	now := time.Unix(1600000000, 0)
+	ticks := 10000
+	for i := 0; i < ticks; i++ {
+		w.due(now)
+		now = now.Add(time.Second)
+	}
+	assert.Assert(t, w.count > ticks*9/100 && w.count < ticks*11/100, "%d restarts out of %d ticks", w.count, ticks)
+}
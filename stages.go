@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Load stages are declared with the -stages flag as a comma-delimited list of <kind>:<duration>[:<workers>[:<interval>]]
+//  - ramp: linearly add or stop workers from the current count up (or down) to <workers> over <duration>
+//  - step: immediately go to <workers> and hold for <duration>
+//  - hold: keep the current workers for <duration>
+// The optional <interval> changes the send interval of all workers when the stage starts.
+// Eg: 'ramp:5m:200,hold:10m,step:1m:500,step:5m:50'
+
+const stagesTickInterval = 100 * time.Millisecond
+
+type loadStage struct {
+	kind     string
+	duration time.Duration
+	workers  int           // -1 means: keep the current worker count
+	interval time.Duration // 0 means: keep the current send interval
+}
+
+type stagePlan struct {
+	stages     []loadStage
+	current    int
+	stageStart time.Time
+	// Worker count when the current stage started, ramps go from this value to the stage's workers
+	fromWorkers int
+	// When true, the last stage is held forever instead of ending the run
+	holdLastStage bool
+}
+
+func parseStages(s string) ([]loadStage, error) {
+	var stages []loadStage
+
+	for i, token := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(token), ":")
+		if len(parts) < 2 || len(parts) > 4 {
+			return nil, fmt.Errorf("Error parsing stage #%d '%s': expected <kind>:<duration>[:<workers>[:<interval>]]", i+1, token)
+		}
+
+		stage := loadStage{kind: parts[0], workers: -1}
+
+		d, err := time.ParseDuration(parts[1])
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("Error parsing stage #%d duration '%s', it must be a >= 0 Go Duration", i+1, parts[1])
+		}
+		stage.duration = d
+
+		if len(parts) >= 3 && len(parts[2]) > 0 {
+			w, err := strconv.Atoi(parts[2])
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("Error parsing stage #%d workers '%s', it must be a >= 0 integer", i+1, parts[2])
+			}
+			stage.workers = w
+		}
+
+		if len(parts) == 4 {
+			iv, err := time.ParseDuration(parts[3])
+			if err != nil || iv <= 0 {
+				return nil, fmt.Errorf("Error parsing stage #%d interval '%s', it must be a > 0 Go Duration", i+1, parts[3])
+			}
+			stage.interval = iv
+		}
+
+		switch stage.kind {
+		case "ramp", "step":
+			if stage.workers < 0 {
+				return nil, fmt.Errorf("Stage #%d (%s) requires a number of workers", i+1, stage.kind)
+			}
+		case "hold":
+			if stage.workers >= 0 {
+				return nil, fmt.Errorf("Stage #%d (hold) doesn't accept a number of workers, use 'step' instead", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("Invalid kind '%s' for stage #%d, must be one of 'ramp', 'step' or 'hold'", stage.kind, i+1)
+		}
+
+		stages = append(stages, stage)
+	}
+
+	return stages, nil
+}
+
+// legacyStages emulates the -workers and -workersInterval flags: start one worker every workersInterval and then run forever
+func legacyStages(workers int, workersInterval time.Duration) []loadStage {
+	return []loadStage{
+		{kind: "ramp", duration: time.Duration(workers) * workersInterval, workers: workers},
+		{kind: "hold", workers: -1},
+	}
+}
+
+func (s loadStage) String() string {
+	var sb strings.Builder
+	switch s.kind {
+	case "ramp":
+		sb.WriteString(fmt.Sprintf("ramp to %d workers over %s", s.workers, s.duration))
+	case "step":
+		sb.WriteString(fmt.Sprintf("step to %d workers for %s", s.workers, s.duration))
+	case "hold":
+		if s.duration == 0 {
+			sb.WriteString("hold until interrupted")
+		} else {
+			sb.WriteString(fmt.Sprintf("hold for %s", s.duration))
+		}
+	}
+	if s.interval > 0 {
+		sb.WriteString(fmt.Sprintf(" with a send interval of %s", s.interval))
+	}
+	return sb.String()
+}
+
+// stageName is how the stage is labelled in the logs and the stats report
+func (p *stagePlan) stageName() string {
+	if p.current >= len(p.stages) {
+		return "done"
+	}
+	return fmt.Sprintf("stage %d/%d (%s)", p.current+1, len(p.stages), p.stages[p.current].kind)
+}
+
+// start begins the current stage, returning the send interval to apply (0 if unchanged)
+func (p *stagePlan) start(now time.Time, currentWorkers int) time.Duration {
+	p.stageStart = now
+	p.fromWorkers = currentWorkers
+	return p.stages[p.current].interval
+}
+
+// desiredWorkers returns how many workers should be running at the given time for the current stage
+func (p *stagePlan) desiredWorkers(now time.Time) int {
+	stage := p.stages[p.current]
+
+	switch stage.kind {
+	case "ramp":
+		elapsed := now.Sub(p.stageStart)
+		if elapsed >= stage.duration {
+			return stage.workers
+		}
+		return p.fromWorkers + int(float64(stage.workers-p.fromWorkers)*float64(elapsed)/float64(stage.duration))
+	case "step":
+		return stage.workers
+	default:
+		return p.fromWorkers
+	}
+}
+
+// stageOver returns true if the current stage reached its duration and the plan can move to the next stage
+func (p *stagePlan) stageOver(now time.Time) bool {
+	if p.holdLastStage && p.current == len(p.stages)-1 {
+		return false
+	}
+	return now.Sub(p.stageStart) >= p.stages[p.current].duration
+}
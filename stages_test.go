@@ -0,0 +1,107 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestParseStages(t *testing.T) {
+	tests := []struct {
+		stages   string
+		expected []loadStage
+		err      string
+	}{
+		{
+			stages: "ramp:5m:200,hold:10m,step:1m:500:500ms,hold:0s",
+			expected: []loadStage{
+				{kind: "ramp", duration: 5 * time.Minute, workers: 200},
+				{kind: "hold", duration: 10 * time.Minute, workers: -1},
+				{kind: "step", duration: time.Minute, workers: 500, interval: 500 * time.Millisecond},
+				{kind: "hold", workers: -1},
+			},
+		},
+		{
+			stages:   " step:1s:0 , hold:1s::2s",
+			expected: []loadStage{{kind: "step", duration: time.Second, workers: 0}, {kind: "hold", duration: time.Second, workers: -1, interval: 2 * time.Second}},
+		},
+		{stages: "ramp", err: "Error parsing stage #1 'ramp'"},
+		{stages: "hold:1s,step:1s:2:1s:1", err: "Error parsing stage #2"},
+		{stages: "hold:soon", err: "Error parsing stage #1 duration 'soon'"},
+		{stages: "hold:-1s", err: "Error parsing stage #1 duration '-1s'"},
+		{stages: "step:1s:-2", err: "Error parsing stage #1 workers '-2'"},
+		{stages: "step:1s:many", err: "Error parsing stage #1 workers 'many'"},
+		{stages: "step:1s:2:0s", err: "Error parsing stage #1 interval '0s'"},
+		{stages: "ramp:1s", err: "Stage #1 (ramp) requires a number of workers"},
+		{stages: "step:1s:", err: "Stage #1 (step) requires a number of workers"},
+		{stages: "hold:1s:5", err: "Stage #1 (hold) doesn't accept a number of workers"},
+		{stages: "step:1s:1,jump:1s:2", err: "Invalid kind 'jump' for stage #2"},
+	}
+
+	for _, test := range tests {
+		stages, err := parseStages(test.stages)
+		if len(test.err) > 0 {
+			assert.ErrorContains(t, err, test.err, "stages %s", test.stages)
+			continue
+		}
+		assert.NilError(t, err, "stages %s", test.stages)
+		assert.Equal(t, len(test.expected), len(stages), "stages %s", test.stages)
+		for i := range stages {
+			assert.Equal(t, test.expected[i], stages[i], "stages %s", test.stages)
+		}
+	}
+}
+
+func TestStagePlan(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	plan := &stagePlan{stages: []loadStage{
+		{kind: "ramp", duration: 10 * time.Second, workers: 20},
+		{kind: "ramp", duration: 4 * time.Second, workers: 0, interval: time.Second},
+		{kind: "hold", duration: 2 * time.Second, workers: -1},
+		{kind: "step", duration: time.Second, workers: 7},
+	}}
+
+	tests := []struct {
+		stage    int
+		current  int
+		elapsed  time.Duration
+		expected int
+		over     bool
+	}{
+		// Ramp up from the 4 workers running when the stage started
+		{stage: 0, current: 4, elapsed: 0, expected: 4},
+		{stage: 0, current: 4, elapsed: 2500 * time.Millisecond, expected: 8},
+		{stage: 0, current: 4, elapsed: 5 * time.Second, expected: 12},
+		{stage: 0, current: 4, elapsed: 9999 * time.Millisecond, expected: 19},
+		{stage: 0, current: 4, elapsed: 10 * time.Second, expected: 20, over: true},
+		{stage: 0, current: 4, elapsed: time.Minute, expected: 20, over: true},
+		// Ramp down
+		{stage: 1, current: 20, elapsed: time.Second, expected: 15},
+		{stage: 1, current: 20, elapsed: 3 * time.Second, expected: 5},
+		{stage: 1, current: 20, elapsed: 4 * time.Second, expected: 0, over: true},
+		// Hold whatever was running
+		{stage: 2, current: 3, elapsed: time.Second, expected: 3},
+		{stage: 2, current: 3, elapsed: 2 * time.Second, expected: 3, over: true},
+		{stage: 3, current: 3, elapsed: 0, expected: 7},
+	}
+
+	for _, test := range tests {
+		plan.current = test.stage
+		interval := plan.start(start, test.current)
+		assert.Equal(t, plan.stages[test.stage].interval, interval)
+
+		now := start.Add(test.elapsed)
+		assert.Equal(t, test.expected, plan.desiredWorkers(now), "stage %d after %s", test.stage, test.elapsed)
+		assert.Equal(t, test.over, plan.stageOver(now), "stage %d after %s", test.stage, test.elapsed)
+	}
+
+	// The last stage is held forever with holdLastStage
+	plan.holdLastStage = true
+	plan.current = 3
+	plan.start(start, 1)
+	assert.Assert(t, !plan.stageOver(start.Add(time.Hour)))
+	assert.Equal(t, "stage 4/4 (step)", plan.stageName())
+	plan.current++
+	assert.Equal(t, "done", plan.stageName())
+}
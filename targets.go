@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aleveille/lagrande/formatter"
+	"github.com/aleveille/lagrande/metric"
+	"github.com/aleveille/lagrande/publisher"
+)
+
+// target is a TSDB the generated metrics are published to. A run can have several targets that either all receive
+// the same data ("mirror" mode) or each receive the data of a share of the workers ("split" mode).
+type target struct {
+	format   string
+	protocol string
+	endpoint string
+	weight   int
+
+	formatter formatter.Formatter
+}
+
+// targetsFlag collects the repeatable -target flag
+type targetsFlag []string
+
+func (f *targetsFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *targetsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// parseTarget parses a comma-delimited list of key=value, eg: format=carbon,protocol=tcp,endpoint=127.0.0.1:2003,weight=2
+func parseTarget(s string) (*target, error) {
+	t := &target{protocol: "auto", weight: 1}
+
+	for _, token := range strings.Split(s, ",") {
+		kv := strings.SplitN(token, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Error parsing target '%s': '%s' isn't of format key=value", s, token)
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+
+		switch key {
+		case "format":
+			t.format = value
+		case "protocol":
+			t.protocol = value
+		case "endpoint":
+			t.endpoint = value
+		case "weight":
+			w, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing target '%s': invalid weight '%s'", s, value)
+			}
+			t.weight = w
+		default:
+			return nil, fmt.Errorf("Error parsing target '%s': unknown key '%s', must be one of 'format', 'protocol', 'endpoint' or 'weight'", s, key)
+		}
+	}
+
+	if len(t.format) == 0 {
+		return nil, fmt.Errorf("Error parsing target '%s': the format is required", s)
+	}
+
+	return t, nil
+}
+
+func (t *target) String() string {
+	return fmt.Sprintf("%s over %s to %s", t.format, t.protocol, t.endpoint)
+}
+
+// processFormatAndProtocol validates the format --> protocol combination, picks the default endpoint and protocol
+// and initializes the formatter accordingly
+func (t *target) processFormatAndProtocol() error {
+	if t.protocol != "auto" && t.protocol != "tcp" && t.protocol != "udp" && t.protocol != "http" && t.protocol != "null" && t.protocol != "log" {
+		return errors.New("The specified protocol is invalid")
+	}
+	if t.protocol == "udp" {
+		return errors.New("The UDP protocol isn't supported yet")
+	}
+	if t.weight < 0 {
+		return errors.New("The target weight must be a >= 0 integer")
+	}
+
+	// The null and log protocols don't send anything and accept any format
+	anyProtocol := t.protocol == "null" || t.protocol == "log"
+
+	switch t.format {
+	case "atlas":
+		if t.protocol != "http" && t.protocol != "auto" && !anyProtocol {
+			return errors.New("Only the HTTP protocol is supported with Atlas")
+		}
+
+		t.formatter = formatter.NewAtlasFormatter()
+
+		if len(t.endpoint) == 0 {
+			t.endpoint = "http://127.0.0.1:7101/api/v1/publish"
+		}
+		if t.protocol == "auto" {
+			t.protocol = "http"
+		}
+	case "carbon":
+		if t.protocol == "http" {
+			return errors.New("The HTTP protocol isn't supported with Carbon")
+		}
+
+		t.formatter = formatter.NewCarbonFormatter()
+
+		if len(t.endpoint) == 0 {
+			t.endpoint = "127.0.0.1:2003"
+		}
+		if t.protocol == "auto" {
+			t.protocol = "tcp"
+		}
+	case "influxdb":
+		if t.protocol != "http" && t.protocol != "auto" && !anyProtocol {
+			return errors.New("Only the HTTP protocol is supported with InfluxDB")
+		}
+
+		t.formatter = formatter.NewInfluxdbFormatter()
+
+		if len(t.endpoint) == 0 {
+			t.endpoint = "http://127.0.0.1:8086/write?db=mydb"
+		}
+		if t.protocol == "auto" {
+			t.protocol = "http"
+		}
+	case "m3db":
+		if t.protocol != "http" && t.protocol != "auto" && !anyProtocol {
+			return errors.New("Only the HTTP protocol is supported with M3DB")
+		}
+
+		t.formatter = formatter.NewM3DBFormatter()
+
+		if len(t.endpoint) == 0 {
+			t.endpoint = "http://localhost:9003/writetagged"
+		}
+		if t.protocol == "auto" {
+			t.protocol = "http"
+		}
+	case "otlp":
+		if t.protocol != "http" && t.protocol != "auto" && !anyProtocol {
+			return errors.New("Only the HTTP protocol is supported with OTLP")
+		}
+
+		t.formatter = formatter.NewOTLPFormatter()
+
+		if len(t.endpoint) == 0 {
+			t.endpoint = "http://127.0.0.1:4318/v1/metrics"
+		}
+		if t.protocol == "auto" {
+			t.protocol = "http"
+		}
+	default:
+		return errors.New("The specified format is invalid")
+	}
+
+	return nil
+}
+
+// newPublisher returns a new publisher for the target. Each worker gets its own publishers.
+func (t *target) newPublisher() publisher.Publisher {
+	switch t.protocol {
+	case "http":
+		return publisher.NewHttpPublisher(t.endpoint)
+	case "tcp":
+		return publisher.NewTcpPublisher(t.endpoint)
+	case "log", "dry-run":
+		return publisher.NewLogPublisher("na")
+	default:
+		return publisher.NewNullPublisher("na")
+	}
+}
+
+// workerTargets returns the index of the targets the nth worker started by a pool publishes to: all the targets when
+// mirroring, or a single target picked by weighted round-robin when splitting. In distributed mode, each agent splits
+// its own workers: the worker numbers are strided across agents, round-robin on them could pick the same target for
+// all the workers of an agent.
+func workerTargets(n int) []int {
+	if targetsMode == "mirror" {
+		indexes := make([]int, len(targets))
+		for i := range targets {
+			indexes[i] = i
+		}
+		return indexes
+	}
+
+	totalWeight := 0
+	for _, t := range targets {
+		totalWeight += t.weight
+	}
+
+	slot := n % totalWeight
+	for i, t := range targets {
+		if slot < t.weight {
+			return []int{i}
+		}
+		slot -= t.weight
+	}
+	return []int{0}
+}
+
+// tagsFormatter formats the raw 'key=value,...' tags carried by generated metrics into the tags format of a target.
+// Generators keep the same tags pointers for their whole life, so the formatted tags are cached by pointer and the
+// formatting only happens once per series. It isn't thread-safe, each worker has its own.
+type tagsFormatter struct {
+	formatter  formatter.Formatter
+	tags       map[*[]byte]*[]byte
+	metadata   map[*metric.MetricStaticMetadata]*metric.MetricStaticMetadata
+	extraTags  string              // Appended to the tags of every series, eg: the tag changing when the worker restarts
+	seriesTags map[*[]byte]*[]byte // Tags of the series with extraTags
+}
+
+func newTagsFormatter(f formatter.Formatter) *tagsFormatter {
+	return &tagsFormatter{
+		formatter:  f,
+		tags:       make(map[*[]byte]*[]byte),
+		metadata:   make(map[*metric.MetricStaticMetadata]*metric.MetricStaticMetadata),
+		seriesTags: make(map[*[]byte]*[]byte),
+	}
+}
+
+// setExtraTags replaces the raw 'key=value,...' tags appended to the tags of every series
+func (f *tagsFormatter) setExtraTags(tags string) {
+	f.extraTags = tags
+	f.seriesTags = make(map[*[]byte]*[]byte)
+}
+
+func (f *tagsFormatter) formatTags(rawTags *[]byte) *[]byte {
+	formatted, ok := f.tags[rawTags]
+	if !ok {
+		var s string
+		if rawTags != nil {
+			s = string(*rawTags)
+		}
+		formatted = f.formatter.FormatTags(&s)
+		f.tags[rawTags] = formatted
+	}
+	return formatted
+}
+
+// formatSeriesTags formats the tags of a series, with the extra tags
+func (f *tagsFormatter) formatSeriesTags(rawTags *[]byte) *[]byte {
+	if len(f.extraTags) == 0 {
+		return f.formatTags(rawTags)
+	}
+	formatted, ok := f.seriesTags[rawTags]
+	if !ok {
+		s := f.extraTags
+		if rawTags != nil && len(*rawTags) > 0 {
+			s = string(*rawTags) + "," + s
+		}
+		formatted = f.formatter.FormatTags(&s)
+		f.seriesTags[rawTags] = formatted
+	}
+	return formatted
+}
+
+// format returns copies of the metrics with their tags formatted for the target
+func (f *tagsFormatter) format(metrics []*metric.Metric) []*metric.Metric {
+	formatted := make([]*metric.Metric, len(metrics))
+
+	for i, m := range metrics {
+		metadata, ok := f.metadata[m.Metadata]
+		if !ok {
+			metadataCopy := *m.Metadata
+			metadataCopy.Tags = f.formatTags(m.Metadata.Tags)
+			metadata = &metadataCopy
+			f.metadata[m.Metadata] = metadata
+		}
+
+		metricCopy := *m
+		metricCopy.Metadata = metadata
+		metricCopy.Tags = f.formatSeriesTags(m.Tags)
+		formatted[i] = &metricCopy
+	}
+
+	return formatted
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected target
+		err      string
+	}{
+		{target: "format=carbon", expected: target{format: "carbon", protocol: "auto", weight: 1}},
+		{target: "format=influxdb, protocol=http, endpoint=http://db:8086/write, weight=3", expected: target{format: "influxdb", protocol: "http", endpoint: "http://db:8086/write", weight: 3}},
+		{target: "format=carbon,weight=0", expected: target{format: "carbon", protocol: "auto", weight: 0}},
+		{target: "protocol=tcp", err: "the format is required"},
+		{target: "format=carbon,weight=heavy", err: "invalid weight 'heavy'"},
+		{target: "format=carbon,tcp", err: "'tcp' isn't of format key=value"},
+		{target: "format=carbon,port=2003", err: "unknown key 'port'"},
+	}
+
+	for _, test := range tests {
+		parsed, err := parseTarget(test.target)
+		if len(test.err) > 0 {
+			assert.ErrorContains(t, err, test.err, "target %s", test.target)
+			continue
+		}
+		assert.NilError(t, err, "target %s", test.target)
+		assert.Equal(t, test.expected, *parsed)
+	}
+}
+
+func TestWorkerTargets(t *testing.T) {
+	defer func(previousTargets []*target, previousMode string) {
+		targets = previousTargets
+		targetsMode = previousMode
+	}(targets, targetsMode)
+
+	tests := []struct {
+		mode     string
+		weights  []int
+		expected [][]int // Targets of the workers 0, 1, 2...
+	}{
+		{mode: "mirror", weights: []int{1, 0, 2}, expected: [][]int{{0, 1, 2}, {0, 1, 2}}},
+		{mode: "split", weights: []int{1}, expected: [][]int{{0}, {0}, {0}}},
+		{mode: "split", weights: []int{1, 1}, expected: [][]int{{0}, {1}, {0}, {1}}},
+		{mode: "split", weights: []int{2, 1}, expected: [][]int{{0}, {0}, {1}, {0}, {0}, {1}}},
+		// Targets with a weight of 0 get no worker
+		{mode: "split", weights: []int{0, 3, 0, 1}, expected: [][]int{{1}, {1}, {1}, {3}, {1}, {1}, {1}, {3}}},
+		{mode: "split", weights: []int{1, 0}, expected: [][]int{{0}, {0}, {0}}},
+	}
+
+	for _, test := range tests {
+		targetsMode = test.mode
+		targets = nil
+		for _, weight := range test.weights {
+			targets = append(targets, &target{weight: weight})
+		}
+
+		for worker, expected := range test.expected {
+			assert.DeepEqual(t, expected, workerTargets(worker))
+		}
+	}
+
+	// In distributed mode, the worker numbers are strided across agents but each agent splits its workers on its own
+	targetsMode = "split"
+	targets = []*target{{weight: 1}, {weight: 1}, {weight: 2}}
+	for agent := 0; agent < 4; agent++ {
+		pool := &workerPool{idStride: 4, idOffset: agent}
+		perTarget := make([]int, len(targets))
+		for n := 0; n < 8; n++ {
+			assert.Equal(t, n*4+agent, pool.workerID(n))
+			perTarget[workerTargets(n)[0]]++
+		}
+		assert.DeepEqual(t, []int{2, 2, 4}, perTarget)
+	}
+}
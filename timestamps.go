@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aleveille/lagrande/generator"
+)
+
+// How the workers timestamp their points, with -timestamps:
+//  - generation: each generator reads the clock when it generates its points
+//  - batch: the worker reads the clock once per tick, all the points of the tick share the same timestamp
+//  - aligned: like batch, truncated to a multiple of the interval so that the points line up on step boundaries
+// -clockOffset shifts every timestamp, eg: to simulate hosts whose clock is skewed.
+
+// workerClock is the clock of the generators of a worker
+type workerClock struct {
+	generators generator.Clock         // What the generators of the worker read
+	tick       *generator.VirtualClock // Set at every tick in batch and aligned modes, nil in generation mode
+	source     generator.Clock         // What the tick time is read from
+}
+
+func processTimestampsConfiguration() error {
+	if timestampsMode != "generation" && timestampsMode != "batch" && timestampsMode != "aligned" {
+		return errors.New("Invalid timestamps specified. Make sure it's \"generation\", \"batch\" or \"aligned\"")
+	}
+
+	var err error
+	clockOffsetDuration, err = time.ParseDuration(clockOffset)
+	if err != nil {
+		return errors.New("Invalid clockOffset specified. Make sure it's a duration parsable by Go library: https://golang.org/pkg/time/#ParseDuration")
+	}
+	return nil
+}
+
+// withClockOffset returns clock shifted by -clockOffset
+func withClockOffset(clock generator.Clock) generator.Clock {
+	if clockOffsetDuration == 0 {
+		return clock
+	}
+	return generator.NewOffsetClock(clock, clockOffsetDuration)
+}
+
+func newWorkerClock(interval time.Duration) *workerClock {
+	c := &workerClock{generators: generator.RealClock}
+	if timestampsMode != "generation" {
+		c.tick = generator.NewVirtualClock(time.Now())
+		c.generators = c.tick
+		c.setInterval(interval)
+	}
+	c.generators = withClockOffset(c.generators)
+	return c
+}
+
+// setInterval aligns the ticks on the new interval in aligned mode
+func (c *workerClock) setInterval(interval time.Duration) {
+	c.source = generator.RealClock
+	if timestampsMode == "aligned" {
+		c.source = generator.NewAlignedClock(generator.RealClock, interval)
+	}
+}
+
+// startTick reads the time of the tick in batch and aligned modes
+func (c *workerClock) startTick() {
+	if c.tick != nil {
+		c.tick.Set(c.source.Now())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+
+	"github.com/aleveille/lagrande/generator"
+)
+
+func TestProcessTimestampsConfiguration(t *testing.T) {
+	defer func(previousMode, previousOffset string, previousDuration time.Duration) {
+		timestampsMode = previousMode
+		clockOffset = previousOffset
+		clockOffsetDuration = previousDuration
+	}(timestampsMode, clockOffset, clockOffsetDuration)
+
+	tests := []struct {
+		mode     string
+		offset   string
+		expected time.Duration
+		err      string
+	}{
+		{mode: "generation", offset: "0s"},
+		{mode: "batch", offset: "1m30s", expected: 90 * time.Second},
+		{mode: "aligned", offset: "-2h", expected: -2 * time.Hour},
+		{mode: "tick", offset: "0s", err: "Invalid timestamps specified"},
+		{mode: "batch", offset: "later", err: "Invalid clockOffset specified"},
+	}
+
+	for _, test := range tests {
+		timestampsMode = test.mode
+		clockOffset = test.offset
+		clockOffsetDuration = 0
+		err := processTimestampsConfiguration()
+		if len(test.err) > 0 {
+			assert.ErrorContains(t, err, test.err, "%+v", test)
+			continue
+		}
+		assert.NilError(t, err, "%+v", test)
+		assert.Equal(t, test.expected, clockOffsetDuration)
+	}
+}
+
+func TestWorkerClock(t *testing.T) {
+	defer func(previousMode string, previousDuration time.Duration) {
+		timestampsMode = previousMode
+		clockOffsetDuration = previousDuration
+	}(timestampsMode, clockOffsetDuration)
+
+	tests := []struct {
+		mode   string
+		offset time.Duration
+	}{
+		{mode: "generation"},
+		{mode: "generation", offset: time.Hour},
+		{mode: "batch"},
+		{mode: "batch", offset: -time.Hour},
+		{mode: "aligned"},
+		{mode: "aligned", offset: 3 * time.Second},
+	}
+
+	interval := 10 * time.Second
+	for _, test := range tests {
+		timestampsMode = test.mode
+		clockOffsetDuration = test.offset
+		c := newWorkerClock(interval)
+
+		before := time.Now()
+		c.startTick()
+		first := c.generators.Now().Add(-test.offset)
+		after := time.Now()
+
+		switch test.mode {
+		case "generation":
+			// The generators read the wall clock, every time
+			assert.Assert(t, c.tick == nil)
+			assert.Assert(t, !first.Before(before) && !first.After(time.Now()), "%+v: %s", test, first)
+			time.Sleep(time.Millisecond)
+			assert.Assert(t, c.generators.Now().Add(-test.offset).After(first), "%+v", test)
+		case "batch":
+			// The time of the tick, until the next tick
+			assert.Assert(t, !first.Before(before) && !first.After(after), "%+v: %s", test, first)
+			time.Sleep(time.Millisecond)
+			assert.Assert(t, c.generators.Now().Add(-test.offset).Equal(first), "%+v", test)
+		case "aligned":
+			// The time of the tick, truncated to the interval
+			assert.Assert(t, first.Equal(before.Truncate(interval)) || first.Equal(after.Truncate(interval)), "%+v: %s", test, first)
+			assert.Assert(t, c.generators.Now().Add(-test.offset).Equal(first), "%+v", test)
+
+			// After an interval change, the ticks are aligned on the new interval
+			c.setInterval(time.Hour)
+			before = time.Now()
+			c.startTick()
+			after = time.Now()
+			aligned := c.generators.Now().Add(-test.offset)
+			assert.Assert(t, aligned.Equal(before.Truncate(time.Hour)) || aligned.Equal(after.Truncate(time.Hour)), "%+v: %s", test, aligned)
+		}
+	}
+}
+
+func TestWithClockOffset(t *testing.T) {
+	defer func(previousDuration time.Duration) {
+		clockOffsetDuration = previousDuration
+	}(clockOffsetDuration)
+
+	clock := generator.NewVirtualClock(time.Unix(1600000000, 0))
+	clockOffsetDuration = 0
+	assert.Equal(t, generator.Clock(clock), withClockOffset(clock))
+
+	clockOffsetDuration = -90 * time.Second
+	assert.Assert(t, withClockOffset(clock).Now().Equal(time.Unix(1600000000-90, 0)))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// workerHandle is what the main goroutine keeps around to control a running worker
+type workerHandle struct {
+	id           int
+	stopChan     chan bool
+	intervalChan chan time.Duration
+}
+
+// workerPool keeps track of the running workers. It isn't thread-safe and is meant to be used from the main goroutine only.
+type workerPool struct {
+	workers []*workerHandle
+	nextID  int
+	// In distributed mode, worker numbers are nextID*idStride+idOffset so that agents don't use the same numbers
+	idStride  int
+	idOffset  int
+	interval  time.Duration
+	statsChan chan<- emissionStat
+	wg        sync.WaitGroup
+}
+
+// Number of running workers, number of workers replaced by churn and whether the emission is paused (1) or not (0),
+// readable from any goroutine (eg: the stats handler)
+var (
+	activeWorkers  int64
+	churnedWorkers int64
+	workersPaused  int32
+)
+
+// workersScaler is what the load stages, the churn and the control of a run act on: a local pool of workers or, in
+// distributed mode, the coordinator spreading the workers across agents
+type workersScaler interface {
+	count() int
+	scaleTo(n int)
+	setInterval(interval time.Duration)
+	replace(n int)
+	setPaused(paused bool)
+	syncStats()
+	stopAll()
+}
+
+func newWorkerPool(interval time.Duration, statsChan chan<- emissionStat) *workerPool {
+	return &workerPool{interval: interval, statsChan: statsChan, idStride: 1}
+}
+
+func (p *workerPool) count() int {
+	return len(p.workers)
+}
+
+func (p *workerPool) spawn() {
+	w := &workerHandle{
+		id:           p.workerID(p.nextID),
+		stopChan:     make(chan bool),
+		intervalChan: make(chan time.Duration, 1),
+	}
+	workerTargetsIndexes := workerTargets(p.nextID)
+	p.nextID++
+	p.workers = append(p.workers, w)
+	workerGenerators := newWorkerGenerators(w.id)
+	atomic.StoreInt64(&activeWorkers, int64(len(p.workers)))
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		spawnWorker(w.id, workerGenerators, workerTargetsIndexes, p.interval, p.statsChan, w.stopChan, w.intervalChan)
+	}()
+	log.Infof("Launched worker-%s-%d", stringPid, w.id)
+}
+
+// workerID returns the worker number of the nth worker started by the pool
+func (p *workerPool) workerID(n int) int {
+	return n*p.idStride + p.idOffset
+}
+
+// stop the worker at the given index of the pool
+func (p *workerPool) stop(index int) {
+	w := p.workers[index]
+	close(w.stopChan)
+	p.workers = append(p.workers[:index], p.workers[index+1:]...)
+	atomic.StoreInt64(&activeWorkers, int64(len(p.workers)))
+	log.Infof("Stopped worker-%s-%d", stringPid, w.id)
+}
+
+// scaleTo starts or stops workers until n workers are running. The most recently started workers are stopped first.
+func (p *workerPool) scaleTo(n int) {
+	for p.count() < n {
+		p.spawn()
+	}
+	for p.count() > n {
+		p.stop(p.count() - 1)
+	}
+}
+
+// setInterval changes the send interval of all running and future workers
+func (p *workerPool) setInterval(interval time.Duration) {
+	if interval == p.interval {
+		return
+	}
+	p.interval = interval
+	for _, w := range p.workers {
+		// Only the latest interval matters, drop a pending one that the worker didn't pick up yet
+		select {
+		case <-w.intervalChan:
+		default:
+		}
+		w.intervalChan <- interval
+	}
+}
+
+// replace stops n randomly picked workers and starts n new ones in their place. The new workers get new ids so they
+// generate brand-new series, as if hosts went down and others came up.
+func (p *workerPool) replace(n int) {
+	if n > p.count() {
+		n = p.count()
+	}
+	for i := 0; i < n; i++ {
+		p.stop(rand.Intn(p.count()))
+	}
+	for i := 0; i < n; i++ {
+		p.spawn()
+	}
+	atomic.AddInt64(&churnedWorkers, int64(n))
+}
+
+// setPaused stops or resumes the metrics emission of all workers. Paused workers keep running and pushing stats.
+func (p *workerPool) setPaused(paused bool) {
+	var value int32
+	if paused {
+		value = 1
+	}
+	if atomic.SwapInt32(&workersPaused, value) != value {
+		if paused {
+			log.Info("Metrics emission paused")
+		} else {
+			log.Info("Metrics emission resumed")
+		}
+	}
+}
+
+// syncStats does nothing, local workers push their stats straight to the stats handler
+func (p *workerPool) syncStats() {}
+
+// stopAll stops every worker and waits for them to push their last stats
+func (p *workerPool) stopAll() {
+	p.scaleTo(0)
+	p.wg.Wait()
+}